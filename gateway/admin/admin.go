@@ -0,0 +1,151 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/sunnyyssh/designing-software-cw3/gateway/apikey"
+	"github.com/sunnyyssh/designing-software-cw3/gateway/cache"
+	"github.com/sunnyyssh/designing-software-cw3/gateway/config"
+	"github.com/sunnyyssh/designing-software-cw3/gateway/router"
+)
+
+// Handler serves gateway state to operators. It's served on a separate listener, which must not be exposed to clients.
+func Handler(m *config.Manager, logger *slog.Logger) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.Handle("GET /metrics", m.Metrics())
+
+	mux.HandleFunc("GET /upstreams", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, m.Router().Status(), logger)
+	})
+
+	mux.HandleFunc("PUT /split", func(w http.ResponseWriter, req *http.Request) {
+		var weights map[string]int
+		if err := json.NewDecoder(req.Body).Decode(&weights); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorBody{Error: "body must be object of group weights", Code: http.StatusBadRequest}, logger)
+			return
+		}
+
+		status, err := m.Router().SetWeights(req.URL.Query().Get("location"), weights)
+		switch {
+		case errors.Is(err, router.ErrLocationNotFound) || errors.Is(err, router.ErrNoSplit):
+			writeJSON(w, http.StatusNotFound, errorBody{Error: err.Error(), Code: http.StatusNotFound}, logger)
+			return
+		case err != nil:
+			writeJSON(w, http.StatusUnprocessableEntity, errorBody{Error: err.Error(), Code: http.StatusUnprocessableEntity}, logger)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, status, logger)
+	})
+
+	mux.HandleFunc("GET /config", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(m.Config())
+	})
+
+	mux.HandleFunc("GET /config/status", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, m.Status(), logger)
+	})
+
+	mux.HandleFunc("POST /config/reload", func(w http.ResponseWriter, req *http.Request) {
+		if err := m.Reload(); err != nil {
+			logger.ErrorContext(req.Context(), "config reload failed, old config is kept", "error", err)
+			writeJSON(w, http.StatusUnprocessableEntity, errorBody{Error: err.Error(), Code: http.StatusUnprocessableEntity}, logger)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, m.Status(), logger)
+	})
+
+	mux.HandleFunc("GET /cache", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, m.Cache().Stats(), logger)
+	})
+
+	mux.HandleFunc("DELETE /cache", func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		filter := cache.Filter{
+			Location:   query.Get("location"),
+			User:       query.Get("user"),
+			PathPrefix: query.Get("path_prefix"),
+		}
+
+		purged := m.Cache().Purge(filter)
+		logger.InfoContext(req.Context(), "cache purged", "filter", filter, "purged", purged)
+
+		writeJSON(w, http.StatusOK, map[string]int{"purged": purged}, logger)
+	})
+
+	if keys := m.APIKeys(); keys != nil {
+		handleAPIKeys(mux, keys, logger)
+	}
+
+	return mux
+}
+
+// handleAPIKeys serves issuing, listing and revoking API keys.
+func handleAPIKeys(mux *http.ServeMux, keys *apikey.Keyring, logger *slog.Logger) {
+	mux.HandleFunc("POST /api-keys", func(w http.ResponseWriter, req *http.Request) {
+		var issue apikey.IssueRequest
+		if err := json.NewDecoder(req.Body).Decode(&issue); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorBody{Error: "body must be API key request: " + err.Error(), Code: http.StatusBadRequest}, logger)
+			return
+		}
+
+		issued, err := keys.Issue(req.Context(), &issue, time.Now())
+		switch {
+		case errors.Is(err, apikey.ErrInvalid):
+			writeJSON(w, http.StatusUnprocessableEntity, errorBody{Error: err.Error(), Code: http.StatusUnprocessableEntity}, logger)
+			return
+		case err != nil:
+			logger.ErrorContext(req.Context(), "failed to issue API key", "error", err)
+			writeJSON(w, http.StatusInternalServerError, errorBody{Error: "failed to issue API key", Code: http.StatusInternalServerError}, logger)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, issued, logger)
+	})
+
+	mux.HandleFunc("GET /api-keys", func(w http.ResponseWriter, req *http.Request) {
+		list, err := keys.List(req.Context(), req.URL.Query().Get("owner"))
+		if err != nil {
+			logger.ErrorContext(req.Context(), "failed to list API keys", "error", err)
+			writeJSON(w, http.StatusInternalServerError, errorBody{Error: "failed to list API keys", Code: http.StatusInternalServerError}, logger)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, list, logger)
+	})
+
+	mux.HandleFunc("DELETE /api-keys/{id}", func(w http.ResponseWriter, req *http.Request) {
+		key, err := keys.Revoke(req.Context(), req.PathValue("id"), time.Now())
+		switch {
+		case errors.Is(err, apikey.ErrNotFound):
+			writeJSON(w, http.StatusNotFound, errorBody{Error: err.Error(), Code: http.StatusNotFound}, logger)
+			return
+		case err != nil:
+			logger.ErrorContext(req.Context(), "failed to revoke API key", "error", err)
+			writeJSON(w, http.StatusInternalServerError, errorBody{Error: "failed to revoke API key", Code: http.StatusInternalServerError}, logger)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, key, logger)
+	})
+}
+
+type errorBody struct {
+	Error string `json:"error"`
+	Code  int    `json:"code"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any, logger *slog.Logger) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Error("failed to write admin response", "error", err)
+	}
+}
@@ -0,0 +1,307 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Header is a request header API key is passed in.
+const Header = "X-API-Key"
+
+// HeaderKeyID tells upstreams which API key request is made with. Incoming values are always stripped.
+const HeaderKeyID = "X-API-Key-ID"
+
+// Scopes API keys may be granted.
+const (
+	ScopeOrdersRead   = "orders:read"
+	ScopeOrdersCreate = "orders:create"
+	ScopeAccountTopUp = "account:top_up"
+)
+
+var Scopes = []string{ScopeOrdersRead, ScopeOrdersCreate, ScopeAccountTopUp}
+
+var (
+	ErrNotFound = errors.New("API key not found")
+	// ErrInvalidKey means key is unknown, malformed, revoked or expired. Clients aren't told which.
+	ErrInvalidKey = errors.New("invalid API key")
+	ErrInvalid    = errors.New("invalid API key request")
+)
+
+// prefix tells API keys apart from other secrets, e.g. in leaked credentials scans.
+const prefix = "gw_"
+
+// Key is an API key without its secret. Secret is shown only once, when key is issued.
+type Key struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	// Owner is ID of user requests of the key are made on behalf of.
+	Owner  string   `json:"owner"`
+	Scopes []string `json:"scopes"`
+	// RateLimit limits requests of the key across all locations. Nil means only location limits apply.
+	RateLimit *RateLimit `json:"rate_limit,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	// ExpiresAt is nil for keys that don't expire.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	// Hash is SHA-256 of the secret, so stolen store doesn't give working keys.
+	Hash string `json:"-"`
+}
+
+type RateLimit struct {
+	Requests int      `json:"requests"`
+	Per      Duration `json:"per"`
+}
+
+// Duration is encoded as string, e.g. "1m30s".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+// HasAnyScope tells whether key has one of scopes. Empty scopes are always satisfied.
+func (k *Key) HasAnyScope(scopes []string) bool {
+	if len(scopes) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(k.Scopes, func(s string) bool { return slices.Contains(scopes, s) })
+}
+
+// Active tells whether key is accepted at now.
+func (k *Key) Active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// Store keeps API keys. Implementations shared between gateway instances share keys.
+type Store interface {
+	// Get returns key by ID or ErrNotFound.
+	Get(ctx context.Context, id string) (*Key, error)
+	// List returns keys of owner, or all keys if owner is empty, the newest first.
+	List(ctx context.Context, owner string) ([]Key, error)
+	Create(ctx context.Context, key *Key) error
+	// Revoke sets revocation time of key unless it's already revoked. It returns ErrNotFound for unknown key.
+	Revoke(ctx context.Context, id string, now time.Time) (*Key, error)
+}
+
+// cacheTTL is how long verified keys are kept in memory. Key revoked through another gateway instance
+// is accepted by this one for at most this long.
+const cacheTTL = 10 * time.Second
+
+type cached struct {
+	key       *Key
+	fetchedAt time.Time
+}
+
+// Keyring issues, verifies and revokes API keys of store.
+type Keyring struct {
+	store  Store
+	logger *slog.Logger
+
+	mu    sync.Mutex
+	cache map[string]cached
+}
+
+func NewKeyring(store Store, logger *slog.Logger) *Keyring {
+	return &Keyring{
+		store:  store,
+		logger: logger,
+		cache:  make(map[string]cached),
+	}
+}
+
+// IssueRequest describes a new key.
+type IssueRequest struct {
+	Name      string     `json:"name"`
+	Owner     string     `json:"owner"`
+	Scopes    []string   `json:"scopes"`
+	RateLimit *RateLimit `json:"rate_limit"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// Issued is a new key with its secret.
+type Issued struct {
+	// Secret is passed in X-API-Key header. It isn't stored, so it can't be shown again.
+	Secret string `json:"key"`
+	Key
+}
+
+func (r *IssueRequest) validate(now time.Time) error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrInvalid, fmt.Sprintf(format, args...)))
+	}
+
+	if r.Owner == "" || len(r.Owner) > 128 || strings.ContainsFunc(r.Owner, func(c rune) bool { return c <= ' ' || c > '~' }) {
+		add("owner must be user ID of up to 128 visible ASCII characters")
+	}
+
+	if len(r.Scopes) == 0 {
+		add("at least one scope is required")
+	}
+	for _, s := range r.Scopes {
+		if !slices.Contains(Scopes, s) {
+			add("unknown scope %q, must be one of %s", s, strings.Join(Scopes, ", "))
+		}
+	}
+
+	if rl := r.RateLimit; rl != nil && (rl.Requests <= 0 || rl.Per <= 0) {
+		add("rate_limit requests and per must be positive")
+	}
+
+	if r.ExpiresAt != nil && !r.ExpiresAt.After(now) {
+		add("expires_at must be in the future")
+	}
+
+	return errors.Join(errs...)
+}
+
+// Issue creates a new key. Returned secret is the only copy of it.
+func (k *Keyring) Issue(ctx context.Context, req *IssueRequest, now time.Time) (*Issued, error) {
+	if err := req.validate(now); err != nil {
+		return nil, err
+	}
+
+	id := randomHex(8)
+	secret := randomHex(32)
+
+	key := Key{
+		ID:        id,
+		Name:      req.Name,
+		Owner:     req.Owner,
+		Scopes:    slices.Compact(slices.Sorted(slices.Values(req.Scopes))),
+		RateLimit: req.RateLimit,
+		CreatedAt: now.UTC(),
+		Hash:      hash(secret),
+	}
+	if req.ExpiresAt != nil {
+		expiresAt := req.ExpiresAt.UTC()
+		key.ExpiresAt = &expiresAt
+	}
+
+	if err := k.store.Create(ctx, &key); err != nil {
+		return nil, err
+	}
+
+	k.logger.InfoContext(ctx, "API key issued", "id", key.ID, "owner", key.Owner, "scopes", key.Scopes)
+	return &Issued{Secret: prefix + id + "_" + secret, Key: key}, nil
+}
+
+func (k *Keyring) List(ctx context.Context, owner string) ([]Key, error) {
+	return k.store.List(ctx, owner)
+}
+
+// Revoke revokes key at once on this gateway instance, other instances stop accepting it within cacheTTL.
+func (k *Keyring) Revoke(ctx context.Context, id string, now time.Time) (*Key, error) {
+	key, err := k.store.Revoke(ctx, id, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	k.mu.Lock()
+	delete(k.cache, id)
+	k.mu.Unlock()
+
+	k.logger.InfoContext(ctx, "API key revoked", "id", id, "owner", key.Owner)
+	return key, nil
+}
+
+// Authenticate returns active key of secret. It returns ErrInvalidKey if there is none and store error if it failed.
+func (k *Keyring) Authenticate(ctx context.Context, secret string, now time.Time) (*Key, error) {
+	id, keySecret, ok := parse(secret)
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+
+	key, err := k.get(ctx, id, now)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hash(keySecret)), []byte(key.Hash)) != 1 || !key.Active(now) {
+		return nil, ErrInvalidKey
+	}
+
+	return key, nil
+}
+
+// get returns key from cache or store. Unknown keys aren't cached, so a new key works at once.
+func (k *Keyring) get(ctx context.Context, id string, now time.Time) (*Key, error) {
+	k.mu.Lock()
+	c, ok := k.cache[id]
+	k.mu.Unlock()
+
+	if ok && now.Sub(c.fetchedAt) < cacheTTL {
+		return c.key, nil
+	}
+
+	key, err := k.store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	k.mu.Lock()
+	// Expired entries are swept on the way, so cache doesn't keep keys of gone clients.
+	for cachedID, c := range k.cache {
+		if now.Sub(c.fetchedAt) >= cacheTTL {
+			delete(k.cache, cachedID)
+		}
+	}
+	k.cache[id] = cached{key: key, fetchedAt: now}
+	k.mu.Unlock()
+
+	return key, nil
+}
+
+// parse splits secret of form gw_<id>_<secret> into key ID and its secret.
+func parse(secret string) (id, keySecret string, ok bool) {
+	rest, ok := strings.CutPrefix(secret, prefix)
+	if !ok {
+		return "", "", false
+	}
+
+	id, keySecret, ok = strings.Cut(rest, "_")
+	if !ok || len(id) != 16 || len(keySecret) != 64 {
+		return "", "", false
+	}
+	return id, keySecret, true
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
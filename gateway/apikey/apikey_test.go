@@ -0,0 +1,74 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKeyring(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "keys.json")
+
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("new store: %s", err)
+	}
+	keys := NewKeyring(store, slog.Default())
+
+	if _, err := keys.Issue(ctx, &IssueRequest{Owner: "merchant", Scopes: []string{"orders:delete"}}, now); !errors.Is(err, ErrInvalid) {
+		t.Errorf("unknown scope: err = %v, want ErrInvalid", err)
+	}
+
+	expiresAt := now.Add(time.Hour)
+	issued, err := keys.Issue(ctx, &IssueRequest{
+		Owner:     "merchant",
+		Scopes:    []string{ScopeOrdersRead, ScopeOrdersCreate},
+		ExpiresAt: &expiresAt,
+	}, now)
+	if err != nil {
+		t.Fatalf("issue: %s", err)
+	}
+
+	key, err := keys.Authenticate(ctx, issued.Secret, now)
+	if err != nil {
+		t.Fatalf("authenticate: %s", err)
+	}
+	if key.Owner != "merchant" || !key.HasAnyScope([]string{ScopeOrdersRead}) || key.HasAnyScope([]string{ScopeAccountTopUp}) {
+		t.Errorf("key = %+v, want merchant's key with orders scopes", key)
+	}
+
+	forged := issued.Secret[:len(issued.Secret)-1] + "0"
+	if forged == issued.Secret {
+		forged = issued.Secret[:len(issued.Secret)-1] + "1"
+	}
+	if _, err := keys.Authenticate(ctx, forged, now); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("wrong secret: err = %v, want ErrInvalidKey", err)
+	}
+	if _, err := keys.Authenticate(ctx, issued.Secret, expiresAt); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("expired key: err = %v, want ErrInvalidKey", err)
+	}
+
+	// Keys survive restart, secrets aren't stored.
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("reopen store: %s", err)
+	}
+	if _, err := NewKeyring(reopened, slog.Default()).Authenticate(ctx, issued.Secret, now); err != nil {
+		t.Errorf("authenticate after reopen: %s", err)
+	}
+
+	if _, err := keys.Revoke(ctx, issued.ID, now); err != nil {
+		t.Fatalf("revoke: %s", err)
+	}
+	if _, err := keys.Authenticate(ctx, issued.Secret, now); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("revoked key: err = %v, want ErrInvalidKey", err)
+	}
+	if _, err := keys.Revoke(ctx, "0000000000000000", now); !errors.Is(err, ErrNotFound) {
+		t.Errorf("revoke unknown key: err = %v, want ErrNotFound", err)
+	}
+}
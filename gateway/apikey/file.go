@@ -0,0 +1,159 @@
+package apikey
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileKey is a key as it's written to file, with its hash.
+type fileKey struct {
+	Key
+	Hash string `json:"hash"`
+}
+
+// FileStore keeps keys in JSON file of single gateway instance. Every change rewrites the whole file,
+// so it suits a modest number of keys.
+type FileStore struct {
+	path string
+
+	mu   sync.Mutex
+	keys map[string]*Key
+}
+
+// NewFileStore reads keys from path. Missing file means there are no keys yet.
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path, keys: make(map[string]*Key)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []fileKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, err
+	}
+
+	for _, k := range keys {
+		key := k.Key
+		key.Hash = k.Hash
+		s.keys[key.ID] = &key
+	}
+
+	return s, nil
+}
+
+func (s *FileStore) Get(_ context.Context, id string) (*Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	res := *key
+	return &res, nil
+}
+
+func (s *FileStore) List(_ context.Context, owner string) ([]Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := make([]Key, 0, len(s.keys))
+	for _, key := range s.keys {
+		if owner == "" || key.Owner == owner {
+			res = append(res, *key)
+		}
+	}
+
+	slices.SortFunc(res, func(a, b Key) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return res, nil
+}
+
+func (s *FileStore) Create(_ context.Context, key *Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[key.ID]; ok {
+		return errors.New("API key ID is taken")
+	}
+
+	stored := *key
+	s.keys[key.ID] = &stored
+
+	if err := s.save(); err != nil {
+		delete(s.keys, key.ID)
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) Revoke(_ context.Context, id string, now time.Time) (*Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if key.RevokedAt != nil {
+		res := *key
+		return &res, nil
+	}
+
+	key.RevokedAt = &now
+	if err := s.save(); err != nil {
+		key.RevokedAt = nil
+		return nil, err
+	}
+
+	res := *key
+	return &res, nil
+}
+
+// save writes keys to temporary file and renames it, so crash never leaves file half written.
+func (s *FileStore) save() error {
+	keys := make([]fileKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, fileKey{Key: *key, Hash: key.Hash})
+	}
+	slices.SortFunc(keys, func(a, b fileKey) int { return strings.Compare(a.ID, b.ID) })
+
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
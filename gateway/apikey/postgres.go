@@ -0,0 +1,119 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS api_keys (
+		id VARCHAR(16) PRIMARY KEY,
+		name TEXT NOT NULL DEFAULT '',
+		owner VARCHAR(128) NOT NULL,
+		scopes TEXT[] NOT NULL,
+		rate_limit_requests INT NOT NULL DEFAULT 0,
+		rate_limit_per_ms BIGINT NOT NULL DEFAULT 0,
+		hash VARCHAR(64) NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		expires_at TIMESTAMPTZ,
+		revoked_at TIMESTAMPTZ
+	)`,
+
+	`CREATE INDEX IF NOT EXISTS api_keys_owner_idx ON api_keys (owner)`,
+}
+
+// PostgresStore keeps keys in PostgreSQL, so gateway instances share them.
+type PostgresStore struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgresStore creates keys table if there is none.
+func NewPostgresStore(ctx context.Context, db *pgxpool.Pool) (*PostgresStore, error) {
+	for _, migration := range migrations {
+		if _, err := db.Exec(ctx, migration); err != nil {
+			return nil, err
+		}
+	}
+	return &PostgresStore{db: db}, nil
+}
+
+const keyColumns = `id, name, owner, scopes, rate_limit_requests, rate_limit_per_ms, hash, created_at, expires_at, revoked_at`
+
+func (s *PostgresStore) Get(ctx context.Context, id string) (*Key, error) {
+	q := `SELECT ` + keyColumns + ` FROM api_keys WHERE id = $1`
+
+	key, err := scanKey(s.db.QueryRow(ctx, q, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return key, err
+}
+
+func (s *PostgresStore) List(ctx context.Context, owner string) ([]Key, error) {
+	q := `SELECT ` + keyColumns + ` FROM api_keys
+		WHERE ($1 = '' OR owner = $1)
+		ORDER BY created_at DESC, id`
+
+	rows, err := s.db.Query(ctx, q, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]Key, 0)
+	for rows.Next() {
+		key, err := scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *key)
+	}
+	return res, rows.Err()
+}
+
+func (s *PostgresStore) Create(ctx context.Context, key *Key) error {
+	q := `INSERT INTO api_keys (` + keyColumns + `) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+
+	var requests, perMS int64
+	if key.RateLimit != nil {
+		requests = int64(key.RateLimit.Requests)
+		perMS = time.Duration(key.RateLimit.Per).Milliseconds()
+	}
+
+	_, err := s.db.Exec(ctx, q,
+		key.ID, key.Name, key.Owner, key.Scopes, requests, perMS, key.Hash, key.CreatedAt, key.ExpiresAt, key.RevokedAt)
+	return err
+}
+
+func (s *PostgresStore) Revoke(ctx context.Context, id string, now time.Time) (*Key, error) {
+	q := `UPDATE api_keys SET revoked_at = COALESCE(revoked_at, $2) WHERE id = $1 RETURNING ` + keyColumns
+
+	key, err := scanKey(s.db.QueryRow(ctx, q, id, now))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return key, err
+}
+
+func scanKey(row pgx.Row) (*Key, error) {
+	var (
+		key      Key
+		requests int64
+		perMS    int64
+	)
+
+	err := row.Scan(&key.ID, &key.Name, &key.Owner, &key.Scopes, &requests, &perMS, &key.Hash,
+		&key.CreatedAt, &key.ExpiresAt, &key.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if requests > 0 {
+		key.RateLimit = &RateLimit{Requests: int(requests), Per: Duration(time.Duration(perMS) * time.Millisecond)}
+	}
+	return &key, nil
+}
@@ -0,0 +1,387 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+)
+
+// Headers gateway passes identity of verified caller in. Incoming values are always stripped.
+const (
+	HeaderUserID    = "X-User-ID"
+	HeaderUserRoles = "X-User-Roles"
+	// HeaderClientCN is subject common name of verified client certificate.
+	HeaderClientCN = "X-Client-Cert-CN"
+)
+
+var (
+	ErrNoToken      = errors.New("no bearer token")
+	ErrInvalidToken = errors.New("invalid token")
+	ErrNoClientCert = errors.New("no verified client certificate")
+)
+
+type Config struct {
+	// HS256Secret verifies HS256 tokens. Empty secret disables HS256.
+	HS256Secret []byte
+	// JWKSFile holds public keys verifying RS256 and ES256 tokens. It's reread when it changes,
+	// so keys are rotated by adding the new key, switching issuer to it and removing the old one later.
+	JWKSFile string
+	// ReloadInterval is how often JWKS file is checked for changes.
+	ReloadInterval time.Duration
+	// Issuer and Audience are checked unless they are empty.
+	Issuer   string
+	Audience string
+	// Leeway is allowed clock skew for exp and nbf claims.
+	Leeway time.Duration
+	// RolesClaim is a claim holding list of roles. Defaults to "roles".
+	RolesClaim string
+}
+
+// Rule is auth requirement of a location.
+type Rule struct {
+	// Required rejects requests without valid token.
+	Required bool
+	// Roles are roles any of which caller must have. Empty means any role. API keys have no roles.
+	Roles []string
+	// APIKeyScopes are scopes by method, any of which API key must have. Empty list accepts any key.
+	// API keys aren't accepted for methods not listed, nil ignores API keys.
+	APIKeyScopes map[string][]string
+	// ClientCert requires TLS client certificate in addition to other credentials. Nil doesn't require it.
+	ClientCert *ClientCert
+}
+
+// ClientCert accepts client certificates verified against client CA of gateway.
+type ClientCert struct {
+	// CommonNames are subject common names any of which certificate must have. Empty means any verified certificate.
+	CommonNames []string
+}
+
+// Verify returns subject common name of verified client certificate of connection.
+func (c *ClientCert) Verify(state *tls.ConnectionState) (string, error) {
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return "", ErrNoClientCert
+	}
+
+	name := state.VerifiedChains[0][0].Subject.CommonName
+	if len(c.CommonNames) > 0 && !slices.Contains(c.CommonNames, name) {
+		return "", fmt.Errorf("client certificate %q isn't allowed", name)
+	}
+	return name, nil
+}
+
+// Identity is a verified caller.
+type Identity struct {
+	Subject string
+	Roles   []string
+}
+
+// HasAnyRole tells whether identity has one of roles. Empty roles are always satisfied.
+func (id *Identity) HasAnyRole(roles []string) bool {
+	if len(roles) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(id.Roles, func(r string) bool { return slices.Contains(roles, r) })
+}
+
+type Verifier struct {
+	cfg    *Config
+	logger *slog.Logger
+
+	mu      sync.RWMutex
+	keys    []publicKey
+	modTime time.Time
+}
+
+func NewVerifier(cfg *Config, logger *slog.Logger) (*Verifier, error) {
+	if len(cfg.HS256Secret) == 0 && cfg.JWKSFile == "" {
+		return nil, errors.New("neither HS256 secret nor JWKS file is set")
+	}
+
+	v := &Verifier{cfg: cfg, logger: logger}
+
+	if cfg.JWKSFile != "" {
+		if _, err := v.reload(); err != nil {
+			return nil, err
+		}
+	}
+
+	return v, nil
+}
+
+// Run rereads JWKS file when it changes until ctx is done.
+func (v *Verifier) Run(ctx context.Context) error {
+	if v.cfg.JWKSFile == "" {
+		return nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-time.Tick(v.cfg.ReloadInterval):
+			if _, err := v.reload(); err != nil {
+				v.logger.ErrorContext(ctx, "reloading JWKS failed, old keys are kept", "error", err)
+			}
+		}
+	}
+}
+
+// reload rereads JWKS file if it's modified. It tells whether keys are changed.
+func (v *Verifier) reload() (bool, error) {
+	info, err := os.Stat(v.cfg.JWKSFile)
+	if err != nil {
+		return false, err
+	}
+
+	v.mu.RLock()
+	unchanged := info.ModTime().Equal(v.modTime)
+	v.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(v.cfg.JWKSFile)
+	if err != nil {
+		return false, err
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return false, err
+	}
+
+	v.mu.Lock()
+	v.keys = keys
+	v.modTime = info.ModTime()
+	v.mu.Unlock()
+
+	v.logger.Info("JWKS loaded", "file", v.cfg.JWKSFile, "keys", len(keys))
+	return true, nil
+}
+
+// Authenticate verifies bearer token of request.
+func (v *Verifier) Authenticate(req *http.Request) (*Identity, error) {
+	scheme, token, ok := strings.Cut(req.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return nil, ErrNoToken
+	}
+
+	return v.Verify(token, time.Now())
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify checks token signature and claims.
+func (v *Verifier) Verify(token string, now time.Time) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, fmt.Errorf("%w: header: %s", ErrInvalidToken, err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %s", ErrInvalidToken, err)
+	}
+
+	if err := v.verifySignature(&h, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidToken, err)
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %s", ErrInvalidToken, err)
+	}
+
+	id, err := v.checkClaims(claims, now)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidToken, err)
+	}
+
+	return id, nil
+}
+
+func (v *Verifier) verifySignature(h *header, signed, signature []byte) error {
+	digest := sha256.Sum256(signed)
+
+	switch h.Alg {
+	case AlgHS256:
+		if len(v.cfg.HS256Secret) == 0 {
+			return errors.New("HS256 tokens aren't accepted")
+		}
+
+		mac := hmac.New(sha256.New, v.cfg.HS256Secret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return errors.New("signature mismatch")
+		}
+		return nil
+
+	case AlgRS256, AlgES256:
+		keys := v.keysFor(h)
+		if len(keys) == 0 {
+			// Token may be signed with just rotated key.
+			if changed, _ := v.reload(); changed {
+				keys = v.keysFor(h)
+			}
+		}
+		if len(keys) == 0 {
+			return fmt.Errorf("no %s key with kid %q", h.Alg, h.Kid)
+		}
+
+		for _, k := range keys {
+			if verifyWith(k, digest[:], signature) {
+				return nil
+			}
+		}
+		return errors.New("signature mismatch")
+
+	default:
+		return fmt.Errorf("unsupported algorithm %q", h.Alg)
+	}
+}
+
+// keysFor returns keys token may be signed with. Token without kid is tried against every key of its algorithm.
+func (v *Verifier) keysFor(h *header) []publicKey {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	res := make([]publicKey, 0, 1)
+	for _, k := range v.keys {
+		if k.alg == h.Alg && (h.Kid == "" || k.kid == h.Kid) {
+			res = append(res, k)
+		}
+	}
+	return res
+}
+
+func verifyWith(k publicKey, digest, signature []byte) bool {
+	switch key := k.key.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, signature) == nil
+
+	case *ecdsa.PublicKey:
+		// JWS ES256 signature is r and s concatenated, not ASN.1.
+		if len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(key, digest, r, s)
+
+	default:
+		return false
+	}
+}
+
+func (v *Verifier) checkClaims(claims map[string]any, now time.Time) (*Identity, error) {
+	if exp, ok := numericClaim(claims, "exp"); !ok {
+		return nil, errors.New("exp claim is required")
+	} else if now.After(exp.Add(v.cfg.Leeway)) {
+		return nil, errors.New("token is expired")
+	}
+
+	if nbf, ok := numericClaim(claims, "nbf"); ok && now.Add(v.cfg.Leeway).Before(nbf) {
+		return nil, errors.New("token is not valid yet")
+	}
+
+	if v.cfg.Issuer != "" && claims["iss"] != v.cfg.Issuer {
+		return nil, errors.New("issuer mismatch")
+	}
+
+	if v.cfg.Audience != "" && !slices.Contains(stringsClaim(claims, "aud"), v.cfg.Audience) {
+		return nil, errors.New("audience mismatch")
+	}
+
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return nil, errors.New("sub claim is required")
+	}
+
+	rolesClaim := v.cfg.RolesClaim
+	if rolesClaim == "" {
+		rolesClaim = "roles"
+	}
+
+	return &Identity{
+		Subject: sub,
+		Roles:   stringsClaim(claims, rolesClaim),
+	}, nil
+}
+
+func numericClaim(claims map[string]any, name string) (time.Time, bool) {
+	val, ok := claims[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	f, err := val.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(int64(f), 0), true
+}
+
+// stringsClaim returns claim that is either a string or an array of strings.
+// Space separated string is split, as OAuth scope claim is.
+func stringsClaim(claims map[string]any, name string) []string {
+	switch val := claims[name].(type) {
+	case string:
+		return strings.Fields(val)
+
+	case []any:
+		res := make([]string, 0, len(val))
+		for _, item := range val {
+			if s, ok := item.(string); ok {
+				res = append(res, s)
+			}
+		}
+		return res
+
+	default:
+		return nil
+	}
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
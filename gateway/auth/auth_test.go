@@ -0,0 +1,344 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// signToken returns token with header alg and kid signed with key, which is []byte secret,
+// RSA or ECDSA private key. Nil key leaves signature empty.
+func signToken(t *testing.T, alg, kid string, key any, claims map[string]any) string {
+	t.Helper()
+
+	h := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		h["kid"] = kid
+	}
+
+	signed := encodeSegment(t, h) + "." + encodeSegment(t, claims)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch key := key.(type) {
+	case nil:
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:]); err != nil {
+			t.Fatalf("sign RS256: %s", err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatalf("sign ES256: %s", err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	default:
+		t.Fatalf("unsupported key %T", key)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func encodeSegment(t *testing.T, v any) string {
+	t.Helper()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshal segment: %s", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func encodeBigInt(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+func rsaJWK(kid string, key *rsa.PrivateKey) jwk {
+	return jwk{
+		Kty: "RSA", Kid: kid, Alg: AlgRS256, Use: "sig",
+		N: encodeBigInt(key.N), E: encodeBigInt(big.NewInt(int64(key.E))),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PrivateKey) jwk {
+	return jwk{
+		Kty: "EC", Kid: kid, Alg: AlgES256, Crv: "P-256",
+		X: encodeBigInt(key.X), Y: encodeBigInt(key.Y),
+	}
+}
+
+// writeJWKS replaces JWKS file and moves its modification time forward, so verifier sees the change
+// even if file system keeps coarse timestamps.
+func writeJWKS(t *testing.T, path string, keys ...jwk) {
+	t.Helper()
+
+	data, err := json.Marshal(map[string][]jwk{"keys": keys})
+	if err != nil {
+		t.Fatalf("marshal JWKS: %s", err)
+	}
+
+	var modTime time.Time
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime().Add(time.Second)
+	} else {
+		modTime = time.Now()
+	}
+
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("write JWKS: %s", err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("touch JWKS: %s", err)
+	}
+}
+
+// claims returns valid claims of admin user changed by overrides. Nil value removes claim.
+func claims(overrides ...map[string]any) map[string]any {
+	c := map[string]any{
+		"sub":   "user",
+		"exp":   testNow.Add(time.Hour).Unix(),
+		"roles": []string{"admin"},
+	}
+	for _, o := range overrides {
+		for name, val := range o {
+			if val == nil {
+				delete(c, name)
+			} else {
+				c[name] = val
+			}
+		}
+	}
+	return c
+}
+
+func TestVerify(t *testing.T) {
+	secret := []byte("test secret")
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate RSA key: %s", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate EC key: %s", err)
+	}
+	otherRSAKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate RSA key: %s", err)
+	}
+
+	rsaPublic, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatalf("marshal RSA public key: %s", err)
+	}
+	rsaPublicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rsaPublic})
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, rsaJWK("rsa", rsaKey), ecJWK("ec", ecKey))
+
+	cfg := &Config{
+		HS256Secret: secret,
+		JWKSFile:    path,
+		Issuer:      "issuer",
+		Audience:    "gateway",
+		Leeway:      30 * time.Second,
+	}
+	v, err := NewVerifier(cfg, slog.Default())
+	if err != nil {
+		t.Fatalf("new verifier: %s", err)
+	}
+
+	jwksOnly, err := NewVerifier(&Config{JWKSFile: path}, slog.Default())
+	if err != nil {
+		t.Fatalf("new JWKS verifier: %s", err)
+	}
+
+	valid := map[string]any{"iss": "issuer", "aud": "gateway"}
+	with := func(overrides map[string]any) map[string]any { return claims(valid, overrides) }
+
+	for _, tt := range []struct {
+		name     string
+		verifier *Verifier
+		token    string
+		wantErr  bool
+	}{
+		{"HS256", v, signToken(t, AlgHS256, "", secret, with(nil)), false},
+		{"RS256", v, signToken(t, AlgRS256, "rsa", rsaKey, with(nil)), false},
+		{"RS256 without kid", v, signToken(t, AlgRS256, "", rsaKey, with(nil)), false},
+		{"ES256", v, signToken(t, AlgES256, "ec", ecKey, with(nil)), false},
+
+		{"HS256 wrong secret", v, signToken(t, AlgHS256, "", []byte("other"), with(nil)), true},
+		{"RS256 unknown key", v, signToken(t, AlgRS256, "rsa", otherRSAKey, with(nil)), true},
+		{"RS256 unknown kid", v, signToken(t, AlgRS256, "missing", rsaKey, with(nil)), true},
+		{"RS256 header with EC key", v, signToken(t, AlgRS256, "ec", ecKey, with(nil)), true},
+		{"ES256 header with RSA key", v, signToken(t, AlgES256, "rsa", rsaKey, with(nil)), true},
+
+		{"alg none", v, signToken(t, "none", "", nil, with(nil)), true},
+		{"alg empty", v, signToken(t, "", "", nil, with(nil)), true},
+		{"HS256 signed with RSA public key", v, signToken(t, AlgHS256, "rsa", rsaPublicPEM, with(nil)), true},
+		{"HS256 without secret configured", jwksOnly, signToken(t, AlgHS256, "rsa", rsaPublicPEM, claims()), true},
+
+		{"expired", v, signToken(t, AlgHS256, "", secret, with(map[string]any{"exp": testNow.Add(-time.Minute).Unix()})), true},
+		{"expired within leeway", v, signToken(t, AlgHS256, "", secret, with(map[string]any{"exp": testNow.Add(-10 * time.Second).Unix()})), false},
+		{"no exp", v, signToken(t, AlgHS256, "", secret, with(map[string]any{"exp": nil})), true},
+		{"not valid yet", v, signToken(t, AlgHS256, "", secret, with(map[string]any{"nbf": testNow.Add(time.Minute).Unix()})), true},
+		{"nbf within leeway", v, signToken(t, AlgHS256, "", secret, with(map[string]any{"nbf": testNow.Add(10 * time.Second).Unix()})), false},
+
+		{"wrong issuer", v, signToken(t, AlgHS256, "", secret, with(map[string]any{"iss": "other"})), true},
+		{"audience in list", v, signToken(t, AlgHS256, "", secret, with(map[string]any{"aud": []string{"other", "gateway"}})), false},
+		{"wrong audience", v, signToken(t, AlgHS256, "", secret, with(map[string]any{"aud": "other"})), true},
+		{"no sub", v, signToken(t, AlgHS256, "", secret, with(map[string]any{"sub": nil})), true},
+
+		{"malformed", v, "not.a-token", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := tt.verifier.Verify(tt.token, testNow)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Fatalf("err = %v, want ErrInvalidToken", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verify: %s", err)
+			}
+			if id.Subject != "user" || !id.HasAnyRole([]string{"admin"}) {
+				t.Errorf("identity = %+v, want user with admin role", id)
+			}
+		})
+	}
+}
+
+func TestVerifyReloadsRotatedKeys(t *testing.T) {
+	oldKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %s", err)
+	}
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %s", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, ecJWK("old", oldKey))
+
+	// Interval is never reached, keys are reloaded only when token has unknown kid.
+	v, err := NewVerifier(&Config{JWKSFile: path, ReloadInterval: time.Hour}, slog.Default())
+	if err != nil {
+		t.Fatalf("new verifier: %s", err)
+	}
+
+	oldToken := signToken(t, AlgES256, "old", oldKey, claims())
+	newToken := signToken(t, AlgES256, "new", newKey, claims())
+
+	if _, err := v.Verify(oldToken, testNow); err != nil {
+		t.Fatalf("old key: %s", err)
+	}
+	if _, err := v.Verify(newToken, testNow); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("new key before rotation: err = %v, want ErrInvalidToken", err)
+	}
+
+	// Issuer adds new key and switches to it.
+	writeJWKS(t, path, ecJWK("old", oldKey), ecJWK("new", newKey))
+
+	if _, err := v.Verify(newToken, testNow); err != nil {
+		t.Fatalf("new key after rotation: %s", err)
+	}
+	if _, err := v.Verify(oldToken, testNow); err != nil {
+		t.Fatalf("old key during rotation: %s", err)
+	}
+
+	// Old key is removed later.
+	writeJWKS(t, path, ecJWK("new", newKey))
+	if _, err := v.reload(); err != nil {
+		t.Fatalf("reload: %s", err)
+	}
+
+	if _, err := v.Verify(oldToken, testNow); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("removed key: err = %v, want ErrInvalidToken", err)
+	}
+	if _, err := v.Verify(newToken, testNow); err != nil {
+		t.Errorf("new key after removal of old one: %s", err)
+	}
+
+	// Broken file keeps loaded keys.
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatalf("write JWKS: %s", err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatalf("touch JWKS: %s", err)
+	}
+	if _, err := v.reload(); err == nil {
+		t.Error("reload of broken JWKS succeeded")
+	}
+	if _, err := v.Verify(newToken, testNow); err != nil {
+		t.Errorf("new key after broken reload: %s", err)
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %s", err)
+	}
+
+	offCurve := ecJWK("bad", ecKey)
+	offCurve.Y = encodeBigInt(new(big.Int).Add(ecKey.Y, big.NewInt(1)))
+
+	encryption := ecJWK("enc", ecKey)
+	encryption.Use = "enc"
+
+	for _, tt := range []struct {
+		name     string
+		keys     []jwk
+		wantKeys int
+		wantErr  bool
+	}{
+		{"signing key", []jwk{ecJWK("ec", ecKey)}, 1, false},
+		{"encryption key is skipped", []jwk{encryption}, 0, false},
+		{"point off curve", []jwk{offCurve}, 0, true},
+		{"unsupported curve", []jwk{{Kty: "EC", Crv: "P-384", X: "AQ", Y: "AQ"}}, 0, true},
+		{"unsupported RSA algorithm", []jwk{{Kty: "RSA", Alg: "RS512", N: "AQ", E: "AQAB"}}, 0, true},
+		{"unsupported key type", []jwk{{Kty: "oct"}}, 0, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(map[string][]jwk{"keys": tt.keys})
+			if err != nil {
+				t.Fatalf("marshal: %s", err)
+			}
+
+			keys, err := parseJWKS(data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+			if len(keys) != tt.wantKeys {
+				t.Errorf("keys = %d, want %d", len(keys), tt.wantKeys)
+			}
+		})
+	}
+}
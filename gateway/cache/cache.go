@@ -0,0 +1,210 @@
+package cache
+
+import (
+	"container/list"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxVariants bounds responses kept for a single URL differing by Vary headers.
+const maxVariants = 8
+
+type Config struct {
+	// MaxBytes bounds size of cached responses.
+	MaxBytes int64
+	// MaxEntryBytes is the largest response body that is cached.
+	MaxEntryBytes int64
+}
+
+// Policy enables cache for location.
+type Policy struct {
+	// TTL is how long responses without max-age are fresh.
+	TTL time.Duration
+}
+
+// Key identifies cached response. User is a part of it, so responses are never shared between users.
+type Key struct {
+	Location string
+	User     string
+	// URI is request path with query.
+	URI string
+}
+
+func (k Key) String() string {
+	return k.Location + "\x00" + k.User + "\x00" + k.URI
+}
+
+// Filter selects entries to purge. Empty fields match anything.
+type Filter struct {
+	Location   string `json:"location"`
+	User       string `json:"user"`
+	PathPrefix string `json:"path_prefix"`
+}
+
+func (f *Filter) matches(k Key) bool {
+	return (f.Location == "" || f.Location == k.Location) &&
+		(f.User == "" || f.User == k.User) &&
+		strings.HasPrefix(k.URI, f.PathPrefix)
+}
+
+type Stats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Stores    uint64 `json:"stores"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+	Bytes     int64  `json:"bytes"`
+}
+
+// item holds variants of a URL response.
+type item struct {
+	key      Key
+	variants []*Entry
+	size     int64
+}
+
+// Cache is a bounded in-memory LRU of responses.
+type Cache struct {
+	mu    sync.Mutex
+	cfg   Config
+	ll    *list.List
+	items map[string]*list.Element
+	size  int64
+
+	hits, misses, stores, evictions atomic.Uint64
+}
+
+func New(cfg Config) *Cache {
+	return &Cache{
+		cfg:   cfg,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// Resize applies new limits, evicting entries if cache doesn't fit anymore.
+func (c *Cache) Resize(cfg Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cfg = cfg
+	c.evict()
+}
+
+func (c *Cache) MaxEntryBytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.cfg.MaxEntryBytes
+}
+
+// Get returns fresh response of key matching Vary headers of request.
+func (c *Cache) Get(key Key, req *http.Request, now time.Time) *Entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key.String()]; ok {
+		it := el.Value.(*item)
+		for _, e := range it.variants {
+			if e.fresh(now) && e.matches(req) {
+				c.ll.MoveToFront(el)
+				c.hits.Add(1)
+				return e
+			}
+		}
+	}
+
+	c.misses.Add(1)
+	return nil
+}
+
+// Put stores response of key. Stale variants and one with the same Vary values are replaced.
+func (c *Cache) Put(key Key, e *Entry, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e.size() > c.cfg.MaxEntryBytes || e.size() > c.cfg.MaxBytes {
+		return
+	}
+
+	k := key.String()
+	el, ok := c.items[k]
+	if !ok {
+		el = c.ll.PushFront(&item{key: key})
+		c.items[k] = el
+	}
+	c.ll.MoveToFront(el)
+
+	it := el.Value.(*item)
+	it.variants = slices.DeleteFunc(it.variants, func(v *Entry) bool {
+		return !v.fresh(now) || slices.Equal(v.vary, e.vary)
+	})
+	if len(it.variants) >= maxVariants {
+		it.variants = it.variants[1:]
+	}
+	it.variants = append(it.variants, e)
+
+	c.size -= it.size
+	it.size = 0
+	for _, v := range it.variants {
+		it.size += v.size()
+	}
+	c.size += it.size
+
+	c.stores.Add(1)
+	c.evict()
+}
+
+// evict removes least recently used items until cache fits.
+func (c *Cache) evict() {
+	for c.size > c.cfg.MaxBytes {
+		el := c.ll.Back()
+		if el == nil {
+			return
+		}
+		c.remove(el)
+		c.evictions.Add(1)
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	it := el.Value.(*item)
+	c.ll.Remove(el)
+	delete(c.items, it.key.String())
+	c.size -= it.size
+}
+
+// Purge removes entries matching filter. It returns a number of removed URLs.
+func (c *Cache) Purge(f Filter) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for el := c.ll.Front(); el != nil; {
+		next := el.Next()
+		if f.matches(el.Value.(*item).key) {
+			c.remove(el)
+			n++
+		}
+		el = next
+	}
+	return n
+}
+
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return Stats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Stores:    c.stores.Load(),
+		Evictions: c.evictions.Load(),
+		Entries:   c.ll.Len(),
+		Bytes:     c.size,
+	}
+}
@@ -0,0 +1,312 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func newRequest(header ...string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/order/all", nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Add(header[i], header[i+1])
+	}
+	return req
+}
+
+func newResponse(header ...string) *http.Response {
+	resp := &http.Response{StatusCode: http.StatusOK, Header: make(http.Header)}
+	for i := 0; i+1 < len(header); i += 2 {
+		resp.Header.Add(header[i], header[i+1])
+	}
+	return resp
+}
+
+func newEntry(req *http.Request, resp *http.Response, body string) *Entry {
+	return NewEntry(req, resp, []byte(body), testNow, time.Minute)
+}
+
+func TestCacheKeyIsolatesUsers(t *testing.T) {
+	c := New(Config{MaxBytes: 1 << 20, MaxEntryBytes: 1 << 20})
+
+	alice := Key{Location: "orders", User: "alice", URI: "/order/all"}
+	bob := Key{Location: "orders", User: "bob", URI: "/order/all"}
+	anonymous := Key{Location: "orders", URI: "/order/all"}
+
+	c.Put(alice, newEntry(newRequest(), newResponse(), "alice's orders"), testNow)
+
+	if e := c.Get(alice, newRequest(), testNow); e == nil || string(e.Body) != "alice's orders" {
+		t.Fatalf("alice got %v, want her response", e)
+	}
+	if e := c.Get(bob, newRequest(), testNow); e != nil {
+		t.Errorf("bob got %q, want miss", e.Body)
+	}
+	if e := c.Get(anonymous, newRequest(), testNow); e != nil {
+		t.Errorf("anonymous got %q, want miss", e.Body)
+	}
+
+	if e := c.Get(alice, newRequest(), testNow.Add(time.Minute)); e != nil {
+		t.Errorf("stale response is served: %q", e.Body)
+	}
+
+	if s := c.Stats(); s.Hits != 1 || s.Misses != 3 || s.Stores != 1 {
+		t.Errorf("stats = %+v, want 1 hit, 3 misses, 1 store", s)
+	}
+}
+
+func TestCacheVary(t *testing.T) {
+	c := New(Config{MaxBytes: 1 << 20, MaxEntryBytes: 1 << 20})
+	key := Key{Location: "orders", URI: "/order/all"}
+
+	english := newRequest("Accept-Language", "en")
+	russian := newRequest("Accept-Language", "ru")
+	resp := newResponse("Vary", "accept-language, Accept-Encoding")
+
+	c.Put(key, newEntry(english, resp, "orders"), testNow)
+	c.Put(key, newEntry(russian, resp, "заказы"), testNow)
+
+	for _, tt := range []struct {
+		name string
+		req  *http.Request
+		want string
+	}{
+		{"english", newRequest("Accept-Language", "en"), "orders"},
+		{"russian", newRequest("Accept-Language", "ru"), "заказы"},
+		{"other language", newRequest("Accept-Language", "de"), ""},
+		{"other encoding", newRequest("Accept-Language", "en", "Accept-Encoding", "gzip"), ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if e := c.Get(key, tt.req, testNow); e != nil {
+				got = string(e.Body)
+			}
+			if got != tt.want {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// Response with the same Vary values replaces the old one.
+	c.Put(key, newEntry(english, resp, "new orders"), testNow)
+	if e := c.Get(key, english, testNow); e == nil || string(e.Body) != "new orders" {
+		t.Errorf("english got %v, want replaced response", e)
+	}
+	if e := c.Get(key, russian, testNow); e == nil || string(e.Body) != "заказы" {
+		t.Errorf("russian got %v, want its response kept", e)
+	}
+}
+
+func TestEntryServe(t *testing.T) {
+	req := newRequest()
+	e := newEntry(req, newResponse("Content-Type", "application/json"), `{"orders": []}`)
+	etag := e.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("entity tag isn't computed")
+	}
+
+	upstreamTagged := newEntry(req, newResponse("ETag", `W/"v2"`), `{}`)
+
+	for _, tt := range []struct {
+		name        string
+		entry       *Entry
+		ifNoneMatch string
+		wantCode    int
+	}{
+		{"no validator", e, "", http.StatusOK},
+		{"matching tag", e, etag, http.StatusNotModified},
+		{"tag in list", e, `"other", ` + etag, http.StatusNotModified},
+		{"weak tag", e, "W/" + etag, http.StatusNotModified},
+		{"any tag", e, "*", http.StatusNotModified},
+		{"other tag", e, `"other"`, http.StatusOK},
+		{"upstream weak tag", upstreamTagged, `"v2"`, http.StatusNotModified},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newRequest()
+			if tt.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			rec := httptest.NewRecorder()
+			tt.entry.Serve(rec, req, testNow.Add(5*time.Second))
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if got := rec.Header().Get("Age"); got != "5" {
+				t.Errorf("Age = %q, want 5", got)
+			}
+			if got := rec.Header().Get("X-Cache"); got != "HIT" {
+				t.Errorf("X-Cache = %q, want HIT", got)
+			}
+
+			wantBody := string(tt.entry.Body)
+			if tt.wantCode == http.StatusNotModified {
+				wantBody = ""
+			}
+			if rec.Body.String() != wantBody {
+				t.Errorf("body = %q, want %q", rec.Body, wantBody)
+			}
+		})
+	}
+}
+
+func TestTTL(t *testing.T) {
+	const defaultTTL = time.Minute
+
+	for _, tt := range []struct {
+		name string
+		resp *http.Response
+		want time.Duration
+	}{
+		{"default", newResponse(), defaultTTL},
+		{"max-age", newResponse("Cache-Control", "public, max-age=10"), 10 * time.Second},
+		{"s-maxage wins", newResponse("Cache-Control", "max-age=10, s-maxage=20"), 20 * time.Second},
+		{"private is cached per user", newResponse("Cache-Control", "private"), defaultTTL},
+		{"no-store", newResponse("Cache-Control", "no-store"), 0},
+		{"no-cache", newResponse("Cache-Control", "max-age=10", "Cache-Control", "No-Cache"), 0},
+		{"invalid max-age", newResponse("Cache-Control", "max-age=soon"), 0},
+		{"Set-Cookie", newResponse("Set-Cookie", "session=1"), 0},
+		{"Vary *", newResponse("Vary", "*"), 0},
+		{"event stream", newResponse("Content-Type", "text/event-stream"), 0},
+		{"not OK", &http.Response{StatusCode: http.StatusNotFound, Header: make(http.Header)}, 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TTL(tt.resp, defaultTTL); got != tt.want {
+				t.Errorf("TTL = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBypass(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		req    *http.Request
+		bypass bool
+	}{
+		{"plain", newRequest(), false},
+		{"max-age", newRequest("Cache-Control", "max-age=0"), false},
+		{"no-cache", newRequest("Cache-Control", "no-cache"), true},
+		{"no-store", newRequest("Cache-Control", "max-age=0, no-store"), true},
+		{"pragma", newRequest("Pragma", "no-cache"), true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Bypass(tt.req); got != tt.bypass {
+				t.Errorf("Bypass = %t, want %t", got, tt.bypass)
+			}
+		})
+	}
+}
+
+func TestCacheable(t *testing.T) {
+	post := httptest.NewRequest(http.MethodPost, "/order/all", strings.NewReader("{}"))
+
+	for _, tt := range []struct {
+		name      string
+		req       *http.Request
+		user      string
+		cacheable bool
+	}{
+		{"anonymous", newRequest(), "", true},
+		{"verified user", newRequest("Authorization", "Bearer token"), "alice", true},
+		{"unverified token", newRequest("Authorization", "Bearer token"), "", false},
+		{"unverified cookie", newRequest("Cookie", "session=1"), "", false},
+		{"POST", post, "alice", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Cacheable(tt.req, tt.user); got != tt.cacheable {
+				t.Errorf("Cacheable = %t, want %t", got, tt.cacheable)
+			}
+		})
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	entry := func() *Entry { return newEntry(newRequest(), newResponse(), strings.Repeat("x", 100)) }
+	size := entry().size()
+
+	// Three entries fit.
+	c := New(Config{MaxBytes: 3 * size, MaxEntryBytes: 2 * size})
+
+	keys := []Key{{URI: "/1"}, {URI: "/2"}, {URI: "/3"}, {URI: "/4"}}
+	for _, k := range keys[:3] {
+		c.Put(k, entry(), testNow)
+	}
+
+	// The first one becomes the most recently used, so the second is evicted.
+	if c.Get(keys[0], newRequest(), testNow) == nil {
+		t.Fatal("first entry is missing")
+	}
+	c.Put(keys[3], entry(), testNow)
+
+	for i, want := range []bool{true, false, true, true} {
+		if got := c.Get(keys[i], newRequest(), testNow) != nil; got != want {
+			t.Errorf("entry %s cached = %t, want %t", keys[i].URI, got, want)
+		}
+	}
+
+	s := c.Stats()
+	if s.Entries != 3 || s.Bytes != 3*size || s.Evictions != 1 {
+		t.Errorf("stats = %+v, want 3 entries of %d bytes and 1 eviction", s, 3*size)
+	}
+
+	// Entry larger than limit isn't stored.
+	c.Put(Key{URI: "/large"}, newEntry(newRequest(), newResponse(), strings.Repeat("x", int(2*size))), testNow)
+	if c.Get(Key{URI: "/large"}, newRequest(), testNow) != nil {
+		t.Error("entry larger than MaxEntryBytes is cached")
+	}
+
+	// Shrinking evicts.
+	c.Resize(Config{MaxBytes: size, MaxEntryBytes: size})
+	if s := c.Stats(); s.Entries != 1 || s.Bytes != size {
+		t.Errorf("stats after resize = %+v, want 1 entry", s)
+	}
+}
+
+func TestCachePurge(t *testing.T) {
+	keys := []Key{
+		{Location: "orders", User: "alice", URI: "/order/all"},
+		{Location: "orders", User: "alice", URI: "/order/1"},
+		{Location: "orders", User: "bob", URI: "/order/all"},
+		{Location: "products", URI: "/product/all"},
+	}
+
+	for _, tt := range []struct {
+		name       string
+		filter     Filter
+		wantPurged []bool
+	}{
+		{"everything", Filter{}, []bool{true, true, true, true}},
+		{"location", Filter{Location: "orders"}, []bool{true, true, true, false}},
+		{"user", Filter{User: "alice"}, []bool{true, true, false, false}},
+		{"path prefix", Filter{PathPrefix: "/order/a"}, []bool{true, false, true, false}},
+		{"all fields", Filter{Location: "orders", User: "bob", PathPrefix: "/order/"}, []bool{false, false, true, false}},
+		{"nothing", Filter{Location: "payments"}, []bool{false, false, false, false}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(Config{MaxBytes: 1 << 20, MaxEntryBytes: 1 << 20})
+			for _, k := range keys {
+				c.Put(k, newEntry(newRequest(), newResponse(), k.URI), testNow)
+			}
+
+			want := 0
+			for _, purged := range tt.wantPurged {
+				if purged {
+					want++
+				}
+			}
+			if n := c.Purge(tt.filter); n != want {
+				t.Errorf("purged = %d, want %d", n, want)
+			}
+
+			for i, k := range keys {
+				if cached := c.Get(k, newRequest(), testNow) != nil; cached == tt.wantPurged[i] {
+					t.Errorf("%+v cached = %t, want %t", k, cached, !tt.wantPurged[i])
+				}
+			}
+		})
+	}
+}
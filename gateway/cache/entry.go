@@ -0,0 +1,242 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry is a cached response.
+type Entry struct {
+	Status  int
+	Header  http.Header
+	Body    []byte
+	Stored  time.Time
+	Expires time.Time
+
+	// varyNames are headers response varies by, vary are their values in request it answered.
+	varyNames []string
+	vary      []string
+}
+
+// NewEntry builds entry of response and its body. Entity tag is computed if upstream hasn't set one,
+// so clients may revalidate.
+func NewEntry(req *http.Request, resp *http.Response, body []byte, now time.Time, ttl time.Duration) *Entry {
+	header := resp.Header.Clone()
+	header.Del("Content-Length")
+
+	if header.Get("ETag") == "" {
+		sum := sha256.Sum256(body)
+		header.Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	}
+
+	names := varyNames(resp.Header)
+
+	return &Entry{
+		Status:    resp.StatusCode,
+		Header:    header,
+		Body:      body,
+		Stored:    now,
+		Expires:   now.Add(ttl),
+		varyNames: names,
+		vary:      varyValues(req, names),
+	}
+}
+
+func (e *Entry) fresh(now time.Time) bool {
+	return now.Before(e.Expires)
+}
+
+func (e *Entry) matches(req *http.Request) bool {
+	return slices.Equal(e.vary, varyValues(req, e.varyNames))
+}
+
+// size estimates memory taken by entry.
+func (e *Entry) size() int64 {
+	n := int64(len(e.Body))
+	for key, vals := range e.Header {
+		n += int64(len(key))
+		for _, v := range vals {
+			n += int64(len(v))
+		}
+	}
+	return n
+}
+
+// Serve writes entry as response to request. Request with matching If-None-Match gets 304.
+func (e *Entry) Serve(w http.ResponseWriter, req *http.Request, now time.Time) {
+	h := w.Header()
+	for key, vals := range e.Header {
+		h[key] = append(h[key], vals...)
+	}
+	h.Set("Age", strconv.Itoa(int(now.Sub(e.Stored).Seconds())))
+	h.Set("X-Cache", "HIT")
+
+	if etagMatches(req.Header.Get("If-None-Match"), e.Header.Get("ETag")) {
+		h.Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	h.Set("Content-Length", strconv.Itoa(len(e.Body)))
+	w.WriteHeader(e.Status)
+	w.Write(e.Body)
+}
+
+// Cacheable tells whether response to request may be taken from or stored in cache.
+// Requests with credentials gateway hasn't verified may be answered differently by upstream, so they aren't cached.
+func Cacheable(req *http.Request, verifiedUser string) bool {
+	if req.Method != http.MethodGet {
+		return false
+	}
+	if verifiedUser == "" && (req.Header.Get("Authorization") != "" || req.Header.Get("Cookie") != "") {
+		return false
+	}
+	return true
+}
+
+// Bypass tells whether client asks for a response not taken from cache.
+func Bypass(req *http.Request) bool {
+	cc := directives(req.Header)
+	_, noCache := cc["no-cache"]
+	_, noStore := cc["no-store"]
+	return noCache || noStore || req.Header.Get("Pragma") == "no-cache"
+}
+
+// TTL returns how long response may be served from cache. Zero means it mustn't be stored.
+// Responses are stored per user, so private ones are cached too.
+func TTL(resp *http.Response, defaultTTL time.Duration) time.Duration {
+	if resp.StatusCode != http.StatusOK || len(resp.Trailer) > 0 || resp.Header.Get("Set-Cookie") != "" {
+		return 0
+	}
+	if slices.Contains(varyNames(resp.Header), "*") {
+		return 0
+	}
+	// Streams end only when client goes away.
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return 0
+	}
+
+	cc := directives(resp.Header)
+	for _, d := range []string{"no-store", "no-cache"} {
+		if _, ok := cc[d]; ok {
+			return 0
+		}
+	}
+
+	for _, d := range []string{"s-maxage", "max-age"} {
+		if val, ok := cc[d]; ok {
+			seconds, err := strconv.Atoi(val)
+			if err != nil || seconds < 0 {
+				return 0
+			}
+			return time.Duration(seconds) * time.Second
+		}
+	}
+
+	return defaultTTL
+}
+
+// directives parses Cache-Control header.
+func directives(h http.Header) map[string]string {
+	res := make(map[string]string)
+	for _, field := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(field, ",") {
+			name, val, _ := strings.Cut(textproto.TrimString(d), "=")
+			if name != "" {
+				res[strings.ToLower(name)] = strings.Trim(val, `"`)
+			}
+		}
+	}
+	return res
+}
+
+func varyNames(h http.Header) []string {
+	var names []string
+	for _, field := range h.Values("Vary") {
+		for _, name := range strings.Split(field, ",") {
+			if name = textproto.TrimString(name); name != "" {
+				names = append(names, textproto.CanonicalMIMEHeaderKey(name))
+			}
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+func varyValues(req *http.Request, names []string) []string {
+	values := make([]string, 0, len(names))
+	for _, name := range names {
+		values = append(values, strings.Join(req.Header.Values(name), ","))
+	}
+	return values
+}
+
+// etagMatches compares entity tags weakly, as If-None-Match requires.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" || etag == "" {
+		return false
+	}
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Recorder keeps a copy of body read through it, unless body is larger than limit.
+type Recorder struct {
+	body     io.ReadCloser
+	buf      bytes.Buffer
+	limit    int64
+	overflow bool
+	complete bool
+}
+
+func NewRecorder(body io.ReadCloser, limit int64) *Recorder {
+	return &Recorder{body: body, limit: limit}
+}
+
+func (r *Recorder) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+
+	if !r.overflow {
+		if int64(r.buf.Len()+n) > r.limit {
+			r.overflow = true
+			r.buf = bytes.Buffer{}
+		} else {
+			r.buf.Write(p[:n])
+		}
+	}
+
+	if errors.Is(err, io.EOF) {
+		r.complete = true
+	}
+	return n, err
+}
+
+func (r *Recorder) Close() error {
+	return r.body.Close()
+}
+
+// Body returns recorded body. It's false if body wasn't read till the end or was too large.
+func (r *Recorder) Body() ([]byte, bool) {
+	if !r.complete || r.overflow {
+		return nil, false
+	}
+	return r.buf.Bytes(), true
+}
@@ -0,0 +1,199 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Pair is a certificate chain and its private key in PEM files.
+type Pair struct {
+	CertFile string
+	KeyFile  string
+}
+
+type Config struct {
+	Pairs []Pair
+	// ClientCAFile holds CA certificates client certificates are verified with. Empty disables client certificates.
+	ClientCAFile string
+	// ReloadInterval is how often files are checked for changes.
+	ReloadInterval time.Duration
+}
+
+// loaded is a consistent set of certificates, it's replaced as a whole on reload.
+type loaded struct {
+	certs []*tls.Certificate
+	// byName maps lower case DNS names, including wildcard ones, to certificates.
+	byName    map[string]*tls.Certificate
+	clientCAs *x509.CertPool
+	// modTimes of files the set is read from.
+	modTimes map[string]time.Time
+}
+
+// Store serves certificates by SNI and rereads files when they change, so renewed certificates are picked up
+// without restart. Files that fail to load keep the old certificates, as renewal may write cert and key one by one.
+type Store struct {
+	cfg    *Config
+	logger *slog.Logger
+
+	mu  sync.RWMutex
+	set *loaded
+}
+
+func NewStore(cfg *Config, logger *slog.Logger) (*Store, error) {
+	if len(cfg.Pairs) == 0 {
+		return nil, errors.New("at least one certificate is required")
+	}
+
+	set, err := load(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{cfg: cfg, logger: logger, set: set}, nil
+}
+
+// Run rereads files when they change until ctx is done.
+func (s *Store) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-time.Tick(s.cfg.ReloadInterval):
+			if !s.changed() {
+				continue
+			}
+
+			set, err := load(s.cfg)
+			if err != nil {
+				s.logger.ErrorContext(ctx, "reloading certificates failed, old ones are kept", "error", err)
+				continue
+			}
+
+			s.mu.Lock()
+			s.set = set
+			s.mu.Unlock()
+
+			s.logger.InfoContext(ctx, "certificates reloaded", "names", len(set.byName))
+		}
+	}
+}
+
+// changed tells whether any file is modified since the set was read.
+func (s *Store) changed() bool {
+	s.mu.RLock()
+	modTimes := s.set.modTimes
+	s.mu.RUnlock()
+
+	for path, modTime := range modTimes {
+		info, err := os.Stat(path)
+		if err == nil && !info.ModTime().Equal(modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetCertificate picks certificate by server name: exact name first, then wildcard one.
+// Clients without SNI or with unknown name get the first certificate.
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	set := s.set
+	s.mu.RUnlock()
+
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if cert, ok := set.byName[name]; ok {
+		return cert, nil
+	}
+	if _, parent, ok := strings.Cut(name, "."); ok {
+		if cert, ok := set.byName["*."+parent]; ok {
+			return cert, nil
+		}
+	}
+
+	return set.certs[0], nil
+}
+
+// ClientCAs returns pool client certificates are verified with. It's nil if client certificates aren't accepted.
+func (s *Store) ClientCAs() *x509.CertPool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.set.clientCAs
+}
+
+func load(cfg *Config) (*loaded, error) {
+	set := &loaded{
+		byName:   make(map[string]*tls.Certificate),
+		modTimes: make(map[string]time.Time),
+	}
+
+	stat := func(path string) error {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		set.modTimes[path] = info.ModTime()
+		return nil
+	}
+
+	for _, p := range cfg.Pairs {
+		// Times are taken before reading, so a change in between is read again on the next check.
+		if err := stat(p.CertFile); err != nil {
+			return nil, err
+		}
+		if err := stat(p.KeyFile); err != nil {
+			return nil, err
+		}
+
+		cert, err := tls.LoadX509KeyPair(p.CertFile, p.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p.CertFile, err)
+		}
+		if cert.Leaf == nil {
+			if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+				return nil, fmt.Errorf("%s: %w", p.CertFile, err)
+			}
+		}
+
+		set.certs = append(set.certs, &cert)
+
+		names := cert.Leaf.DNSNames
+		if len(names) == 0 && cert.Leaf.Subject.CommonName != "" {
+			names = []string{cert.Leaf.Subject.CommonName}
+		}
+		for _, name := range names {
+			name = strings.ToLower(name)
+			// The first certificate of a name wins, as in config order.
+			if _, ok := set.byName[name]; !ok {
+				set.byName[name] = &cert
+			}
+		}
+	}
+
+	if cfg.ClientCAFile != "" {
+		if err := stat(cfg.ClientCAFile); err != nil {
+			return nil, err
+		}
+
+		data, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		set.clientCAs = x509.NewCertPool()
+		if !set.clientCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("%s: no PEM certificates", cfg.ClientCAFile)
+		}
+	}
+
+	return set, nil
+}
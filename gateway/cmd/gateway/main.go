@@ -1,52 +1,120 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
-	"github.com/sunnyyssh/designing-software-cw3/gateway/router"
-	"gopkg.in/yaml.v3"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sunnyyssh/designing-software-cw3/gateway/admin"
+	"github.com/sunnyyssh/designing-software-cw3/gateway/apikey"
+	"github.com/sunnyyssh/designing-software-cw3/gateway/config"
 )
 
-const configPath = "/etc/gateway/config.yaml"
+var (
+	configPath = flag.String("config", "/etc/gateway/config.yaml", "path to config file, it's reloaded on change and SIGHUP")
+	addr       = flag.String("addr", ":80", "address clients are served on")
+	tlsAddr    = flag.String("tls-addr", "", "address HTTPS clients are served on, config must have tls block; empty disables HTTPS")
+	adminAddr  = flag.String("admin-addr", ":8081", "address admin API is served on, it must not be exposed to clients")
+	keysFile   = flag.String("api-keys-file", "", "file API keys are stored in, "+
+		"API_KEYS_PG_CONN_STRING environment variable stores them in PostgreSQL instead")
+)
 
 func main() {
-	config, err := readConfig(configPath)
-	if err != nil {
-		slog.Error("failed to read config", "error", err)
-	}
+	flag.Parse()
 
-	router := router.New(config, slog.Default())
+	logger := slog.Default()
 
-	if err := http.ListenAndServe(":80", router); err != nil {
-		slog.Error("serving http failed", "error", err)
+	keys, err := newKeyring(context.Background(), logger)
+	if err != nil {
+		logger.Error("failed to open API key store", "error", err)
+		os.Exit(1)
 	}
-}
 
-func readConfig(path string) (*router.Config, error) {
-	data, err := os.ReadFile(path)
+	manager, err := config.NewManager(*configPath, keys, logger)
 	if err != nil {
-		return nil, err
+		logger.Error("failed to load config", "path", *configPath, "error", err)
+		os.Exit(1)
 	}
 
-	var rawConfig struct {
-		Locations map[string]struct {
-			URL string `yaml:"url"`
-		} `yaml:"locations"`
+	go manager.Run(context.Background())
+
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+
+		for range hup {
+			if err := manager.Reload(); err != nil {
+				logger.Error("config reload failed, old config is kept", "error", err)
+			}
+		}
+	}()
+
+	go func() {
+		if err := http.ListenAndServe(*adminAddr, admin.Handler(manager, logger)); err != nil {
+			logger.Error("serving admin http failed", "error", err)
+		}
+	}()
+
+	if *tlsAddr != "" {
+		if _, err := manager.Router().TLSConfig(); err != nil {
+			logger.Error("HTTPS listener needs tls block in config", "error", err)
+			os.Exit(1)
+		}
+
+		go func() {
+			// HTTP/2 is enabled by server for TLS connections.
+			srv := &http.Server{Addr: *tlsAddr, Handler: manager, TLSConfig: manager.TLSConfig()}
+			if err := srv.ListenAndServeTLS("", ""); err != nil {
+				logger.Error("serving https failed", "error", err)
+			}
+		}()
 	}
 
-	if err := yaml.Unmarshal(data, &rawConfig); err != nil {
-		return nil, err
+	if err := http.ListenAndServe(*addr, manager); err != nil {
+		logger.Error("serving http failed", "error", err)
 	}
+}
+
+// newKeyring opens API key store. Keys aren't accepted if neither file nor PostgreSQL is set.
+func newKeyring(ctx context.Context, logger *slog.Logger) (*apikey.Keyring, error) {
+	var store apikey.Store
+
+	switch connString := os.Getenv("API_KEYS_PG_CONN_STRING"); {
+	case connString != "":
+		db, err := pgxpool.New(ctx, connString)
+		if err != nil {
+			return nil, err
+		}
+
+		for range 10 {
+			if err = db.Ping(ctx); err == nil {
+				break
+			}
+			time.Sleep(time.Second)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if store, err = apikey.NewPostgresStore(ctx, db); err != nil {
+			return nil, err
+		}
+
+	case *keysFile != "":
+		var err error
+		if store, err = apikey.NewFileStore(*keysFile); err != nil {
+			return nil, err
+		}
 
-	config := new(router.Config)
-	for prefix, location := range rawConfig.Locations {
-		config.Locations = append(config.Locations, router.Location{
-			Prefix: prefix,
-			URL:    location.URL,
-		})
+	default:
+		return nil, nil
 	}
 
-	return config, nil
+	return apikey.NewKeyring(store, logger), nil
 }
@@ -0,0 +1,59 @@
+// Command jwt issues HS256 tokens accepted by the gateway. It's meant for local testing only.
+//
+//	JWT_SECRET=secret go run ./cmd/jwt -sub "$USER_ID" -roles admin
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+func main() {
+	var (
+		sub      = flag.String("sub", "", "subject, i.e. user ID")
+		roles    = flag.String("roles", "", "comma separated roles")
+		issuer   = flag.String("iss", "", "issuer")
+		audience = flag.String("aud", "", "audience")
+		ttl      = flag.Duration("ttl", time.Hour, "token lifetime")
+	)
+	flag.Parse()
+
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" || *sub == "" {
+		fmt.Fprintln(os.Stderr, "JWT_SECRET environment variable and -sub flag are required")
+		os.Exit(2)
+	}
+
+	now := time.Now()
+	claims := map[string]any{
+		"sub": *sub,
+		"iat": now.Unix(),
+		"exp": now.Add(*ttl).Unix(),
+	}
+	if *roles != "" {
+		claims["roles"] = strings.Split(*roles, ",")
+	}
+	if *issuer != "" {
+		claims["iss"] = *issuer
+	}
+	if *audience != "" {
+		claims["aud"] = *audience
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+
+	fmt.Println(signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)))
+}
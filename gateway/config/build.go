@@ -0,0 +1,408 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sunnyyssh/designing-software-cw3/gateway/apikey"
+	"github.com/sunnyyssh/designing-software-cw3/gateway/auth"
+	"github.com/sunnyyssh/designing-software-cw3/gateway/cache"
+	"github.com/sunnyyssh/designing-software-cw3/gateway/certs"
+	"github.com/sunnyyssh/designing-software-cw3/gateway/cors"
+	"github.com/sunnyyssh/designing-software-cw3/gateway/ratelimit"
+	"github.com/sunnyyssh/designing-software-cw3/gateway/router"
+	"github.com/sunnyyssh/designing-software-cw3/gateway/upstream"
+)
+
+// Load parses and validates config and builds router config of it.
+// Rate limit buckets, cached responses and metrics are kept in limits, responses and m between loads.
+// Keys check API keys, locations may accept them only if it's not nil.
+func Load(
+	data []byte, limits ratelimit.Store, responses *cache.Cache, keys *apikey.Keyring, m *router.Metrics, logger *slog.Logger,
+) (*router.Config, error) {
+	raw, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &router.Config{
+		APIKeys:        keys,
+		RateLimitStore: limits,
+		Cache:          responses,
+		Metrics:        m,
+	}
+
+	if raw.JWT != nil {
+		config.Verifier, err = newVerifier(raw.JWT, logger)
+		if err != nil {
+			return nil, fmt.Errorf("jwt: %w", err)
+		}
+	}
+
+	if raw.TLS != nil {
+		config.TLS, err = newTLS(raw.TLS, logger)
+		if err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+	}
+
+	for _, name := range raw.names() {
+		location := raw.Locations[name]
+
+		if location.Auth.APIKeys != nil && keys == nil {
+			return nil, fmt.Errorf("locations.%s.auth.api_keys: gateway has no API key store", name)
+		}
+
+		var (
+			pool  *upstream.Pool
+			split *router.Split
+		)
+		switch {
+		case location.Composite != nil:
+			// Composite location has no upstreams of its own.
+		case location.Split != nil:
+			split, err = newSplit(&location, logger.With("location", name))
+		default:
+			pool, err = upstream.NewPool(upstreamConfig(&location, location.URL, location.Upstreams), logger.With("location", name))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("locations.%s: %w", name, err)
+		}
+
+		mirror, err := newMirror(&location, logger.With("location", name, "shadow", true))
+		if err != nil {
+			return nil, fmt.Errorf("locations.%s.mirror: %w", name, err)
+		}
+
+		match := routeMatch(name, &location)
+
+		corsPolicy := raw.CORS
+		if location.CORS != nil {
+			corsPolicy = location.CORS
+		}
+
+		config.Locations = append(config.Locations, router.Location{
+			Name:      name,
+			Match:     match,
+			Rewrite:   rewrite(&match, &location),
+			Upstreams: pool,
+			Split:     split,
+			Mirror:    mirror,
+			Composite: newComposite(&location),
+			Headers: router.HeaderRules{
+				Request:  router.HeaderEdit(location.Headers.Request),
+				Response: router.HeaderEdit(location.Headers.Response),
+			},
+			Auth: auth.Rule{
+				Required:     location.Auth.Required,
+				Roles:        location.Auth.Roles,
+				APIKeyScopes: apiKeyScopes(location.Auth.APIKeys),
+				ClientCert:   clientCert(&location),
+			},
+			Cache:      cachePolicy(&location),
+			CORS:       newCORS(corsPolicy),
+			RateLimits: rateLimits(location.RateLimits),
+			Timeouts: router.Timeouts{
+				Connect: orDefault(location.Timeouts.Connect, 5*time.Second),
+				Read:    orDefault(location.Timeouts.Read, 30*time.Second),
+			},
+			Retry: router.Retry{
+				Attempts:   location.Retry.Attempts,
+				Backoff:    orDefault(location.Retry.Backoff, 100*time.Millisecond),
+				MaxBackoff: orDefault(location.Retry.MaxBackoff, 2*time.Second),
+			},
+		})
+	}
+
+	responses.Resize(cache.Config{
+		MaxBytes:      orDefault(raw.Cache.MaxSizeMB, 64) << 20,
+		MaxEntryBytes: orDefault(raw.Cache.MaxEntrySizeKB, 1024) << 10,
+	})
+
+	return config, nil
+}
+
+func cachePolicy(loc *rawLocation) *cache.Policy {
+	if loc.Cache == nil {
+		return nil
+	}
+	return &cache.Policy{TTL: loc.Cache.TTL}
+}
+
+func clientCert(loc *rawLocation) *auth.ClientCert {
+	if loc.Auth.ClientCert == nil {
+		return nil
+	}
+	return &auth.ClientCert{CommonNames: loc.Auth.ClientCert.CommonNames}
+}
+
+func apiKeyScopes(raw map[string][]string) map[string][]string {
+	if raw == nil {
+		return nil
+	}
+
+	scopes := make(map[string][]string, len(raw))
+	for method, s := range raw {
+		scopes[strings.ToUpper(method)] = s
+	}
+	return scopes
+}
+
+func routeMatch(name string, loc *rawLocation) router.Match {
+	m := router.Match{
+		Prefix: loc.Match.Prefix,
+		Exact:  loc.Match.Exact,
+		Hosts:  loc.Match.Hosts,
+	}
+
+	switch {
+	case loc.Match.Regex != "":
+		m.Regex = regexp.MustCompile(loc.Match.Regex)
+	case loc.pathMatchers() == 0:
+		m.Prefix = name
+	}
+
+	for _, method := range loc.Match.Methods {
+		m.Methods = append(m.Methods, strings.ToUpper(method))
+	}
+
+	for _, h := range loc.Match.Headers {
+		hm := router.HeaderMatch{Name: h.Name, Value: h.Value}
+		if h.Regex != "" {
+			hm.Regex = regexp.MustCompile(h.Regex)
+		}
+		m.Headers = append(m.Headers, hm)
+	}
+
+	return m
+}
+
+func rewrite(m *router.Match, loc *rawLocation) router.Rewrite {
+	rw := router.Rewrite{
+		StripPrefix: m.Prefix,
+		With:        loc.Rewrite.Replace.With,
+		AddPrefix:   loc.Rewrite.AddPrefix,
+	}
+
+	if loc.Rewrite.StripPrefix != nil {
+		rw.StripPrefix = *loc.Rewrite.StripPrefix
+	}
+	if loc.Rewrite.Replace.Regex != "" {
+		rw.Replace = regexp.MustCompile(loc.Rewrite.Replace.Regex)
+	}
+
+	return rw
+}
+
+func newVerifier(raw *rawJWT, logger *slog.Logger) (*auth.Verifier, error) {
+	cfg := &auth.Config{
+		JWKSFile:       raw.JWKSFile,
+		ReloadInterval: orDefault(raw.ReloadInterval, 30*time.Second),
+		Issuer:         raw.Issuer,
+		Audience:       raw.Audience,
+		Leeway:         raw.Leeway,
+		RolesClaim:     raw.RolesClaim,
+	}
+
+	if raw.HS256SecretEnv != "" {
+		secret := os.Getenv(raw.HS256SecretEnv)
+		if secret == "" {
+			return nil, fmt.Errorf("%s environment variable is empty", raw.HS256SecretEnv)
+		}
+		cfg.HS256Secret = []byte(secret)
+	}
+
+	return auth.NewVerifier(cfg, logger)
+}
+
+func newTLS(raw *rawTLS, logger *slog.Logger) (*router.TLS, error) {
+	cfg := &certs.Config{
+		ClientCAFile:   raw.ClientCA,
+		ReloadInterval: orDefault(raw.ReloadInterval, time.Minute),
+	}
+	for _, c := range raw.Certificates {
+		cfg.Pairs = append(cfg.Pairs, certs.Pair{CertFile: c.Cert, KeyFile: c.Key})
+	}
+
+	store, err := certs.NewStore(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &router.TLS{
+		Certs:        store,
+		MinVersion:   tlsVersions[raw.MinVersion],
+		RedirectHTTP: raw.RedirectHTTP,
+		HTTPSPort:    orDefault(raw.HTTPSPort, 443),
+	}
+
+	if h := raw.HSTS; h != nil {
+		t.HSTS = "max-age=" + strconv.Itoa(int(h.MaxAge.Seconds()))
+		if h.IncludeSubdomains {
+			t.HSTS += "; includeSubDomains"
+		}
+		if h.Preload {
+			t.HSTS += "; preload"
+		}
+	}
+
+	return t, nil
+}
+
+func newCORS(raw *rawCORS) *cors.Policy {
+	if raw == nil {
+		return nil
+	}
+
+	p := &cors.Policy{
+		AllowedOrigins:   raw.AllowedOrigins,
+		AllowedMethods:   []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type"},
+		ExposedHeaders:   raw.ExposedHeaders,
+		AllowCredentials: raw.AllowCredentials,
+		MaxAge:           raw.MaxAge,
+	}
+
+	if len(raw.AllowedMethods) > 0 {
+		p.AllowedMethods = make([]string, 0, len(raw.AllowedMethods))
+		for _, m := range raw.AllowedMethods {
+			p.AllowedMethods = append(p.AllowedMethods, strings.ToUpper(m))
+		}
+	}
+	if len(raw.AllowedHeaders) > 0 {
+		p.AllowedHeaders = raw.AllowedHeaders
+	}
+
+	return p
+}
+
+func rateLimits(raw []rawRateLimit) []ratelimit.Rule {
+	rules := make([]ratelimit.Rule, 0, len(raw))
+
+	for _, r := range raw {
+		methods := make([]string, 0, len(r.Methods))
+		for _, m := range r.Methods {
+			methods = append(methods, strings.ToUpper(m))
+		}
+
+		rules = append(rules, ratelimit.Rule{
+			Key:     ratelimit.KeyKind(r.Key),
+			Methods: methods,
+			Limit: ratelimit.Limit{
+				Rate:  float64(r.Requests) / r.Per.Seconds(),
+				Burst: orDefault(r.Burst, r.Requests),
+			},
+			Window: r.Per,
+		})
+	}
+
+	return rules
+}
+
+// newSplit builds pools of split groups. Groups share upstream settings of location.
+func newSplit(loc *rawLocation, logger *slog.Logger) (*router.Split, error) {
+	groups := make([]router.Group, 0, len(loc.Split.Groups))
+	for _, g := range loc.Split.Groups {
+		pool, err := upstream.NewPool(upstreamConfig(loc, g.URL, g.Upstreams), logger.With("group", g.Name))
+		if err != nil {
+			return nil, fmt.Errorf("split group %s: %w", g.Name, err)
+		}
+		groups = append(groups, router.Group{Name: g.Name, Weight: g.Weight, Upstreams: pool})
+	}
+
+	sticky := router.Sticky{By: router.StickyKind(loc.Split.Sticky)}
+	if sticky.By == router.StickyCookie {
+		sticky.Cookie = orDefault(loc.Split.Cookie, "gateway_split")
+	}
+
+	overrides := make([]router.Override, 0, len(loc.Split.Overrides))
+	for _, o := range loc.Split.Overrides {
+		overrides = append(overrides, router.Override{Header: o.Header, Value: o.Value, Group: o.Group})
+	}
+
+	return router.NewSplit(groups, sticky, overrides), nil
+}
+
+func newMirror(loc *rawLocation, logger *slog.Logger) (*router.Mirror, error) {
+	if loc.Mirror == nil {
+		return nil, nil
+	}
+
+	pool, err := upstream.NewPool(upstreamConfig(loc, loc.Mirror.URL, loc.Mirror.Upstreams), logger)
+	if err != nil {
+		return nil, err
+	}
+
+	return &router.Mirror{
+		Upstreams:   pool,
+		Percent:     loc.Mirror.Percent,
+		AllowUnsafe: loc.Mirror.AllowUnsafe,
+		Compare:     loc.Mirror.Compare,
+		Timeout:     orDefault(loc.Mirror.Timeout, 10*time.Second),
+	}, nil
+}
+
+func newComposite(loc *rawLocation) *router.Composite {
+	if loc.Composite == nil {
+		return nil
+	}
+
+	c := &router.Composite{Timeout: orDefault(loc.Composite.Timeout, 10*time.Second)}
+	for _, s := range loc.Composite.Sections {
+		c.Sections = append(c.Sections, router.Section{Name: s.Name, Path: s.Path, Required: s.Required})
+	}
+	return c
+}
+
+// upstreamConfig builds config of pool of targets given either as url or as list of upstreams.
+func upstreamConfig(loc *rawLocation, url string, targets []rawTarget) *upstream.Config {
+	cfg := &upstream.Config{
+		Balancer:   upstream.BalancerKind(loc.Balancer),
+		HashHeader: loc.HashHeader,
+		HealthCheck: upstream.HealthCheck{
+			Path:               loc.HealthCheck.Path,
+			Interval:           orDefault(loc.HealthCheck.Interval, 10*time.Second),
+			Timeout:            orDefault(loc.HealthCheck.Timeout, 2*time.Second),
+			HealthyThreshold:   orDefault(loc.HealthCheck.HealthyThreshold, 2),
+			UnhealthyThreshold: orDefault(loc.HealthCheck.UnhealthyThreshold, 3),
+		},
+		Passive: upstream.Passive{
+			MaxFails: 3,
+			EjectFor: orDefault(loc.Passive.EjectFor, 30*time.Second),
+		},
+		Breaker: upstream.Breaker{
+			FailureThreshold: 5,
+			OpenFor:          orDefault(loc.Breaker.OpenFor, 30*time.Second),
+			HalfOpenRequests: orDefault(loc.Breaker.HalfOpenRequests, 1),
+		},
+	}
+
+	if loc.Passive.MaxFails != nil {
+		cfg.Passive.MaxFails = *loc.Passive.MaxFails
+	}
+	if loc.Breaker.FailureThreshold != nil {
+		cfg.Breaker.FailureThreshold = *loc.Breaker.FailureThreshold
+	}
+
+	if url != "" {
+		cfg.Targets = append(cfg.Targets, upstream.Target{URL: url})
+	}
+	for _, t := range targets {
+		cfg.Targets = append(cfg.Targets, upstream.Target{URL: t.URL, Weight: t.Weight})
+	}
+
+	return cfg
+}
+
+func orDefault[T comparable](val, defaultValue T) T {
+	var zero T
+	if val == zero {
+		return defaultValue
+	}
+	return val
+}
@@ -0,0 +1,652 @@
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sunnyyssh/designing-software-cw3/gateway/apikey"
+	"github.com/sunnyyssh/designing-software-cw3/gateway/ratelimit"
+	"github.com/sunnyyssh/designing-software-cw3/gateway/router"
+	"github.com/sunnyyssh/designing-software-cw3/gateway/upstream"
+	"gopkg.in/yaml.v3"
+)
+
+type rawTarget struct {
+	URL    string `yaml:"url"`
+	Weight int    `yaml:"weight"`
+}
+
+type rawHeaderMatch struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+	Regex string `yaml:"regex"`
+}
+
+type rawHeaderEdit struct {
+	Set    map[string]string `yaml:"set"`
+	Remove []string          `yaml:"remove"`
+}
+
+type rawGroup struct {
+	Name   string `yaml:"name"`
+	Weight int    `yaml:"weight"`
+	// URL is a shorthand for the single upstream.
+	URL       string      `yaml:"url"`
+	Upstreams []rawTarget `yaml:"upstreams"`
+}
+
+type rawSplit struct {
+	// Groups get traffic by weights. Sticky clients are hashed onto groups in this order.
+	Groups []rawGroup `yaml:"groups"`
+	Sticky string     `yaml:"sticky"`
+	// Cookie is a name of cookie sticky clients are identified by.
+	Cookie    string `yaml:"cookie"`
+	Overrides []struct {
+		Header string `yaml:"header"`
+		Value  string `yaml:"value"`
+		Group  string `yaml:"group"`
+	} `yaml:"overrides"`
+}
+
+type rawMirror struct {
+	// URL is a shorthand for the single shadow upstream.
+	URL       string      `yaml:"url"`
+	Upstreams []rawTarget `yaml:"upstreams"`
+	Percent   float64     `yaml:"percent"`
+	// AllowUnsafe mirrors methods other than GET, HEAD, OPTIONS and TRACE.
+	AllowUnsafe bool          `yaml:"allow_unsafe"`
+	Compare     bool          `yaml:"compare"`
+	Timeout     time.Duration `yaml:"timeout"`
+}
+
+type rawComposite struct {
+	// Timeout limits fetching all sections.
+	Timeout  time.Duration `yaml:"timeout"`
+	Sections []struct {
+		Name string `yaml:"name"`
+		// Path is a gateway path sections are fetched from. It may contain {user_id}, {query.NAME}
+		// and {NAME} of named groups of location regex.
+		Path     string `yaml:"path"`
+		Required bool   `yaml:"required"`
+	} `yaml:"sections"`
+}
+
+type rawCORS struct {
+	AllowedOrigins   []string      `yaml:"allowed_origins"`
+	AllowedMethods   []string      `yaml:"allowed_methods"`
+	AllowedHeaders   []string      `yaml:"allowed_headers"`
+	ExposedHeaders   []string      `yaml:"exposed_headers"`
+	AllowCredentials bool          `yaml:"allow_credentials"`
+	MaxAge           time.Duration `yaml:"max_age"`
+}
+
+type rawLocation struct {
+	// Match selects requests of location. Location key is its prefix unless match has prefix, exact or regex.
+	Match struct {
+		Prefix  string           `yaml:"prefix"`
+		Exact   string           `yaml:"exact"`
+		Regex   string           `yaml:"regex"`
+		Methods []string         `yaml:"methods"`
+		Hosts   []string         `yaml:"hosts"`
+		Headers []rawHeaderMatch `yaml:"headers"`
+	} `yaml:"match"`
+
+	Rewrite struct {
+		// StripPrefix defaults to prefix of prefix location, so upstream gets path relative to it.
+		StripPrefix *string `yaml:"strip_prefix"`
+		Replace     struct {
+			Regex string `yaml:"regex"`
+			With  string `yaml:"with"`
+		} `yaml:"replace"`
+		AddPrefix string `yaml:"add_prefix"`
+	} `yaml:"rewrite"`
+
+	Headers struct {
+		Request  rawHeaderEdit `yaml:"request"`
+		Response rawHeaderEdit `yaml:"response"`
+	} `yaml:"headers"`
+
+	// URL is a shorthand for the single upstream.
+	URL        string      `yaml:"url"`
+	Upstreams  []rawTarget `yaml:"upstreams"`
+	Balancer   string      `yaml:"balancer"`
+	HashHeader string      `yaml:"hash_header"`
+	// Split replaces url and upstreams with groups of upstreams. Groups share balancing, health checks and breakers settings.
+	Split *rawSplit `yaml:"split"`
+	// Mirror sends copies of requests to shadow upstreams. It shares upstream settings of location too.
+	Mirror *rawMirror `yaml:"mirror"`
+	// Composite replaces upstreams with sections fetched from other locations and merged into one document.
+	Composite *rawComposite `yaml:"composite"`
+
+	HealthCheck struct {
+		Path               string        `yaml:"path"`
+		Interval           time.Duration `yaml:"interval"`
+		Timeout            time.Duration `yaml:"timeout"`
+		HealthyThreshold   int           `yaml:"healthy_threshold"`
+		UnhealthyThreshold int           `yaml:"unhealthy_threshold"`
+	} `yaml:"health_check"`
+
+	Passive struct {
+		MaxFails *int          `yaml:"max_fails"`
+		EjectFor time.Duration `yaml:"eject_for"`
+	} `yaml:"passive"`
+
+	Breaker struct {
+		FailureThreshold *int          `yaml:"failure_threshold"`
+		OpenFor          time.Duration `yaml:"open_for"`
+		HalfOpenRequests int           `yaml:"half_open_requests"`
+	} `yaml:"breaker"`
+
+	Timeouts struct {
+		Connect time.Duration `yaml:"connect"`
+		Read    time.Duration `yaml:"read"`
+	} `yaml:"timeouts"`
+
+	Retry struct {
+		Attempts   int           `yaml:"attempts"`
+		Backoff    time.Duration `yaml:"backoff"`
+		MaxBackoff time.Duration `yaml:"max_backoff"`
+	} `yaml:"retry"`
+
+	Auth struct {
+		Required bool     `yaml:"required"`
+		Roles    []string `yaml:"roles"`
+		// APIKeys accepts API keys for listed methods. Key must have any of method scopes, empty list accepts any key.
+		APIKeys map[string][]string `yaml:"api_keys"`
+		// ClientCert requires TLS client certificate verified by tls.client_ca.
+		ClientCert *struct {
+			CommonNames []string `yaml:"common_names"`
+		} `yaml:"client_cert"`
+	} `yaml:"auth"`
+
+	// Cache enables caching of GET responses.
+	Cache *struct {
+		// TTL is how long responses without max-age are fresh. Zero caches only responses with max-age.
+		TTL time.Duration `yaml:"ttl"`
+	} `yaml:"cache"`
+
+	// CORS replaces top-level policy for location.
+	CORS *rawCORS `yaml:"cors"`
+
+	RateLimits []rawRateLimit `yaml:"rate_limits"`
+}
+
+type rawRateLimit struct {
+	Key      string        `yaml:"key"`
+	Methods  []string      `yaml:"methods"`
+	Requests int           `yaml:"requests"`
+	Per      time.Duration `yaml:"per"`
+	// Burst is bucket size. Defaults to Requests.
+	Burst int `yaml:"burst"`
+}
+
+type rawJWT struct {
+	// HS256SecretEnv is a name of environment variable holding HS256 secret, so it's not kept in config.
+	HS256SecretEnv string        `yaml:"hs256_secret_env"`
+	JWKSFile       string        `yaml:"jwks_file"`
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+	Issuer         string        `yaml:"issuer"`
+	Audience       string        `yaml:"audience"`
+	Leeway         time.Duration `yaml:"leeway"`
+	RolesClaim     string        `yaml:"roles_claim"`
+}
+
+type rawTLS struct {
+	// Certificates are picked by SNI, the first one serves clients without SNI or with unknown name.
+	Certificates []struct {
+		Cert string `yaml:"cert"`
+		Key  string `yaml:"key"`
+	} `yaml:"certificates"`
+	// ClientCA verifies client certificates locations may require.
+	ClientCA       string        `yaml:"client_ca"`
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+	MinVersion     string        `yaml:"min_version"`
+	RedirectHTTP   bool          `yaml:"redirect_http"`
+	// HTTPSPort is a port clients reach HTTPS listener on, redirects lead there.
+	HTTPSPort int `yaml:"https_port"`
+	HSTS      *struct {
+		MaxAge            time.Duration `yaml:"max_age"`
+		IncludeSubdomains bool          `yaml:"include_subdomains"`
+		Preload           bool          `yaml:"preload"`
+	} `yaml:"hsts"`
+}
+
+type rawConfig struct {
+	JWT   *rawJWT `yaml:"jwt"`
+	TLS   *rawTLS `yaml:"tls"`
+	Cache struct {
+		MaxSizeMB      int64 `yaml:"max_size_mb"`
+		MaxEntrySizeKB int64 `yaml:"max_entry_size_kb"`
+	} `yaml:"cache"`
+
+	// CORS is a policy of locations without their own one.
+	CORS      *rawCORS               `yaml:"cors"`
+	Locations map[string]rawLocation `yaml:"locations"`
+}
+
+// parse decodes config rejecting unknown fields, so typos don't silently turn options off.
+func parse(data []byte) (*rawConfig, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+
+	var raw rawConfig
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	if err := raw.validate(); err != nil {
+		return nil, err
+	}
+
+	return &raw, nil
+}
+
+// problems collects config errors, so all of them are reported at once.
+type problems []error
+
+func (p *problems) add(path string, format string, args ...any) {
+	*p = append(*p, fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...)))
+}
+
+func (c *rawConfig) validate() error {
+	var p problems
+
+	if len(c.Locations) == 0 {
+		p.add("locations", "at least one location is required")
+	}
+
+	for _, prefix := range c.names() {
+		loc := c.Locations[prefix]
+		loc.validate(&p, prefix, c.JWT != nil)
+
+		if loc.Auth.ClientCert != nil && (c.TLS == nil || c.TLS.ClientCA == "") {
+			p.add("locations."+prefix+".auth.client_cert", "requires tls.client_ca")
+		}
+	}
+
+	if c.TLS != nil {
+		c.TLS.validate(&p, "tls")
+	}
+
+	if c.CORS != nil {
+		c.CORS.validate(&p, "cors")
+	}
+
+	if c.Cache.MaxSizeMB < 0 || c.Cache.MaxEntrySizeKB < 0 {
+		p.add("cache", "sizes must not be negative")
+	}
+
+	if c.JWT != nil {
+		if c.JWT.HS256SecretEnv == "" && c.JWT.JWKSFile == "" {
+			p.add("jwt", "hs256_secret_env or jwks_file is required")
+		}
+		if c.JWT.ReloadInterval < 0 || c.JWT.Leeway < 0 {
+			p.add("jwt", "durations must not be negative")
+		}
+	}
+
+	return errors.Join(p...)
+}
+
+// names returns location names in stable order.
+func (c *rawConfig) names() []string {
+	names := make([]string, 0, len(c.Locations))
+	for name := range c.Locations {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// pathMatchers returns number of path matchers set in match block.
+func (l *rawLocation) pathMatchers() int {
+	n := 0
+	for _, m := range []string{l.Match.Prefix, l.Match.Exact, l.Match.Regex} {
+		if m != "" {
+			n++
+		}
+	}
+	return n
+}
+
+func (l *rawLocation) validate(p *problems, name string, hasJWT bool) {
+	path := "locations." + name
+
+	switch l.pathMatchers() {
+	case 0:
+		if !strings.HasPrefix(name, "/") {
+			p.add(path, "prefix must start with /")
+		}
+	case 1:
+		if (l.Match.Prefix != "" && !strings.HasPrefix(l.Match.Prefix, "/")) || (l.Match.Exact != "" && !strings.HasPrefix(l.Match.Exact, "/")) {
+			p.add(path+".match", "path must start with /")
+		}
+		validateRegex(p, path+".match.regex", l.Match.Regex)
+	default:
+		p.add(path+".match", "prefix, exact and regex are mutually exclusive")
+	}
+
+	for i, h := range l.Match.Headers {
+		hPath := fmt.Sprintf("%s.match.headers[%d]", path, i)
+		if h.Name == "" {
+			p.add(hPath+".name", "is required")
+		}
+		if h.Value != "" && h.Regex != "" {
+			p.add(hPath, "value and regex are mutually exclusive")
+		}
+		validateRegex(p, hPath+".regex", h.Regex)
+	}
+
+	validateRegex(p, path+".rewrite.replace.regex", l.Rewrite.Replace.Regex)
+
+	switch {
+	case l.Composite != nil:
+		if l.URL != "" || len(l.Upstreams) > 0 || l.Split != nil || l.Mirror != nil || l.Cache != nil {
+			p.add(path, "composite and url, upstreams, split, mirror or cache are mutually exclusive")
+		}
+		l.Composite.validate(p, path+".composite", l)
+	case l.Split != nil:
+		if l.URL != "" || len(l.Upstreams) > 0 {
+			p.add(path, "split and url or upstreams are mutually exclusive")
+		}
+		l.Split.validate(p, path+".split")
+	default:
+		validateTargets(p, path, l.URL, l.Upstreams)
+	}
+
+	if l.Mirror != nil {
+		validateTargets(p, path+".mirror", l.Mirror.URL, l.Mirror.Upstreams)
+		if l.Mirror.Percent <= 0 || l.Mirror.Percent > 100 {
+			p.add(path+".mirror.percent", "must be above 0 and at most 100")
+		}
+		if l.Mirror.Timeout < 0 {
+			p.add(path+".mirror.timeout", "must not be negative")
+		}
+	}
+
+	switch upstream.BalancerKind(l.Balancer) {
+	case "", upstream.RoundRobin, upstream.LeastConn:
+	case upstream.Hash:
+		if l.HashHeader == "" {
+			p.add(path+".hash_header", "is required by hash balancer")
+		}
+	default:
+		p.add(path+".balancer", "must be round_robin, least_conn or hash")
+	}
+
+	hc := l.HealthCheck
+	if hc.Interval < 0 || hc.Timeout < 0 || hc.HealthyThreshold < 0 || hc.UnhealthyThreshold < 0 {
+		p.add(path+".health_check", "values must not be negative")
+	}
+	if (l.Passive.MaxFails != nil && *l.Passive.MaxFails < 0) || l.Passive.EjectFor < 0 {
+		p.add(path+".passive", "values must not be negative")
+	}
+	if (l.Breaker.FailureThreshold != nil && *l.Breaker.FailureThreshold < 0) || l.Breaker.OpenFor < 0 || l.Breaker.HalfOpenRequests < 0 {
+		p.add(path+".breaker", "values must not be negative")
+	}
+	if l.Timeouts.Connect < 0 || l.Timeouts.Read < 0 {
+		p.add(path+".timeouts", "values must not be negative")
+	}
+	if l.Retry.Attempts < 0 || l.Retry.Backoff < 0 || l.Retry.MaxBackoff < 0 {
+		p.add(path+".retry", "values must not be negative")
+	}
+
+	if l.CORS != nil {
+		l.CORS.validate(p, path+".cors")
+	}
+
+	if l.Cache != nil && l.Cache.TTL < 0 {
+		p.add(path+".cache.ttl", "must not be negative")
+	}
+
+	switch {
+	case !hasJWT && len(l.Auth.Roles) > 0:
+		p.add(path+".auth.roles", "requires jwt block")
+	case !hasJWT && l.Auth.Required && l.Auth.APIKeys == nil:
+		p.add(path+".auth", "requires jwt block or api_keys")
+	}
+
+	for method, scopes := range l.Auth.APIKeys {
+		mPath := fmt.Sprintf("%s.auth.api_keys.%s", path, method)
+		if !slices.Contains(methods, strings.ToUpper(method)) {
+			p.add(mPath, "unknown method")
+		}
+		for _, s := range scopes {
+			if !slices.Contains(apikey.Scopes, s) {
+				p.add(mPath, "unknown scope %q, must be one of %s", s, strings.Join(apikey.Scopes, ", "))
+			}
+		}
+	}
+
+	for i, r := range l.RateLimits {
+		rlPath := fmt.Sprintf("%s.rate_limits[%d]", path, i)
+
+		if !slices.Contains([]ratelimit.KeyKind{ratelimit.KeyIP, ratelimit.KeyUser, ratelimit.KeyAPIKey}, ratelimit.KeyKind(r.Key)) {
+			p.add(rlPath+".key", "must be ip, user or api_key")
+		}
+		if r.Requests <= 0 || r.Per <= 0 {
+			p.add(rlPath, "requests and per must be positive")
+		}
+		if r.Burst < 0 {
+			p.add(rlPath+".burst", "must not be negative")
+		}
+	}
+}
+
+var methods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodDelete, http.MethodOptions, http.MethodTrace,
+}
+
+// validateTargets checks upstreams of location or split group given either as url or as list of upstreams.
+func validateTargets(p *problems, path, url string, targets []rawTarget) {
+	switch {
+	case url == "" && len(targets) == 0:
+		p.add(path, "url or upstreams is required")
+	case url != "" && len(targets) > 0:
+		p.add(path, "url and upstreams are mutually exclusive")
+	}
+
+	if url != "" {
+		validateURL(p, path+".url", url)
+	}
+	for i, t := range targets {
+		validateURL(p, fmt.Sprintf("%s.upstreams[%d].url", path, i), t.URL)
+		if t.Weight < 0 {
+			p.add(fmt.Sprintf("%s.upstreams[%d].weight", path, i), "must not be negative")
+		}
+	}
+}
+
+func (s *rawSplit) validate(p *problems, path string) {
+	if len(s.Groups) == 0 {
+		p.add(path+".groups", "at least one group is required")
+	}
+
+	names := make(map[string]bool, len(s.Groups))
+	total := 0
+
+	for i, g := range s.Groups {
+		gPath := fmt.Sprintf("%s.groups[%d]", path, i)
+
+		switch {
+		case g.Name == "":
+			p.add(gPath+".name", "is required")
+		case names[g.Name]:
+			p.add(gPath+".name", "%q is duplicated", g.Name)
+		}
+		names[g.Name] = true
+
+		if g.Weight < 0 {
+			p.add(gPath+".weight", "must not be negative")
+		}
+		total += g.Weight
+
+		validateTargets(p, gPath, g.URL, g.Upstreams)
+	}
+
+	if len(s.Groups) > 0 && total <= 0 {
+		p.add(path+".groups", "at least one group must have positive weight")
+	}
+
+	switch router.StickyKind(s.Sticky) {
+	case router.StickyNone, router.StickyUser:
+		if s.Cookie != "" {
+			p.add(path+".cookie", "is used only by cookie stickiness")
+		}
+	case router.StickyCookie:
+	default:
+		p.add(path+".sticky", "must be user or cookie")
+	}
+
+	for i, o := range s.Overrides {
+		oPath := fmt.Sprintf("%s.overrides[%d]", path, i)
+		if o.Header == "" {
+			p.add(oPath+".header", "is required")
+		}
+		if !names[o.Group] {
+			p.add(oPath+".group", "unknown group %q", o.Group)
+		}
+	}
+}
+
+func (c *rawComposite) validate(p *problems, path string, loc *rawLocation) {
+	if len(c.Sections) == 0 {
+		p.add(path+".sections", "at least one section is required")
+	}
+	if c.Timeout < 0 {
+		p.add(path+".timeout", "must not be negative")
+	}
+
+	var groups []string
+	if re, err := regexp.Compile(loc.Match.Regex); err == nil && loc.Match.Regex != "" {
+		groups = re.SubexpNames()
+	}
+
+	names := make(map[string]bool, len(c.Sections))
+
+	for i, s := range c.Sections {
+		sPath := fmt.Sprintf("%s.sections[%d]", path, i)
+
+		switch {
+		case s.Name == "":
+			p.add(sPath+".name", "is required")
+		case s.Name == "errors":
+			p.add(sPath+".name", "errors is reserved for failed sections")
+		case names[s.Name]:
+			p.add(sPath+".name", "%q is duplicated", s.Name)
+		}
+		names[s.Name] = true
+
+		if !strings.HasPrefix(s.Path, "/") {
+			p.add(sPath+".path", "must start with /")
+		}
+
+		for _, name := range router.Placeholders(s.Path) {
+			switch {
+			case name == "user_id":
+				if !loc.Auth.Required && len(loc.Auth.Roles) == 0 {
+					p.add(sPath+".path", "{user_id} requires auth of location")
+				}
+			case strings.HasPrefix(name, "query."):
+				if name == "query." {
+					p.add(sPath+".path", "{query.} has no parameter name")
+				}
+			case name == "" || !slices.Contains(groups, name):
+				p.add(sPath+".path", "unknown placeholder {%s}", name)
+			}
+		}
+	}
+}
+
+// tlsVersions are versions TLS may be limited to. Older ones are broken.
+var tlsVersions = map[string]uint16{
+	"":    tls.VersionTLS12,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func (t *rawTLS) validate(p *problems, path string) {
+	if len(t.Certificates) == 0 {
+		p.add(path+".certificates", "at least one certificate is required")
+	}
+	for i, c := range t.Certificates {
+		if c.Cert == "" || c.Key == "" {
+			p.add(fmt.Sprintf("%s.certificates[%d]", path, i), "cert and key are required")
+		}
+	}
+
+	if _, ok := tlsVersions[t.MinVersion]; !ok {
+		p.add(path+".min_version", "must be 1.2 or 1.3")
+	}
+	if t.ReloadInterval < 0 {
+		p.add(path+".reload_interval", "must not be negative")
+	}
+	if t.HTTPSPort < 0 || t.HTTPSPort > 65535 {
+		p.add(path+".https_port", "must be a port")
+	}
+
+	if t.HSTS != nil {
+		if t.HSTS.MaxAge <= 0 {
+			p.add(path+".hsts.max_age", "must be positive")
+		}
+		// Browsers' preload lists accept only these.
+		if t.HSTS.Preload && (t.HSTS.MaxAge < 365*24*time.Hour || !t.HSTS.IncludeSubdomains) {
+			p.add(path+".hsts.preload", "requires max_age of at least a year and include_subdomains")
+		}
+	}
+}
+
+func (c *rawCORS) validate(p *problems, path string) {
+	if len(c.AllowedOrigins) == 0 {
+		p.add(path+".allowed_origins", "at least one origin is required")
+	}
+
+	for i, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+
+		u, err := neturl.Parse(strings.Replace(origin, "*", "wildcard", 1))
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" || strings.Count(origin, "*") > 1 {
+			p.add(fmt.Sprintf("%s.allowed_origins[%d]", path, i), "must be *, or scheme://host[:port] with optional * for subdomains")
+		}
+	}
+
+	if c.MaxAge < 0 {
+		p.add(path+".max_age", "must not be negative")
+	}
+}
+
+func validateRegex(p *problems, path, expr string) {
+	if expr == "" {
+		return
+	}
+	if _, err := regexp.Compile(expr); err != nil {
+		p.add(path, "%s", err)
+	}
+}
+
+// validateURL checks upstream URL. It must end with / as routed path is appended to it.
+func validateURL(p *problems, path, raw string) {
+	u, err := neturl.Parse(raw)
+	if err != nil {
+		p.add(path, "%s", err)
+		return
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		p.add(path, "must be absolute http or https URL")
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		p.add(path, "must end with /")
+	}
+}
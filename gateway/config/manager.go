@@ -0,0 +1,293 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sunnyyssh/designing-software-cw3/gateway/apikey"
+	"github.com/sunnyyssh/designing-software-cw3/gateway/cache"
+	"github.com/sunnyyssh/designing-software-cw3/gateway/metrics"
+	"github.com/sunnyyssh/designing-software-cw3/gateway/ratelimit"
+	"github.com/sunnyyssh/designing-software-cw3/gateway/router"
+)
+
+// watchInterval is how often config file is checked for changes.
+const watchInterval = 5 * time.Second
+
+// Status describes the active config and the last reload attempt.
+type Status struct {
+	Path string `json:"path"`
+	// Version is incremented by every successful load.
+	Version     int       `json:"version"`
+	Checksum    string    `json:"checksum"`
+	LoadedAt    time.Time `json:"loaded_at"`
+	LastAttempt time.Time `json:"last_attempt"`
+	// LastError is an error of the last attempt. It's empty if the last attempt succeeded.
+	LastError string `json:"last_error,omitempty"`
+	Failures  int    `json:"failures"`
+}
+
+type active struct {
+	router *router.Router
+	data   []byte
+	// cancel stops health checks and JWKS reloading of router.
+	cancel context.CancelFunc
+}
+
+// Manager routes requests with router of the latest valid config. Reload swaps routers atomically:
+// requests in flight are finished by the old router while new ones go to the new one.
+// Invalid config is rejected and the old one stays active.
+type Manager struct {
+	path      string
+	limits    ratelimit.Store
+	responses *cache.Cache
+	keys      *apikey.Keyring
+	registry  *metrics.Registry
+	metrics   *router.Metrics
+	reloads   *metrics.Counter
+	logger    *slog.Logger
+
+	current atomic.Pointer[active]
+
+	// mu serializes reloads.
+	mu sync.Mutex
+	// ctx is set by Run. Routers loaded before are started by Run.
+	ctx context.Context
+	// modTime is modification time of the file the last attempt has read.
+	modTime time.Time
+	status  Status
+}
+
+// NewManager loads config from path. Rate limit buckets, cached responses and metrics are kept between reloads.
+// Keys check API keys, they aren't accepted if it's nil.
+func NewManager(path string, keys *apikey.Keyring, logger *slog.Logger) (*Manager, error) {
+	registry := metrics.NewRegistry()
+
+	m := &Manager{
+		path:      path,
+		limits:    ratelimit.NewMemoryStore(),
+		responses: cache.New(cache.Config{}),
+		keys:      keys,
+		registry:  registry,
+		metrics:   router.NewMetrics(registry),
+		reloads:   registry.Counter("gateway_config_reloads_total", "Config load attempts.", "result"),
+		logger:    logger,
+		status:    Status{Path: path},
+	}
+	m.registerMetrics()
+
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *Manager) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	m.current.Load().router.ServeHTTP(w, req)
+}
+
+// Router returns router of the active config.
+func (m *Manager) Router() *router.Router {
+	return m.current.Load().router
+}
+
+// Cache returns cache of responses shared by all configs.
+func (m *Manager) Cache() *cache.Cache {
+	return m.responses
+}
+
+// APIKeys returns keyring shared by all configs. It's nil if gateway has no API key store.
+func (m *Manager) APIKeys() *apikey.Keyring {
+	return m.keys
+}
+
+// TLSConfig returns config of HTTPS listener. Handshakes use certificates and settings of the active config,
+// so they change on reload without restarting the listener.
+func (m *Manager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return m.Router().TLSConfig()
+		},
+	}
+}
+
+// Metrics returns metrics of requests, upstreams and config reloads.
+func (m *Manager) Metrics() http.Handler {
+	return m.registry
+}
+
+func (m *Manager) registerMetrics() {
+	m.registry.Collect("gateway_config_last_reload_successful", "Whether the last config load attempt succeeded.",
+		metrics.TypeGauge, nil, func(emit metrics.EmitFunc) {
+			if m.Status().LastError == "" {
+				emit(1)
+			} else {
+				emit(0)
+			}
+		})
+
+	m.registry.Collect("gateway_config_last_reload_success_timestamp_seconds", "Time the active config was loaded.",
+		metrics.TypeGauge, nil, func(emit metrics.EmitFunc) {
+			emit(float64(m.Status().LoadedAt.Unix()))
+		})
+
+	m.registry.Collect("gateway_config_version", "Number of successful config loads.",
+		metrics.TypeGauge, nil, func(emit metrics.EmitFunc) {
+			emit(float64(m.Status().Version))
+		})
+
+	m.registry.Collect("gateway_upstream_healthy", "Whether upstream passes health checks and isn't ejected.",
+		metrics.TypeGauge, []string{"location", "upstream"}, func(emit metrics.EmitFunc) {
+			for _, loc := range m.Router().Status() {
+				statuses := loc.Upstreams
+				for _, g := range loc.Groups {
+					statuses = append(statuses, g.Upstreams...)
+				}
+
+				for _, u := range statuses {
+					healthy := 0.0
+					if u.Healthy && !u.Ejected {
+						healthy = 1
+					}
+					emit(healthy, loc.Name, u.URL)
+				}
+			}
+		})
+
+	m.registry.Collect("gateway_split_weight", "Current weight of split group.",
+		metrics.TypeGauge, []string{"location", "group"}, func(emit metrics.EmitFunc) {
+			for _, loc := range m.Router().Status() {
+				for _, g := range loc.Groups {
+					emit(float64(g.Weight), loc.Name, g.Name)
+				}
+			}
+		})
+}
+
+// Config returns content of the active config file.
+func (m *Manager) Config() []byte {
+	return m.current.Load().data
+}
+
+func (m *Manager) Status() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.status
+}
+
+// Reload reads config file and swaps router if config is valid.
+func (m *Manager) Reload() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.status.LastAttempt = time.Now()
+
+	if err := m.reload(); err != nil {
+		m.status.LastError = err.Error()
+		m.status.Failures++
+		m.reloads.Inc("failure")
+		return err
+	}
+
+	m.status.LastError = ""
+	m.reloads.Inc("success")
+	return nil
+}
+
+func (m *Manager) reload() error {
+	info, err := os.Stat(m.path)
+	if err != nil {
+		return err
+	}
+	m.modTime = info.ModTime()
+
+	data, err := os.ReadFile(m.path)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := Load(data, m.limits, m.responses, m.keys, m.metrics, m.logger)
+	if err != nil {
+		return err
+	}
+
+	next := &active{
+		router: router.New(cfg, m.logger),
+		data:   data,
+	}
+	if m.ctx != nil {
+		m.start(next)
+	}
+
+	if prev := m.current.Swap(next); prev != nil && prev.cancel != nil {
+		prev.cancel()
+	}
+
+	checksum := sha256.Sum256(data)
+
+	m.status.Version++
+	m.status.Checksum = hex.EncodeToString(checksum[:])
+	m.status.LoadedAt = time.Now()
+
+	m.logger.Info("config loaded", "path", m.path, "version", m.status.Version, "checksum", m.status.Checksum)
+	return nil
+}
+
+func (m *Manager) start(a *active) {
+	ctx, cancel := context.WithCancel(m.ctx)
+	a.cancel = cancel
+
+	go func() {
+		if err := a.router.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			m.logger.Error("router stopped", "error", err)
+		}
+	}()
+}
+
+// Run runs the active router and reloads config when its file changes until ctx is done.
+func (m *Manager) Run(ctx context.Context) error {
+	m.mu.Lock()
+	m.ctx = ctx
+	m.start(m.current.Load())
+	m.mu.Unlock()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-time.Tick(watchInterval):
+			if !m.changed() {
+				continue
+			}
+
+			if err := m.Reload(); err != nil {
+				m.logger.ErrorContext(ctx, "config reload failed, old config is kept", "error", err)
+			}
+		}
+	}
+}
+
+// changed tells whether config file is modified since the last attempt, so broken file isn't reread again and again.
+func (m *Manager) changed() bool {
+	info, err := os.Stat(m.path)
+	if err != nil {
+		return false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return !info.ModTime().Equal(m.modTime)
+}
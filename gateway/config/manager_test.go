@@ -0,0 +1,159 @@
+package config
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeConfig writes config routing /api/ to url.
+func writeConfig(t *testing.T, path, url string) []byte {
+	t.Helper()
+
+	data := []byte("locations:\n  /api/:\n    url: " + url + "/\n")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("write config: %s", err)
+	}
+	return data
+}
+
+func get(t *testing.T, url string) string {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("get: %s", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read body: %s", err)
+	}
+	return string(body)
+}
+
+func TestManagerKeepsConfigOnInvalidReload(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("old"))
+	}))
+	t.Cleanup(backend.Close)
+
+	path := filepath.Join(t.TempDir(), "gateway.yaml")
+	data := writeConfig(t, path, backend.URL)
+
+	m, err := NewManager(path, nil, slog.Default())
+	if err != nil {
+		t.Fatalf("new manager: %s", err)
+	}
+	gateway := httptest.NewServer(m)
+	t.Cleanup(gateway.Close)
+
+	for _, invalid := range []string{
+		"locations: [",
+		"locations:\n  /api/:\n    url: http://backend/\n    retires: 3\n",
+		"locations:\n  /api/:\n    url: not a url\n",
+		"locations: {}\n",
+	} {
+		if err := os.WriteFile(path, []byte(invalid), 0o600); err != nil {
+			t.Fatalf("write config: %s", err)
+		}
+		if err := m.Reload(); err == nil {
+			t.Fatalf("invalid config %q is loaded", invalid)
+		}
+
+		if got := string(m.Config()); got != string(data) {
+			t.Errorf("active config = %q, want the old one", got)
+		}
+		if got := get(t, gateway.URL+"/api/"); got != "old" {
+			t.Errorf("response = %q, want the old upstream one", got)
+		}
+	}
+
+	status := m.Status()
+	if status.Version != 1 || status.Failures != 4 || status.LastError == "" {
+		t.Errorf("status = %+v, want version 1 with 4 failures and the last error", status)
+	}
+
+	// The next valid config clears the error.
+	writeConfig(t, path, backend.URL)
+	if err := m.Reload(); err != nil {
+		t.Fatalf("reload: %s", err)
+	}
+	if status := m.Status(); status.Version != 2 || status.LastError != "" {
+		t.Errorf("status = %+v, want version 2 without error", status)
+	}
+}
+
+func TestManagerReloadSwapsRoutesWithRequestInFlight(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	oldBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		w.Write([]byte("old"))
+	}))
+	t.Cleanup(oldBackend.Close)
+
+	newBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("new"))
+	}))
+	t.Cleanup(newBackend.Close)
+
+	path := filepath.Join(t.TempDir(), "gateway.yaml")
+	writeConfig(t, path, oldBackend.URL)
+
+	m, err := NewManager(path, nil, slog.Default())
+	if err != nil {
+		t.Fatalf("new manager: %s", err)
+	}
+	gateway := httptest.NewServer(m)
+	t.Cleanup(gateway.Close)
+
+	inFlight := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(gateway.URL + "/api/slow")
+		if err != nil {
+			inFlight <- "error: " + err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		inFlight <- string(body)
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request hasn't reached upstream")
+	}
+
+	writeConfig(t, path, newBackend.URL)
+	if err := m.Reload(); err != nil {
+		t.Fatalf("reload: %s", err)
+	}
+
+	// New requests go to the new upstream at once.
+	if got := get(t, gateway.URL+"/api/"); got != "new" {
+		t.Errorf("response after reload = %q, want the new upstream one", got)
+	}
+
+	// Request in flight is finished by the old router.
+	close(release)
+	select {
+	case got := <-inFlight:
+		if got != "old" {
+			t.Errorf("request in flight got %q, want the old upstream response", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request in flight hasn't finished")
+	}
+}
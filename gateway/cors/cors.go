@@ -0,0 +1,141 @@
+package cors
+
+import (
+	"net/http"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy tells which browser origins may call location and how.
+type Policy struct {
+	// AllowedOrigins are origins like "https://shop.example.com". "*" allows any origin,
+	// "https://*.example.com" allows any subdomain.
+	AllowedOrigins []string
+	AllowedMethods []string
+	// AllowedHeaders are request headers browser may send. "*" allows any header.
+	AllowedHeaders []string
+	// ExposedHeaders are response headers scripts may read besides the safelisted ones.
+	ExposedHeaders []string
+	// AllowCredentials lets browser send cookies and Authorization. Origin is echoed then instead of "*".
+	AllowCredentials bool
+	// MaxAge is how long browser may cache preflight answer. Zero leaves it to browser.
+	MaxAge time.Duration
+}
+
+// IsPreflight tells whether request is CORS preflight rather than ordinary OPTIONS request.
+func IsPreflight(req *http.Request) bool {
+	return req.Method == http.MethodOptions && req.Header.Get("Origin") != "" && req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// RemoveHeaders removes CORS response headers, so upstream ones don't mix with the gateway policy.
+func RemoveHeaders(h http.Header) {
+	for name := range h {
+		if strings.HasPrefix(name, "Access-Control-") {
+			delete(h, name)
+		}
+	}
+}
+
+// SetHeaders sets headers of response to cross-origin request. Nothing but Vary is set for disallowed origins.
+func (p *Policy) SetHeaders(h http.Header, req *http.Request) {
+	h.Add("Vary", "Origin")
+
+	origin := req.Header.Get("Origin")
+	if origin == "" || !p.originAllowed(origin) {
+		return
+	}
+
+	p.setOrigin(h, origin)
+	if len(p.ExposedHeaders) > 0 {
+		h.Set("Access-Control-Expose-Headers", strings.Join(p.ExposedHeaders, ", "))
+	}
+}
+
+// Preflight checks preflight request and sets headers of its answer. It returns false if request isn't allowed.
+func (p *Policy) Preflight(h http.Header, req *http.Request) bool {
+	h.Add("Vary", "Origin")
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+
+	origin := req.Header.Get("Origin")
+	if !p.originAllowed(origin) {
+		return false
+	}
+
+	method := req.Header.Get("Access-Control-Request-Method")
+	if !slices.Contains(p.AllowedMethods, method) {
+		return false
+	}
+
+	requested := requestedHeaders(req)
+	anyHeader := slices.Contains(p.AllowedHeaders, "*")
+	for _, name := range requested {
+		if !anyHeader && !slices.ContainsFunc(p.AllowedHeaders, func(a string) bool { return strings.EqualFold(a, name) }) {
+			return false
+		}
+	}
+
+	p.setOrigin(h, origin)
+	h.Set("Access-Control-Allow-Methods", strings.Join(p.AllowedMethods, ", "))
+	if len(requested) > 0 {
+		// Requested headers are echoed, as "*" doesn't cover Authorization and isn't allowed with credentials.
+		h.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+	}
+	if p.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge.Seconds())))
+	}
+
+	return true
+}
+
+func (p *Policy) setOrigin(h http.Header, origin string) {
+	if slices.Contains(p.AllowedOrigins, "*") && !p.AllowCredentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+
+	if p.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (p *Policy) originAllowed(origin string) bool {
+	return slices.ContainsFunc(p.AllowedOrigins, func(pattern string) bool { return originMatches(pattern, origin) })
+}
+
+func originMatches(pattern, origin string) bool {
+	if pattern == "*" {
+		return true
+	}
+
+	before, after, wildcard := strings.Cut(pattern, "*")
+	if !wildcard {
+		return strings.EqualFold(pattern, origin)
+	}
+
+	origin = strings.ToLower(origin)
+	before, after = strings.ToLower(before), strings.ToLower(after)
+	if len(origin) <= len(before)+len(after) || !strings.HasPrefix(origin, before) || !strings.HasSuffix(origin, after) {
+		return false
+	}
+
+	// Wildcard stands for subdomains only, not for a path or port.
+	sub := origin[len(before) : len(origin)-len(after)]
+	return !strings.ContainsAny(sub, "/:@")
+}
+
+func requestedHeaders(req *http.Request) []string {
+	var res []string
+	for _, field := range req.Header.Values("Access-Control-Request-Headers") {
+		for _, name := range strings.Split(field, ",") {
+			if name = textproto.TrimString(name); name != "" {
+				res = append(res, name)
+			}
+		}
+	}
+	return res
+}
@@ -0,0 +1,151 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func preflight(origin, method, headers string) *http.Request {
+	req := httptest.NewRequest(http.MethodOptions, "/order/all", nil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", method)
+	if headers != "" {
+		req.Header.Set("Access-Control-Request-Headers", headers)
+	}
+	return req
+}
+
+func TestPreflight(t *testing.T) {
+	policy := &Policy{
+		AllowedOrigins:   []string{"https://shop.example.com", "https://*.example.org"},
+		AllowedMethods:   []string{http.MethodGet, http.MethodPost},
+		AllowedHeaders:   []string{"Authorization", "Content-Type"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}
+	anyOrigin := &Policy{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{http.MethodGet},
+		AllowedHeaders: []string{"*"},
+	}
+
+	for _, tt := range []struct {
+		name        string
+		policy      *Policy
+		req         *http.Request
+		wantAllowed bool
+		wantOrigin  string
+		wantHeaders string
+	}{
+		{"allowed", policy, preflight("https://shop.example.com", "POST", "content-type, authorization"),
+			true, "https://shop.example.com", "content-type, authorization"},
+		{"origin case", policy, preflight("HTTPS://Shop.Example.com", "GET", ""), true, "HTTPS://Shop.Example.com", ""},
+		{"subdomain", policy, preflight("https://admin.example.org", "GET", ""), true, "https://admin.example.org", ""},
+		{"nested subdomain", policy, preflight("https://a.b.example.org", "GET", ""), true, "https://a.b.example.org", ""},
+
+		{"other origin", policy, preflight("https://evil.com", "GET", ""), false, "", ""},
+		{"suffix of allowed origin", policy, preflight("https://shop.example.com.evil.com", "GET", ""), false, "", ""},
+		{"bare wildcard domain", policy, preflight("https://example.org", "GET", ""), false, "", ""},
+		{"wildcard over port", policy, preflight("https://evil.com:1@x.example.org", "GET", ""), false, "", ""},
+		{"wildcard over scheme", policy, preflight("http://admin.example.org", "GET", ""), false, "", ""},
+		{"null origin", policy, preflight("null", "GET", ""), false, "", ""},
+		{"method", policy, preflight("https://shop.example.com", "DELETE", ""), false, "", ""},
+		{"method case", policy, preflight("https://shop.example.com", "post", ""), false, "", ""},
+		{"header", policy, preflight("https://shop.example.com", "GET", "Content-Type, X-Debug"), false, "", ""},
+
+		{"any origin", anyOrigin, preflight("https://evil.com", "GET", "X-Debug"), true, "*", "X-Debug"},
+		{"any origin other method", anyOrigin, preflight("https://evil.com", "PUT", ""), false, "", ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			h := make(http.Header)
+			allowed := tt.policy.Preflight(h, tt.req)
+
+			if allowed != tt.wantAllowed {
+				t.Fatalf("allowed = %t, want %t", allowed, tt.wantAllowed)
+			}
+			if got := h.Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := h.Get("Access-Control-Allow-Headers"); got != tt.wantHeaders {
+				t.Errorf("Allow-Headers = %q, want %q", got, tt.wantHeaders)
+			}
+			if got := h.Values("Vary"); len(got) != 3 {
+				t.Errorf("Vary = %v, want Origin and requested method and headers", got)
+			}
+
+			if !allowed {
+				for name := range h {
+					if name != "Vary" {
+						t.Errorf("rejected preflight has %s header", name)
+					}
+				}
+				return
+			}
+
+			wantCredentials, wantMaxAge := "", ""
+			if tt.policy.AllowCredentials {
+				wantCredentials, wantMaxAge = "true", "600"
+			}
+			if got := h.Get("Access-Control-Allow-Credentials"); got != wantCredentials {
+				t.Errorf("Allow-Credentials = %q, want %q", got, wantCredentials)
+			}
+			if got := h.Get("Access-Control-Max-Age"); got != wantMaxAge {
+				t.Errorf("Max-Age = %q, want %q", got, wantMaxAge)
+			}
+		})
+	}
+}
+
+func TestSetHeaders(t *testing.T) {
+	policy := &Policy{
+		AllowedOrigins: []string{"https://shop.example.com"},
+		ExposedHeaders: []string{"X-Request-ID", "RateLimit-Remaining"},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/order/all", nil)
+	req.Header.Set("Origin", "https://shop.example.com")
+	h := make(http.Header)
+	policy.SetHeaders(h, req)
+
+	if got := h.Get("Access-Control-Allow-Origin"); got != "https://shop.example.com" {
+		t.Errorf("Allow-Origin = %q, want the origin", got)
+	}
+	if got := h.Get("Access-Control-Expose-Headers"); got != "X-Request-ID, RateLimit-Remaining" {
+		t.Errorf("Expose-Headers = %q, want exposed headers", got)
+	}
+
+	req.Header.Set("Origin", "https://evil.com")
+	h = make(http.Header)
+	policy.SetHeaders(h, req)
+
+	if len(h) != 1 || h.Get("Vary") != "Origin" {
+		t.Errorf("headers for other origin = %v, want Vary only", h)
+	}
+}
+
+func TestIsPreflight(t *testing.T) {
+	if !IsPreflight(preflight("https://shop.example.com", "GET", "")) {
+		t.Error("preflight isn't recognised")
+	}
+
+	options := httptest.NewRequest(http.MethodOptions, "/order/all", nil)
+	options.Header.Set("Origin", "https://shop.example.com")
+	if IsPreflight(options) {
+		t.Error("OPTIONS without requested method is taken for preflight")
+	}
+}
+
+func TestRemoveHeaders(t *testing.T) {
+	h := http.Header{
+		"Access-Control-Allow-Origin": {"*"},
+		"Access-Control-Max-Age":      {"60"},
+		"Content-Type":                {"application/json"},
+	}
+	RemoveHeaders(h)
+
+	if len(h) != 1 || h.Get("Content-Type") == "" {
+		t.Errorf("headers = %v, want Content-Type only", h)
+	}
+}
@@ -0,0 +1,344 @@
+// Package metrics keeps gateway metrics and serves them in Prometheus text exposition format.
+// Label values must come from a bounded set, e.g. location names rather than request paths.
+package metrics
+
+import (
+	"bufio"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type Type string
+
+const (
+	TypeCounter   Type = "counter"
+	TypeGauge     Type = "gauge"
+	TypeHistogram Type = "histogram"
+)
+
+// DefaultBuckets are upper bounds of request duration histograms in seconds.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type family interface {
+	write(w *bufio.Writer)
+}
+
+// Registry is a set of metrics served together.
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.families = append(r.families, f)
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	families := slices.Clone(r.families)
+	r.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw)
+	}
+	bw.Flush()
+}
+
+// header describes metric family.
+type header struct {
+	name   string
+	help   string
+	typ    Type
+	labels []string
+}
+
+func (h *header) writeHeader(w *bufio.Writer) {
+	w.WriteString("# HELP " + h.name + " " + strings.ReplaceAll(h.help, "\n", " ") + "\n")
+	w.WriteString("# TYPE " + h.name + " " + string(h.typ) + "\n")
+}
+
+// writeSample writes a single line. Extra label, e.g. le of histogram buckets, goes after family labels.
+func (h *header) writeSample(w *bufio.Writer, suffix string, values []string, extraName, extraValue string, v float64) {
+	w.WriteString(h.name + suffix)
+
+	if len(values) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, name := range h.labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			writeLabel(w, name, values[i])
+		}
+		if extraName != "" {
+			if len(values) > 0 {
+				w.WriteByte(',')
+			}
+			writeLabel(w, extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func writeLabel(w *bufio.Writer, name, value string) {
+	w.WriteString(name + `="`)
+	w.WriteString(labelReplacer.Replace(value))
+	w.WriteByte('"')
+}
+
+var labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// vec keeps series of family by their label values.
+type vec[T any] struct {
+	header
+	newSeries func() *T
+
+	mu     sync.RWMutex
+	series map[string]*T
+	values map[string][]string
+}
+
+func newVec[T any](h header, newSeries func() *T) *vec[T] {
+	return &vec[T]{
+		header:    h,
+		newSeries: newSeries,
+		series:    make(map[string]*T),
+		values:    make(map[string][]string),
+	}
+}
+
+func (v *vec[T]) get(values []string) *T {
+	if len(values) != len(v.labels) {
+		panic("metrics: " + v.name + " takes " + strconv.Itoa(len(v.labels)) + " label values")
+	}
+
+	key := strings.Join(values, "\xff")
+
+	v.mu.RLock()
+	s, ok := v.series[key]
+	v.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if s, ok := v.series[key]; ok {
+		return s
+	}
+	s = v.newSeries()
+	v.series[key] = s
+	v.values[key] = slices.Clone(values)
+	return s
+}
+
+// each calls f for series in order of their label values, so output is stable.
+func (v *vec[T]) each(f func(values []string, s *T)) {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	v.mu.RUnlock()
+
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		v.mu.RLock()
+		s, values := v.series[key], v.values[key]
+		v.mu.RUnlock()
+		f(values, s)
+	}
+}
+
+type value struct {
+	bits atomic.Uint64
+}
+
+func (v *value) add(delta float64) {
+	for {
+		old := v.bits.Load()
+		if v.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (v *value) set(x float64) {
+	v.bits.Store(math.Float64bits(x))
+}
+
+func (v *value) load() float64 {
+	return math.Float64frombits(v.bits.Load())
+}
+
+// Counter is a family of values which only grow.
+type Counter struct {
+	*vec[value]
+}
+
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	c := &Counter{newVec(header{name: name, help: help, typ: TypeCounter, labels: labels}, newValue)}
+	r.register(c)
+	return c
+}
+
+func (c *Counter) Inc(values ...string) {
+	c.get(values).add(1)
+}
+
+func (c *Counter) Add(delta float64, values ...string) {
+	if delta < 0 {
+		panic("metrics: counter " + c.name + " can't decrease")
+	}
+	c.get(values).add(delta)
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	c.each(func(values []string, v *value) {
+		c.writeSample(w, "", values, "", "", v.load())
+	})
+}
+
+// Gauge is a family of values which go up and down.
+type Gauge struct {
+	*vec[value]
+}
+
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{newVec(header{name: name, help: help, typ: TypeGauge, labels: labels}, newValue)}
+	r.register(g)
+	return g
+}
+
+func (g *Gauge) Add(delta float64, values ...string) {
+	g.get(values).add(delta)
+}
+
+func (g *Gauge) Set(x float64, values ...string) {
+	g.get(values).set(x)
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.writeHeader(w)
+	g.each(func(values []string, v *value) {
+		g.writeSample(w, "", values, "", "", v.load())
+	})
+}
+
+func newValue() *value {
+	return &value{}
+}
+
+type histogramSeries struct {
+	// counts are observations per bucket, the last one is +Inf.
+	counts []atomic.Uint64
+	sum    value
+}
+
+// Histogram is a family of observation distributions.
+type Histogram struct {
+	*vec[histogramSeries]
+	buckets []float64
+}
+
+// Histogram registers histogram with sorted upper bounds of buckets. +Inf bucket is added implicitly.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+
+	newSeries := func() *histogramSeries {
+		return &histogramSeries{counts: make([]atomic.Uint64, len(buckets)+1)}
+	}
+
+	h := &Histogram{
+		vec:     newVec(header{name: name, help: help, typ: TypeHistogram, labels: labels}, newSeries),
+		buckets: buckets,
+	}
+	r.register(h)
+	return h
+}
+
+func (h *Histogram) Observe(x float64, values ...string) {
+	s := h.get(values)
+
+	i, _ := slices.BinarySearch(h.buckets, x)
+	s.counts[i].Add(1)
+	s.sum.add(x)
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.writeHeader(w)
+	h.each(func(values []string, s *histogramSeries) {
+		var cumulative uint64
+		for i := range s.counts {
+			cumulative += s.counts[i].Load()
+
+			le := math.Inf(1)
+			if i < len(h.buckets) {
+				le = h.buckets[i]
+			}
+			h.writeSample(w, "_bucket", values, "le", formatFloat(le), float64(cumulative))
+		}
+		h.writeSample(w, "_sum", values, "", "", s.sum.load())
+		h.writeSample(w, "_count", values, "", "", float64(cumulative))
+	})
+}
+
+// EmitFunc reports a sample of collected family.
+type EmitFunc func(v float64, values ...string)
+
+type collected struct {
+	header
+	collect func(emit EmitFunc)
+}
+
+// Collect registers family which values are read from collect on every scrape,
+// e.g. state gateway keeps anyway, like upstream health.
+func (r *Registry) Collect(name, help string, typ Type, labels []string, collect func(emit EmitFunc)) {
+	r.register(&collected{
+		header:  header{name: name, help: help, typ: typ, labels: labels},
+		collect: collect,
+	})
+}
+
+func (c *collected) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	c.collect(func(v float64, values ...string) {
+		if len(values) != len(c.labels) {
+			panic("metrics: " + c.name + " takes " + strconv.Itoa(len(c.labels)) + " label values")
+		}
+		c.writeSample(w, "", values, "", "", v)
+	})
+}
@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExposition(t *testing.T) {
+	reg := NewRegistry()
+
+	requests := reg.Counter("requests_total", "Requests.", "location", "code")
+	requests.Inc("/order/", "2xx")
+	requests.Add(2, "/order/", "2xx")
+	requests.Inc(`say "hi"`+"\n", "5xx")
+
+	inFlight := reg.Gauge("in_flight", "Requests in flight.")
+	inFlight.Add(3)
+	inFlight.Add(-1)
+
+	duration := reg.Histogram("duration_seconds", "Duration.", []float64{1, 0.1}, "location")
+	duration.Observe(0.05, "/order/")
+	duration.Observe(0.1, "/order/")
+	duration.Observe(5, "/order/")
+
+	reg.Collect("version", "Version.", TypeGauge, nil, func(emit EmitFunc) {
+		emit(7)
+	})
+
+	rec := httptest.NewRecorder()
+	reg.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+
+	want := `# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{location="/order/",code="2xx"} 3
+requests_total{location="say \"hi\"\n",code="5xx"} 1
+# HELP in_flight Requests in flight.
+# TYPE in_flight gauge
+in_flight 2
+# HELP duration_seconds Duration.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{location="/order/",le="0.1"} 2
+duration_seconds_bucket{location="/order/",le="1"} 2
+duration_seconds_bucket{location="/order/",le="+Inf"} 3
+duration_seconds_sum{location="/order/"} 5.15
+duration_seconds_count{location="/order/"} 3
+# HELP version Version.
+# TYPE version gauge
+version 7
+`
+	if string(body) != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", body, want)
+	}
+}
@@ -0,0 +1,172 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type KeyKind string
+
+const (
+	KeyIP     KeyKind = "ip"
+	KeyUser   KeyKind = "user"
+	KeyAPIKey KeyKind = "api_key"
+)
+
+// Limit is a token bucket: it holds up to Burst tokens and gets Rate tokens per second.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+type Result struct {
+	Allowed   bool
+	Remaining int
+	// Reset is when bucket will be full again.
+	Reset time.Duration
+	// RetryAfter is when the next request will be allowed. It's zero if request is allowed.
+	RetryAfter time.Duration
+}
+
+// Store keeps token buckets. Implementations shared between gateway instances make limits global.
+type Store interface {
+	// Take takes a token from bucket of key if there is one.
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+}
+
+type Rule struct {
+	Key KeyKind
+	// Methods the rule applies to. Empty means any method.
+	Methods []string
+	Limit   Limit
+	// Window is a period limit is configured for. It's reported in RateLimit-Policy header.
+	Window time.Duration
+}
+
+// Applies tells whether rule limits requests with method.
+func (r *Rule) Applies(method string) bool {
+	return len(r.Methods) == 0 || slices.Contains(r.Methods, method)
+}
+
+// RequestKey returns bucket key of request of verified user and API key.
+// Requests without user or API key are limited by IP.
+func (r *Rule) RequestKey(req *http.Request, userID, apiKeyID string) string {
+	switch r.Key {
+	case KeyUser:
+		if userID != "" {
+			return "user:" + userID
+		}
+	case KeyAPIKey:
+		if apiKeyID != "" {
+			return "api_key:" + apiKeyID
+		}
+	}
+
+	return "ip:" + ClientIP(req)
+}
+
+// ClientIP returns address of peer connected to gateway.
+func ClientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// SetHeaders sets RateLimit-* headers of result, and Retry-After if request is rejected.
+func SetHeaders(h http.Header, rule *Rule, res Result) {
+	h.Set("RateLimit-Limit", strconv.Itoa(rule.Limit.Burst))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", rule.Limit.Burst, ceilSeconds(rule.Window)))
+
+	if !res.Allowed {
+		h.Set("Retry-After", strconv.Itoa(max(ceilSeconds(res.RetryAfter), 1)))
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+// MemoryStore keeps buckets in memory of single gateway instance.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// sweepPeriod is how often full buckets are forgotten, they are the same as missing ones.
+const sweepPeriod = time.Minute
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*bucket),
+	}
+}
+
+func (s *MemoryStore) Take(_ context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) > sweepPeriod {
+		s.sweep(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		s.buckets[key] = b
+	}
+	b.limit = limit
+
+	return take(b, limit, now), nil
+}
+
+func take(b *bucket, limit Limit, now time.Time) Result {
+	burst := float64(limit.Burst)
+
+	b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+
+	res := Result{Allowed: b.tokens >= 1}
+	if res.Allowed {
+		b.tokens--
+	} else {
+		res.RetryAfter = seconds((1 - b.tokens) / limit.Rate)
+	}
+
+	res.Remaining = int(b.tokens)
+	res.Reset = seconds((burst - b.tokens) / limit.Rate)
+
+	return res
+}
+
+// sweep forgets buckets which are full by now.
+func (s *MemoryStore) sweep(now time.Time) {
+	s.lastSweep = now
+
+	for key, b := range s.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate >= float64(b.limit.Burst) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
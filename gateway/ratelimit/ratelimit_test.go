@@ -0,0 +1,149 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func TestMemoryStoreRefillsTokens(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	// Bucket of 3 tokens gets one every 500ms.
+	limit := Limit{Rate: 2, Burst: 3}
+
+	for i, want := range []Result{
+		{Allowed: true, Remaining: 2, Reset: 500 * time.Millisecond},
+		{Allowed: true, Remaining: 1, Reset: time.Second},
+		{Allowed: true, Remaining: 0, Reset: 1500 * time.Millisecond},
+		{Allowed: false, Remaining: 0, Reset: 1500 * time.Millisecond, RetryAfter: 500 * time.Millisecond},
+	} {
+		res, err := store.Take(ctx, "ip:10.0.0.1", limit, testNow)
+		if err != nil {
+			t.Fatalf("take: %s", err)
+		}
+		if res != want {
+			t.Errorf("take %d = %+v, want %+v", i+1, res, want)
+		}
+	}
+
+	// Other keys have their own buckets.
+	if res, _ := store.Take(ctx, "ip:10.0.0.2", limit, testNow); !res.Allowed {
+		t.Error("other key is limited")
+	}
+
+	// Half of token isn't enough.
+	res, _ := store.Take(ctx, "ip:10.0.0.1", limit, testNow.Add(250*time.Millisecond))
+	if res.Allowed || res.RetryAfter != 250*time.Millisecond {
+		t.Errorf("take after 250ms = %+v, want rejected with retry after 250ms", res)
+	}
+
+	res, _ = store.Take(ctx, "ip:10.0.0.1", limit, testNow.Add(500*time.Millisecond))
+	if !res.Allowed || res.Remaining != 0 {
+		t.Errorf("take after 500ms = %+v, want allowed with no tokens left", res)
+	}
+
+	// Bucket doesn't grow over burst.
+	later := testNow.Add(time.Hour)
+	for i := range limit.Burst {
+		if res, _ := store.Take(ctx, "ip:10.0.0.1", limit, later); !res.Allowed {
+			t.Fatalf("take %d after an hour is rejected", i+1)
+		}
+	}
+	if res, _ := store.Take(ctx, "ip:10.0.0.1", limit, later); res.Allowed {
+		t.Error("bucket holds more than burst")
+	}
+}
+
+func TestMemoryStoreSweepsFullBuckets(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	// A token takes 100s to come.
+	limit := Limit{Rate: 0.01, Burst: 10}
+
+	store.Take(ctx, "fast", Limit{Rate: 100, Burst: 10}, testNow)
+	store.Take(ctx, "slow", limit, testNow)
+	for range 10 {
+		store.Take(ctx, "empty", limit, testNow)
+	}
+
+	// Sweep runs on the first take after sweepPeriod. Fast bucket is full by then, the others aren't.
+	now := testNow.Add(sweepPeriod + time.Second)
+	store.Take(ctx, "new", limit, now)
+
+	for key, want := range map[string]bool{"fast": false, "slow": true, "empty": true, "new": true} {
+		if _, ok := store.buckets[key]; ok != want {
+			t.Errorf("bucket %q kept = %t, want %t", key, ok, want)
+		}
+	}
+
+	// Swept bucket starts full, as it would be anyway.
+	res, _ := store.Take(ctx, "fast", limit, now)
+	if res.Remaining != limit.Burst-1 {
+		t.Errorf("swept bucket remaining = %d, want %d", res.Remaining, limit.Burst-1)
+	}
+}
+
+func TestSetHeaders(t *testing.T) {
+	rule := &Rule{Limit: Limit{Rate: 1, Burst: 60}, Window: time.Minute}
+
+	for _, tt := range []struct {
+		name           string
+		res            Result
+		wantReset      string
+		wantRetryAfter string
+	}{
+		{"allowed", Result{Allowed: true, Remaining: 59, Reset: time.Second}, "1", ""},
+		{"rejected", Result{Remaining: 0, Reset: 60 * time.Second, RetryAfter: 1500 * time.Millisecond}, "60", "2"},
+		{"rejected for less than second", Result{Reset: 59 * time.Second, RetryAfter: time.Millisecond}, "59", "1"},
+		{"rejected without wait", Result{Reset: 59 * time.Second}, "59", "1"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			h := make(http.Header)
+			SetHeaders(h, rule, tt.res)
+
+			if got := h.Get("RateLimit-Limit"); got != "60" {
+				t.Errorf("RateLimit-Limit = %q, want 60", got)
+			}
+			if got := h.Get("RateLimit-Policy"); got != "60;w=60" {
+				t.Errorf("RateLimit-Policy = %q, want 60;w=60", got)
+			}
+			if got := h.Get("RateLimit-Reset"); got != tt.wantReset {
+				t.Errorf("RateLimit-Reset = %q, want %q", got, tt.wantReset)
+			}
+			if got := h.Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantRetryAfter)
+			}
+		})
+	}
+}
+
+func TestRuleRequestKey(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/order/all", nil)
+	req.RemoteAddr = "10.0.0.1:51234"
+
+	for _, tt := range []struct {
+		name     string
+		kind     KeyKind
+		userID   string
+		apiKeyID string
+		want     string
+	}{
+		{"ip", KeyIP, "alice", "key", "ip:10.0.0.1"},
+		{"user", KeyUser, "alice", "", "user:alice"},
+		{"anonymous user", KeyUser, "", "", "ip:10.0.0.1"},
+		{"API key", KeyAPIKey, "", "key", "api_key:key"},
+		{"no API key", KeyAPIKey, "alice", "", "ip:10.0.0.1"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := &Rule{Key: tt.kind}
+			if got := rule.RequestKey(req, tt.userID, tt.apiKeyID); got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
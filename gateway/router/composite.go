@@ -0,0 +1,285 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sunnyyssh/designing-software-cw3/gateway/auth"
+	"github.com/sunnyyssh/designing-software-cw3/gateway/upstream"
+)
+
+// maxSectionBody is the largest section response merged into composite one.
+const maxSectionBody = 1 << 20
+
+// Composite answers with one JSON document merged of sections fetched concurrently from other locations,
+// e.g. account balance and recent orders of the user. Failed optional sections are reported in "errors"
+// field of the document instead of failing the whole response.
+type Composite struct {
+	Sections []Section
+	// Timeout limits fetching all sections.
+	Timeout time.Duration
+}
+
+// Section is a GET request to gateway location, its JSON response is put under Name.
+type Section struct {
+	Name string
+	// Path is a gateway path with optional query. It may contain placeholders, see Placeholders.
+	Path string
+	// Required section fails the whole response when it fails.
+	Required bool
+}
+
+// SectionError describes failed section. Code is HTTP status the section has failed with.
+type SectionError struct {
+	Error string `json:"error"`
+	Code  int    `json:"code"`
+}
+
+var placeholderRe = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// Placeholders returns names of placeholders in section path. {user_id} is the verified user,
+// {query.NAME} is query parameter of client request, {NAME} is named group of location regex.
+func Placeholders(path string) []string {
+	var names []string
+	for _, m := range placeholderRe.FindAllStringSubmatch(path, -1) {
+		names = append(names, m[1])
+	}
+	return names
+}
+
+// expand substitutes placeholders of section path. Values are escaped, so they can't change the path they are put in.
+func (s *Section) expand(req *http.Request, loc *Location) string {
+	var groups []string
+	if loc.Match.Regex != nil {
+		groups = loc.Match.Regex.FindStringSubmatch(req.URL.Path)
+	}
+
+	value := func(name string) string {
+		if name == "user_id" {
+			// Gateway has verified the user by now.
+			return req.Header.Get(auth.HeaderUserID)
+		}
+		if param, ok := strings.CutPrefix(name, "query."); ok {
+			return req.URL.Query().Get(param)
+		}
+		if groups == nil {
+			return ""
+		}
+		if i := loc.Match.Regex.SubexpIndex(name); i > 0 && i < len(groups) {
+			return groups[i]
+		}
+		return ""
+	}
+
+	path, query, hasQuery := strings.Cut(s.Path, "?")
+
+	path = placeholderRe.ReplaceAllStringFunc(path, func(m string) string {
+		return url.PathEscape(value(m[1 : len(m)-1]))
+	})
+	if !hasQuery {
+		return path
+	}
+
+	query = placeholderRe.ReplaceAllStringFunc(query, func(m string) string {
+		return url.QueryEscape(value(m[1 : len(m)-1]))
+	})
+	return path + "?" + query
+}
+
+// sectionResult is either body of section or its error.
+type sectionResult struct {
+	body json.RawMessage
+	err  *SectionError
+	// header holds cookies of split groups the section was routed to.
+	header http.Header
+}
+
+// serveComposite fetches sections of composite location and writes merged document.
+func (r *Router) serveComposite(w http.ResponseWriter, req *http.Request, loc *Location, logger *slog.Logger) {
+	ctx, cancel := context.WithTimeout(req.Context(), loc.Composite.Timeout)
+	defer cancel()
+
+	sections := loc.Composite.Sections
+	results := make([]sectionResult, len(sections))
+
+	var wg sync.WaitGroup
+	for i := range sections {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = r.fetchSection(ctx, req, loc, &sections[i], logger.With("section", sections[i].Name))
+		}()
+	}
+	wg.Wait()
+
+	for i, res := range results {
+		for _, cookie := range res.header.Values("Set-Cookie") {
+			w.Header().Add("Set-Cookie", cookie)
+		}
+
+		if res.err != nil {
+			r.metrics.sections.Inc(loc.Name, sections[i].Name, "error")
+		} else {
+			r.metrics.sections.Inc(loc.Name, sections[i].Name, "ok")
+		}
+	}
+
+	for i, res := range results {
+		if res.err != nil && sections[i].Required {
+			logger.WarnContext(req.Context(), "required section failed", "section", sections[i].Name, "code", res.err.Code)
+			writeJSON(w, res.err.Code, SectionError{Error: sections[i].Name + ": " + res.err.Error, Code: res.err.Code})
+			return
+		}
+	}
+
+	var buf bytes.Buffer
+	errs := make(map[string]*SectionError)
+
+	buf.WriteByte('{')
+	for i, res := range results {
+		name, _ := json.Marshal(sections[i].Name)
+		buf.Write(name)
+		buf.WriteByte(':')
+
+		if res.err != nil {
+			errs[sections[i].Name] = res.err
+			buf.WriteString("null")
+		} else {
+			// Body is valid JSON, so only whitespace is removed.
+			json.Compact(&buf, res.body)
+		}
+		buf.WriteByte(',')
+	}
+
+	// Errors are always there, so clients needn't check whether the field is present.
+	encodedErrs, _ := json.Marshal(errs)
+	buf.WriteString(`"errors":`)
+	buf.Write(encodedErrs)
+	buf.WriteByte('}')
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		logger.ErrorContext(req.Context(), "failed to write response", "error", err)
+		return
+	}
+
+	logger.InfoContext(req.Context(), "request served", "code", http.StatusOK, "failed_sections", len(errs))
+}
+
+// fetchSection routes section request to its location as if client sent it, so auth rules of location apply.
+func (r *Router) fetchSection(
+	ctx context.Context, baseReq *http.Request, loc *Location, section *Section, logger *slog.Logger,
+) sectionResult {
+	res := sectionResult{header: make(http.Header)}
+
+	fail := func(code int, msg string) sectionResult {
+		res.err = &SectionError{Error: msg, Code: code}
+		return res
+	}
+
+	target, err := url.Parse(section.expand(baseReq, loc))
+	if err != nil {
+		return fail(http.StatusBadGateway, "invalid section path")
+	}
+
+	req := baseReq.Clone(ctx)
+	req.Method = http.MethodGet
+	req.URL.Path, req.URL.RawPath, req.URL.RawQuery = target.Path, target.RawPath, target.RawQuery
+	req.RequestURI = ""
+	req.Body = http.NoBody
+	req.ContentLength = 0
+	// Section body is merged as is, so it must come plain and complete.
+	for _, name := range []string{"Accept-Encoding", "Range", "If-None-Match", "If-Modified-Since", "Content-Type"} {
+		req.Header.Del(name)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	sectionLoc := r.match(req)
+	switch {
+	case sectionLoc == nil:
+		return fail(http.StatusNotFound, "no location for section")
+	case sectionLoc.Composite != nil:
+		return fail(http.StatusBadGateway, "section can't be composite")
+	}
+
+	if _, code := r.verify(req, sectionLoc, logger); code != 0 {
+		return fail(code, http.StatusText(code))
+	}
+
+	pool := sectionLoc.Upstreams
+	if sectionLoc.Split != nil {
+		pool = sectionLoc.Split.pick(res.header, req, sectionLoc.Name).Upstreams
+	}
+
+	resp, up, err := r.roundTrip(req, sectionLoc, pool, sectionLoc.Rewrite.apply(req.URL.Path), logger)
+	switch {
+	case errors.Is(err, upstream.ErrNoHealthyUpstream) || errors.Is(err, upstream.ErrCircuitOpen):
+		logger.WarnContext(ctx, "no upstream to route section", "location", sectionLoc.Name, "error", err)
+		return fail(http.StatusServiceUnavailable, "Service unavailable")
+	case isTimeout(err):
+		logger.WarnContext(ctx, "section timed out", "location", sectionLoc.Name, "error", err)
+		return fail(http.StatusGatewayTimeout, "Gateway timeout")
+	case err != nil:
+		logger.WarnContext(ctx, "failed to route section", "location", sectionLoc.Name, "error", err)
+		return fail(http.StatusBadGateway, "Bad gateway")
+	}
+	defer resp.Body.Close()
+	defer pool.Release(up, resp.StatusCode, nil)
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSectionBody+1))
+	switch {
+	case isTimeout(err):
+		return fail(http.StatusGatewayTimeout, "Gateway timeout")
+	case err != nil:
+		logger.WarnContext(ctx, "failed to read section", "location", sectionLoc.Name, "error", err)
+		return fail(http.StatusBadGateway, "Bad gateway")
+	case len(body) > maxSectionBody:
+		return fail(http.StatusBadGateway, "section response is too large")
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		logger.InfoContext(ctx, "section failed", "location", sectionLoc.Name, "code", resp.StatusCode)
+
+		// Services answer errors as {"error": ..., "code": ...}, so their message is kept.
+		var upstreamErr SectionError
+		if json.Unmarshal(body, &upstreamErr) != nil || upstreamErr.Error == "" {
+			upstreamErr.Error = http.StatusText(resp.StatusCode)
+		}
+		code := resp.StatusCode
+		if code < 400 {
+			// Redirects and alike can't be followed inside composite response.
+			code = http.StatusBadGateway
+		}
+		return fail(code, upstreamErr.Error)
+	}
+
+	if !json.Valid(body) {
+		return fail(http.StatusBadGateway, "section response is not JSON")
+	}
+
+	res.body = body
+	return res
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, err = w.Write(body)
+	return err
+}
@@ -0,0 +1,87 @@
+package router
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/sunnyyssh/designing-software-cw3/gateway/upstream"
+)
+
+func TestComposite(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/profile/a%20b":
+			if got := r.URL.Query().Get("fields"); got != "x&y" {
+				t.Errorf("fields = %q, want x&y", got)
+			}
+			w.Write([]byte(`{ "name": "Ann" }`))
+		case "/orders":
+			w.Write([]byte(`[1, 2]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"no such thing","code":404}`))
+		}
+	}))
+	t.Cleanup(backend.Close)
+
+	pool, err := upstream.NewPool(&upstream.Config{Targets: []upstream.Target{{URL: backend.URL + "/"}}}, slog.Default())
+	if err != nil {
+		t.Fatalf("new pool: %s", err)
+	}
+
+	newGateway := func(sections ...Section) *httptest.Server {
+		gateway := httptest.NewServer(New(&Config{
+			Locations: []Location{
+				{
+					Name:      "/api/",
+					Match:     Match{Prefix: "/api/"},
+					Rewrite:   Rewrite{StripPrefix: "/api/"},
+					Upstreams: pool,
+				},
+				{
+					Name:      "overview",
+					Match:     Match{Regex: regexp.MustCompile(`^/users/(?P<id>[^/]+)/overview$`)},
+					Composite: &Composite{Sections: sections, Timeout: time.Second},
+				},
+			},
+		}, slog.Default()))
+		t.Cleanup(gateway.Close)
+		return gateway
+	}
+
+	get := func(gateway *httptest.Server) (int, string) {
+		resp, err := http.Get(gateway.URL + "/users/a%20b/overview?fields=x%26y")
+		if err != nil {
+			t.Fatalf("get: %s", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	gateway := newGateway(
+		Section{Name: "profile", Path: "/api/profile/{id}?fields={query.fields}"},
+		Section{Name: "orders", Path: "/api/orders"},
+		Section{Name: "reviews", Path: "/api/reviews"},
+	)
+	code, body := get(gateway)
+	want := `{"profile":{"name":"Ann"},"orders":[1,2],"reviews":null,"errors":{"reviews":{"error":"no such thing","code":404}}}`
+	if code != http.StatusOK || body != want {
+		t.Errorf("got %d %s, want 200 %s", code, body, want)
+	}
+
+	gateway = newGateway(
+		Section{Name: "orders", Path: "/api/orders"},
+		Section{Name: "reviews", Path: "/api/reviews", Required: true},
+	)
+	code, body = get(gateway)
+	want = `{"error":"reviews: no such thing","code":404}`
+	if code != http.StatusNotFound || body != want {
+		t.Errorf("got %d %s, want 404 %s", code, body, want)
+	}
+}
@@ -0,0 +1,173 @@
+package router
+
+import (
+	"cmp"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Match selects requests of location. Exactly one of Exact, Prefix and Regex is set, other conditions are optional.
+type Match struct {
+	Exact  string
+	Prefix string
+	Regex  *regexp.Regexp
+
+	// Methods the location serves. Empty means any method.
+	Methods []string
+	// Hosts are request hosts without port. "*.example.com" matches any subdomain. Empty means any host.
+	Hosts []string
+	// Headers must all match.
+	Headers []HeaderMatch
+}
+
+// HeaderMatch checks request header. Header must be present if neither Value nor Regex is set.
+type HeaderMatch struct {
+	Name  string
+	Value string
+	Regex *regexp.Regexp
+}
+
+// rank orders kinds of path matchers: exact paths are tried first, then regexes, then prefixes.
+func (m *Match) rank() int {
+	switch {
+	case m.Exact != "":
+		return 0
+	case m.Regex != nil:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// conditions is a number of non-path conditions. Location with more of them is more specific.
+func (m *Match) conditions() int {
+	n := len(m.Headers)
+	if len(m.Methods) > 0 {
+		n++
+	}
+	if len(m.Hosts) > 0 {
+		n++
+	}
+	return n
+}
+
+func (m *Match) matches(req *http.Request) bool {
+	path := req.URL.Path
+
+	switch {
+	case m.Exact != "":
+		if path != m.Exact {
+			return false
+		}
+	case m.Prefix != "":
+		if !strings.HasPrefix(path, m.Prefix) {
+			return false
+		}
+	case m.Regex != nil:
+		if !m.Regex.MatchString(path) {
+			return false
+		}
+	default:
+		return false
+	}
+
+	if len(m.Methods) > 0 && !slices.Contains(m.Methods, req.Method) {
+		return false
+	}
+
+	if len(m.Hosts) > 0 && !slices.ContainsFunc(m.Hosts, func(h string) bool { return hostMatches(h, req.Host) }) {
+		return false
+	}
+
+	for _, h := range m.Headers {
+		if !h.matches(req.Header) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (h *HeaderMatch) matches(header http.Header) bool {
+	values := header.Values(h.Name)
+
+	switch {
+	case h.Regex != nil:
+		return slices.ContainsFunc(values, h.Regex.MatchString)
+	case h.Value != "":
+		return slices.Contains(values, h.Value)
+	default:
+		return len(values) > 0
+	}
+}
+
+func hostMatches(pattern, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return len(host) > len(suffix)+1 && strings.HasSuffix(strings.ToLower(host), "."+strings.ToLower(suffix))
+	}
+	return strings.EqualFold(pattern, host)
+}
+
+// compareLocations orders locations in the order they are tried: by matcher kind, longer prefixes first,
+// then more specific ones first, then by name, so routing never depends on config order.
+func compareLocations(a, b Location) int {
+	return cmp.Or(
+		cmp.Compare(a.Match.rank(), b.Match.rank()),
+		cmp.Compare(len(b.Match.Prefix), len(a.Match.Prefix)),
+		cmp.Compare(b.Match.conditions(), a.Match.conditions()),
+		cmp.Compare(a.Name, b.Name),
+	)
+}
+
+// Rewrite changes path routed to upstream. Steps are applied in order of fields.
+type Rewrite struct {
+	// StripPrefix is removed from path if it's there.
+	StripPrefix string
+	// Replace replaces matches in path with With, which may refer to groups as $1.
+	Replace *regexp.Regexp
+	With    string
+	// AddPrefix is prepended to path.
+	AddPrefix string
+}
+
+// apply returns rewritten path. It always starts with /.
+func (rw *Rewrite) apply(path string) string {
+	path = strings.TrimPrefix(path, rw.StripPrefix)
+	if rw.Replace != nil {
+		path = rw.Replace.ReplaceAllString(path, rw.With)
+	}
+	path = rw.AddPrefix + path
+
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+// HeaderRules change headers of requests to upstream and responses to client. Headers are removed first,
+// then Set ones replace values.
+type HeaderRules struct {
+	Request  HeaderEdit
+	Response HeaderEdit
+}
+
+type HeaderEdit struct {
+	Set    map[string]string
+	Remove []string
+}
+
+func (e *HeaderEdit) apply(h http.Header) {
+	for _, name := range e.Remove {
+		h.Del(name)
+	}
+	for name, val := range e.Set {
+		h.Set(name, val)
+	}
+}
@@ -0,0 +1,129 @@
+package router
+
+import (
+	"cmp"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	r := New(&Config{Locations: []Location{
+		{Name: "/order/", Match: Match{Prefix: "/order/"}},
+		{Name: "/order/product/", Match: Match{Prefix: "/order/product/"}},
+		{Name: "order-health", Match: Match{Exact: "/order/health"}},
+		{Name: "order-events", Match: Match{Regex: regexp.MustCompile(`^/order/[0-9a-f-]+/events$`)}},
+		{Name: "order-delete", Match: Match{Prefix: "/order/", Methods: []string{http.MethodDelete}}},
+		{Name: "canary", Match: Match{Prefix: "/order/", Headers: []HeaderMatch{{Name: "X-Canary", Value: "1"}}}},
+		{Name: "api-host", Match: Match{Prefix: "/", Hosts: []string{"api.example.com", "*.api.example.com"}}},
+		{Name: "mobile", Match: Match{Prefix: "/m/", Headers: []HeaderMatch{{Name: "User-Agent", Regex: regexp.MustCompile(`Mobile`)}}}},
+		{Name: "debug", Match: Match{Prefix: "/debug/", Headers: []HeaderMatch{{Name: "X-Debug"}}}},
+	}}, slog.Default())
+
+	tests := []struct {
+		name    string
+		method  string
+		host    string
+		path    string
+		headers map[string]string
+		want    string
+	}{
+		{name: "prefix", path: "/order/all", want: "/order/"},
+		{name: "longest prefix", path: "/order/product/1", want: "/order/product/"},
+		{name: "exact before prefix", path: "/order/health", want: "order-health"},
+		{name: "exact is exact", path: "/order/health/deep", want: "/order/"},
+		{name: "regex before prefix", path: "/order/3f2a-11/events", want: "order-events"},
+		{name: "regex not matching", path: "/order/3f2a-11/events/x", want: "/order/"},
+		{name: "method", method: http.MethodDelete, path: "/order/1", want: "order-delete"},
+		{name: "other method", method: http.MethodPost, path: "/order/1", want: "/order/"},
+		{name: "header value", path: "/order/1", headers: map[string]string{"X-Canary": "1"}, want: "canary"},
+		{name: "other header value", path: "/order/1", headers: map[string]string{"X-Canary": "0"}, want: "/order/"},
+		{name: "tie by name", method: http.MethodDelete, path: "/order/1", headers: map[string]string{"X-Canary": "1"}, want: "canary"},
+		{name: "header regex", path: "/m/feed", headers: map[string]string{"User-Agent": "Foo Mobile"}, want: "mobile"},
+		{name: "header regex not matching", path: "/m/feed", headers: map[string]string{"User-Agent": "Foo"}, want: ""},
+		{name: "header presence", path: "/debug/vars", headers: map[string]string{"X-Debug": "yes"}, want: "debug"},
+		{name: "header absence", path: "/debug/vars", want: ""},
+		{name: "host", host: "api.example.com", path: "/x", want: "api-host"},
+		{name: "host with port", host: "API.example.com:8080", path: "/x", want: "api-host"},
+		{name: "wildcard host", host: "eu.api.example.com", path: "/x", want: "api-host"},
+		{name: "wildcard is not apex", host: "evil-api.example.com", path: "/x", want: ""},
+		{name: "other host", host: "shop.example.com", path: "/x", want: ""},
+		{name: "prefix is not segment", path: "/orders", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(cmp.Or(tt.method, http.MethodGet), tt.path, nil)
+			req.Host = cmp.Or(tt.host, "gateway")
+			for name, val := range tt.headers {
+				req.Header.Set(name, val)
+			}
+
+			got := ""
+			if loc := r.match(req); loc != nil {
+				got = loc.Name
+			}
+			if got != tt.want {
+				t.Errorf("match = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		rewrite Rewrite
+		path    string
+		want    string
+	}{
+		{name: "none", path: "/order/all", want: "/order/all"},
+		{name: "strip", rewrite: Rewrite{StripPrefix: "/order/"}, path: "/order/product/all", want: "/product/all"},
+		{name: "strip whole path", rewrite: Rewrite{StripPrefix: "/order/"}, path: "/order/", want: "/"},
+		{name: "strip missing prefix", rewrite: Rewrite{StripPrefix: "/order/"}, path: "/payment/", want: "/payment/"},
+		{
+			name:    "replace",
+			rewrite: Rewrite{Replace: regexp.MustCompile(`^/v1/(\w+)/(.*)$`), With: "/$1/api/$2"},
+			path:    "/v1/order/all",
+			want:    "/order/api/all",
+		},
+		{name: "add prefix", rewrite: Rewrite{AddPrefix: "/internal"}, path: "/all", want: "/internal/all"},
+		{
+			name:    "all steps in order",
+			rewrite: Rewrite{StripPrefix: "/shop", Replace: regexp.MustCompile(`^/orders`), With: "/order", AddPrefix: "/v2"},
+			path:    "/shop/orders/1",
+			want:    "/v2/order/1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rewrite.apply(tt.path); got != tt.want {
+				t.Errorf("apply(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeaderEdit(t *testing.T) {
+	edit := HeaderEdit{
+		Set:    map[string]string{"X-Gateway": "shop", "X-Version": "2"},
+		Remove: []string{"Cookie", "X-Version"},
+	}
+
+	h := http.Header{}
+	h.Set("Cookie", "session=1")
+	h.Set("X-Version", "1")
+	h.Set("Accept", "application/json")
+
+	edit.apply(h)
+
+	want := map[string]string{"Cookie": "", "X-Version": "2", "X-Gateway": "shop", "Accept": "application/json"}
+	for name, val := range want {
+		if got := h.Get(name); got != val {
+			t.Errorf("%s = %q, want %q", name, got, val)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package router
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sunnyyssh/designing-software-cw3/gateway/metrics"
+)
+
+// unmatched labels requests no location has matched.
+const unmatched = "unmatched"
+
+// Metrics of routed requests. They are labelled by location name, method and status class,
+// never by path, so number of series is bounded by config.
+type Metrics struct {
+	requests       *metrics.Counter
+	duration       *metrics.Histogram
+	inFlight       *metrics.Gauge
+	upstreamErrors *metrics.Counter
+	retries        *metrics.Counter
+	groupRequests  *metrics.Counter
+	groupDuration  *metrics.Histogram
+	mirrors        *metrics.Counter
+	sections       *metrics.Counter
+}
+
+func NewMetrics(reg *metrics.Registry) *Metrics {
+	return &Metrics{
+		requests: reg.Counter("gateway_requests_total",
+			"Requests served by gateway.", "location", "method", "code"),
+		duration: reg.Histogram("gateway_request_duration_seconds",
+			"Time from receiving request till its response is written.", metrics.DefaultBuckets, "location", "method", "code"),
+		inFlight: reg.Gauge("gateway_requests_in_flight",
+			"Requests being served.", "location"),
+		upstreamErrors: reg.Counter("gateway_upstream_errors_total",
+			"Failed upstream calls: connection errors, timeouts and 502, 503 or 504 answers.", "location", "upstream", "reason"),
+		retries: reg.Counter("gateway_upstream_retries_total",
+			"Requests sent to upstream again after failed attempt.", "location"),
+		groupRequests: reg.Counter("gateway_split_requests_total",
+			"Requests routed to split groups.", "location", "group", "code"),
+		groupDuration: reg.Histogram("gateway_split_request_duration_seconds",
+			"Time from picking split group till response is written.", metrics.DefaultBuckets, "location", "group", "code"),
+		mirrors: reg.Counter("gateway_mirror_requests_total",
+			"Sampled requests by outcome of mirroring: sent, match, mismatch, error, skipped or dropped.", "location", "result"),
+		sections: reg.Counter("gateway_composite_sections_total",
+			"Sections fetched for composite locations by result: ok or error.", "location", "section", "result"),
+	}
+}
+
+// start counts request in flight. Returned function records it as served with status of w.
+func (m *Metrics) start(location, method string, w *statusWriter) func() {
+	began := time.Now()
+	m.inFlight.Add(1, location)
+
+	return func() {
+		m.inFlight.Add(-1, location)
+
+		code := statusClass(w.status)
+		m.requests.Inc(location, methodLabel(method), code)
+		m.duration.Observe(time.Since(began).Seconds(), location, methodLabel(method), code)
+	}
+}
+
+// startGroup records request routed to split group, so canary may be compared with stable group.
+func (m *Metrics) startGroup(location, group string, w *statusWriter) func() {
+	began := time.Now()
+
+	return func() {
+		code := statusClass(w.status)
+		m.groupRequests.Inc(location, group, code)
+		m.groupDuration.Observe(time.Since(began).Seconds(), location, group, code)
+	}
+}
+
+// upstreamError records failed attempt. Attempts cancelled by client aren't upstream errors.
+func (m *Metrics) upstreamError(location, url string, status int, err error) {
+	switch {
+	case err != nil && isTimeout(err):
+		m.upstreamErrors.Inc(location, url, "timeout")
+	case err != nil:
+		m.upstreamErrors.Inc(location, url, "connection")
+	case isRetriable(status):
+		m.upstreamErrors.Inc(location, url, strconv.Itoa(status))
+	}
+}
+
+// methodLabel keeps methods label bounded: clients may send anything as a method.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions, http.MethodConnect, http.MethodTrace:
+		return method
+	default:
+		return "OTHER"
+	}
+}
+
+func statusClass(status int) string {
+	if status == 0 {
+		// Nothing written means empty 200.
+		status = http.StatusOK
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// statusWriter remembers response status for metrics.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	// Informational responses are followed by the final one.
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController flush the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,204 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/sunnyyssh/designing-software-cw3/gateway/cache"
+	"github.com/sunnyyssh/designing-software-cw3/gateway/upstream"
+)
+
+// maxMirrorInFlight bounds shadow requests of location, so slow shadow doesn't pile up goroutines.
+// Requests over the limit aren't mirrored.
+const maxMirrorInFlight = 100
+
+// maxCompareBody is the largest response body compared. Larger ones are compared by status only.
+const maxCompareBody = 1 << 20
+
+// Mirror sends copies of location requests to shadow upstreams and discards their responses,
+// so a new version may be tried on real traffic without affecting clients.
+type Mirror struct {
+	Upstreams *upstream.Pool
+	// Percent of requests mirrored, from 0 to 100.
+	Percent float64
+	// AllowUnsafe mirrors requests changing state, e.g. POST. Only GET, HEAD, OPTIONS and TRACE are mirrored otherwise.
+	AllowUnsafe bool
+	// Compare logs difference of status and body between primary and shadow responses.
+	Compare bool
+	// Timeout limits the whole shadow request.
+	Timeout time.Duration
+
+	client   *http.Client
+	inFlight chan struct{}
+}
+
+// primaryResult is what client got, shadow response is compared with it.
+type primaryResult struct {
+	// status is zero if primary upstream failed.
+	status int
+	body   []byte
+	// recorded is false if body wasn't recorded completely.
+	recorded bool
+}
+
+// sampled tells whether request is mirrored.
+func (m *Mirror) sampled(req *http.Request) bool {
+	if !m.AllowUnsafe && !isSafe(req.Method) {
+		return false
+	}
+	return rand.Float64()*100 < m.Percent
+}
+
+// isSafe tells whether request with method doesn't change state of upstream.
+func isSafe(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
+
+// startMirror sends copy of request to shadow upstream in background. Request body is buffered for both
+// upstreams, so requests with bodies of unknown length or larger than maxReplayBody aren't mirrored.
+// Returned function must be called with primary result when it's known. It's nil if request isn't mirrored.
+func (r *Router) startMirror(req *http.Request, loc *Location, path string, logger *slog.Logger) func(primaryResult) {
+	m := loc.Mirror
+
+	var body []byte
+	if req.ContentLength != 0 {
+		if req.ContentLength < 0 || req.ContentLength > maxReplayBody {
+			r.metrics.mirrors.Inc(loc.Name, "skipped")
+			return nil
+		}
+
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			r.metrics.mirrors.Inc(loc.Name, "skipped")
+			return nil
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	select {
+	case m.inFlight <- struct{}{}:
+	default:
+		r.metrics.mirrors.Inc(loc.Name, "dropped")
+		return nil
+	}
+
+	target, err := m.Upstreams.Acquire(req)
+	if err != nil {
+		<-m.inFlight
+		r.metrics.mirrors.Inc(loc.Name, "error")
+		return nil
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+
+	shadowReq, err := buildReq(req, target, path, reqBody, &loc.Headers.Request)
+	if err != nil {
+		m.Upstreams.Release(target, 0, nil)
+		<-m.inFlight
+		r.metrics.mirrors.Inc(loc.Name, "error")
+		return nil
+	}
+
+	// Shadow outlives client request, so it isn't cancelled with it.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(req.Context()), m.Timeout)
+	shadowReq = shadowReq.WithContext(ctx)
+
+	primary := make(chan primaryResult, 1)
+	logger = logger.With("shadow", target.URL)
+
+	go func() {
+		defer func() { <-m.inFlight }()
+		defer cancel()
+
+		status, shadowBody, err := r.shadowRoundTrip(shadowReq, m, target)
+		if err != nil {
+			r.metrics.mirrors.Inc(loc.Name, "error")
+			logger.WarnContext(ctx, "shadow request failed", "error", err)
+			return
+		}
+
+		if !m.Compare {
+			r.metrics.mirrors.Inc(loc.Name, "sent")
+			return
+		}
+
+		res := <-primary
+		if res.status == 0 {
+			// Primary failed, there is nothing to compare with.
+			r.metrics.mirrors.Inc(loc.Name, "sent")
+			return
+		}
+
+		bodyCompared := res.recorded && shadowBody != nil
+		if res.status == status && (!bodyCompared || sameBody(res.body, shadowBody)) {
+			r.metrics.mirrors.Inc(loc.Name, "match")
+			logger.DebugContext(ctx, "shadow response matches", "code", status)
+			return
+		}
+
+		r.metrics.mirrors.Inc(loc.Name, "mismatch")
+		logger.InfoContext(ctx, "shadow response differs",
+			"code", res.status, "shadow_code", status,
+			"body_compared", bodyCompared, "size", len(res.body), "shadow_size", len(shadowBody))
+	}()
+
+	return func(res primaryResult) {
+		primary <- res
+	}
+}
+
+// shadowRoundTrip returns status and body of shadow response. Body is nil if it's too large to be compared.
+func (r *Router) shadowRoundTrip(req *http.Request, m *Mirror, target *upstream.Upstream) (int, []byte, error) {
+	resp, err := m.client.Do(req)
+	if err != nil {
+		m.Upstreams.Release(target, 0, err)
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	defer m.Upstreams.Release(target, resp.StatusCode, nil)
+
+	if !m.Compare {
+		_, err := io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode, nil, err
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCompareBody+1))
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(body) > maxCompareBody {
+		body = nil
+	}
+	return resp.StatusCode, body, nil
+}
+
+// sameBody compares JSON bodies by value, so order of keys and formatting don't matter, other bodies by bytes.
+func sameBody(a, b []byte) bool {
+	var va, vb any
+	if json.Unmarshal(a, &va) == nil && json.Unmarshal(b, &vb) == nil {
+		return reflect.DeepEqual(va, vb)
+	}
+	return bytes.Equal(a, b)
+}
+
+// recordPrimary wraps body of primary response, so it may be compared with shadow one when it's read.
+func recordPrimary(resp *http.Response) *cache.Recorder {
+	rec := cache.NewRecorder(resp.Body, maxCompareBody)
+	resp.Body = rec
+	return rec
+}
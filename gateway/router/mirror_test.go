@@ -0,0 +1,228 @@
+package router
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sunnyyssh/designing-software-cw3/gateway/upstream"
+)
+
+func newTestPool(t *testing.T, url string) *upstream.Pool {
+	t.Helper()
+
+	pool, err := upstream.NewPool(&upstream.Config{Targets: []upstream.Target{{URL: url + "/"}}}, slog.Default())
+	if err != nil {
+		t.Fatalf("new pool: %s", err)
+	}
+	return pool
+}
+
+// shadowRequest is what shadow upstream got.
+type shadowRequest struct {
+	method string
+	path   string
+	body   string
+}
+
+// newMirroredGateway serves primary handler behind gateway location /api/ mirrored to shadow.
+func newMirroredGateway(t *testing.T, mirror *Mirror, primary, shadow http.HandlerFunc) *httptest.Server {
+	t.Helper()
+
+	primaryServer := httptest.NewServer(primary)
+	t.Cleanup(primaryServer.Close)
+	shadowServer := httptest.NewServer(shadow)
+	t.Cleanup(shadowServer.Close)
+
+	mirror.Upstreams = newTestPool(t, shadowServer.URL)
+
+	gateway := httptest.NewServer(New(&Config{
+		Locations: []Location{{
+			Name:      "/api/",
+			Match:     Match{Prefix: "/api/"},
+			Rewrite:   Rewrite{StripPrefix: "/api/"},
+			Upstreams: newTestPool(t, primaryServer.URL),
+			Mirror:    mirror,
+		}},
+	}, slog.Default()))
+	t.Cleanup(gateway.Close)
+
+	return gateway
+}
+
+func TestMirrorSampled(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		mirror Mirror
+		method string
+		want   bool
+	}{
+		{"all GET", Mirror{Percent: 100}, http.MethodGet, true},
+		{"all HEAD", Mirror{Percent: 100}, http.MethodHead, true},
+		{"none", Mirror{Percent: 0}, http.MethodGet, false},
+		{"POST", Mirror{Percent: 100}, http.MethodPost, false},
+		{"PUT", Mirror{Percent: 100}, http.MethodPut, false},
+		{"DELETE", Mirror{Percent: 100}, http.MethodDelete, false},
+		{"unsafe allowed", Mirror{Percent: 100, AllowUnsafe: true}, http.MethodPost, true},
+		{"unsafe allowed but none", Mirror{Percent: 0, AllowUnsafe: true}, http.MethodPost, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/order/all", nil)
+			for range 100 {
+				if got := tt.mirror.sampled(req); got != tt.want {
+					t.Fatalf("sampled = %t, want %t", got, tt.want)
+				}
+			}
+		})
+	}
+
+	m := &Mirror{Percent: 25}
+	req := httptest.NewRequest(http.MethodGet, "/api/order/all", nil)
+	n := 0
+	for range 4000 {
+		if m.sampled(req) {
+			n++
+		}
+	}
+	// Expected 1000 with standard deviation about 27.
+	if n < 850 || n > 1150 {
+		t.Errorf("%d of 4000 requests are sampled, want about 25%%", n)
+	}
+}
+
+func TestMirrorSendsCopies(t *testing.T) {
+	shadowed := make(chan shadowRequest, 10)
+
+	gateway := newMirroredGateway(t,
+		&Mirror{Percent: 100, AllowUnsafe: true, Timeout: 5 * time.Second},
+		func(w http.ResponseWriter, r *http.Request) {
+			io.Copy(io.Discard, r.Body)
+			w.Write([]byte("primary"))
+		},
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			shadowed <- shadowRequest{method: r.Method, path: r.URL.Path, body: string(body)}
+			// Shadow answer never reaches client.
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("shadow"))
+		},
+	)
+
+	resp, err := http.Post(gateway.URL+"/api/order", "application/json", strings.NewReader(`{"items": []}`))
+	if err != nil {
+		t.Fatalf("post: %s", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || string(body) != "primary" {
+		t.Errorf("response = %d %q, want primary one", resp.StatusCode, body)
+	}
+
+	select {
+	case got := <-shadowed:
+		want := shadowRequest{method: http.MethodPost, path: "/order", body: `{"items": []}`}
+		if got != want {
+			t.Errorf("shadow got %+v, want %+v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request isn't mirrored")
+	}
+}
+
+func TestMirrorSkipsUnsafeMethods(t *testing.T) {
+	shadowed := make(chan shadowRequest, 10)
+
+	gateway := newMirroredGateway(t,
+		&Mirror{Percent: 100, Timeout: 5 * time.Second},
+		func(w http.ResponseWriter, r *http.Request) {},
+		func(w http.ResponseWriter, r *http.Request) {
+			shadowed <- shadowRequest{method: r.Method, path: r.URL.Path}
+		},
+	)
+
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodGet} {
+		req, _ := http.NewRequest(method, gateway.URL+"/api/order/"+strings.ToLower(method), nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %s", method, err)
+		}
+		resp.Body.Close()
+	}
+
+	// Requests are sent in order, so the GET one comes last if unsafe ones are mirrored too.
+	select {
+	case got := <-shadowed:
+		if got.method != http.MethodGet {
+			t.Errorf("shadow got %s %s, want only GET mirrored", got.method, got.path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("GET request isn't mirrored")
+	}
+}
+
+func TestMirrorInFlightLimit(t *testing.T) {
+	release := make(chan struct{})
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(shadow.Close)
+	t.Cleanup(func() {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+	})
+
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(primary.Close)
+
+	r := New(&Config{
+		Locations: []Location{{
+			Name:      "/api/",
+			Match:     Match{Prefix: "/api/"},
+			Upstreams: newTestPool(t, primary.URL),
+			Mirror:    &Mirror{Upstreams: newTestPool(t, shadow.URL), Percent: 100, Timeout: time.Minute},
+		}},
+	}, slog.Default())
+	loc := &r.locs[0]
+
+	mirror := func() bool {
+		req := httptest.NewRequest(http.MethodGet, "/api/order/all", nil)
+		report := r.startMirror(req, loc, "/order/all", slog.Default())
+		if report == nil {
+			return false
+		}
+		report(primaryResult{})
+		return true
+	}
+
+	// Shadow hangs, so slots aren't freed.
+	for i := range maxMirrorInFlight {
+		if !mirror() {
+			t.Fatalf("request %d isn't mirrored under the limit", i+1)
+		}
+	}
+	if mirror() {
+		t.Fatal("request over the limit is mirrored")
+	}
+
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(loc.Mirror.inFlight) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d shadow requests are still in flight", len(loc.Mirror.inFlight))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !mirror() {
+		t.Error("request isn't mirrored after slots are freed")
+	}
+}
@@ -0,0 +1,189 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"github.com/sunnyyssh/designing-software-cw3/gateway/apikey"
+	"github.com/sunnyyssh/designing-software-cw3/gateway/ratelimit"
+	"github.com/sunnyyssh/designing-software-cw3/gateway/upstream"
+)
+
+// errUpstreamBody means upstream failed in the middle of response body, so response reached client cut.
+var errUpstreamBody = errors.New("reading upstream response failed")
+
+// hopHeaders describe a single connection, so they are never forwarded.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopHeaders removes hop-by-hop headers, including ones listed in Connection header.
+func removeHopHeaders(h http.Header) {
+	for _, field := range h["Connection"] {
+		for _, name := range strings.Split(field, ",") {
+			if name = textproto.TrimString(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// containsToken tells whether comma separated header values contain token.
+func containsToken(values []string, token string) bool {
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(textproto.TrimString(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setForwarded tells upstream about the original request. Gateway is the edge, so client address is appended
+// to X-Forwarded-For, while X-Forwarded-Proto and X-Forwarded-Host are always set by gateway.
+func setForwarded(h http.Header, baseReq *http.Request) {
+	clientIP := ratelimit.ClientIP(baseReq)
+	if prior := h.Values("X-Forwarded-For"); len(prior) > 0 {
+		clientIP = strings.Join(prior, ", ") + ", " + clientIP
+	}
+	h.Set("X-Forwarded-For", clientIP)
+
+	proto := "http"
+	if baseReq.TLS != nil {
+		proto = "https"
+	}
+	h.Set("X-Forwarded-Proto", proto)
+	h.Set("X-Forwarded-Host", baseReq.Host)
+}
+
+// buildReq builds upstream request. Path starts with / and is resolved against upstream URL.
+// Body is nil for requests without body, otherwise it's streamed and has length of the client one.
+func buildReq(baseReq *http.Request, target *upstream.Upstream, path string, body io.Reader, headers *HeaderEdit) (*http.Request, error) {
+	// Upstream request is cancelled when client goes away, so long-lived streams are closed too.
+	req, err := http.NewRequestWithContext(baseReq.Context(), baseReq.Method, target.URL+strings.TrimPrefix(path, "/"), body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.URL.RawQuery = baseReq.URL.RawQuery
+	if body != nil {
+		req.ContentLength = baseReq.ContentLength
+	}
+
+	req.Header = baseReq.Header.Clone()
+	removeHopHeaders(req.Header)
+	// Upstreams get verified key ID and owner instead of the secret.
+	req.Header.Del(apikey.Header)
+	// Upstream may answer with trailers only if client accepts them.
+	if containsToken(baseReq.Header["Te"], "trailers") {
+		req.Header.Set("Te", "trailers")
+	}
+	setForwarded(req.Header, baseReq)
+	headers.apply(req.Header)
+
+	// Trailer values are filled by server when client body is read.
+	req.Trailer = baseReq.Trailer
+
+	return req, nil
+}
+
+// write copies upstream response to client. Streaming responses are flushed as they come.
+// Error wrapping errUpstreamBody means upstream broke, other ones mean client did.
+func write(w http.ResponseWriter, resp *http.Response, headers *HeaderEdit) error {
+	defer resp.Body.Close()
+
+	removeHopHeaders(resp.Header)
+	headers.apply(resp.Header)
+
+	h := w.Header()
+	for key, vals := range resp.Header {
+		for _, val := range vals {
+			h.Add(key, val)
+		}
+	}
+
+	announced := len(resp.Trailer)
+	if announced > 0 {
+		keys := make([]string, 0, announced)
+		for key := range resp.Trailer {
+			keys = append(keys, key)
+		}
+		h.Set("Trailer", strings.Join(keys, ", "))
+	}
+
+	w.WriteHeader(resp.StatusCode)
+
+	if err := copyBody(w, resp.Body, isStreaming(resp)); err != nil {
+		return err
+	}
+
+	// Trailers upstream hasn't announced may be sent only with prefix.
+	prefix := ""
+	if len(resp.Trailer) != announced {
+		prefix = http.TrailerPrefix
+	}
+
+	for key, vals := range resp.Trailer {
+		for _, val := range vals {
+			h.Add(prefix+key, val)
+		}
+	}
+
+	return nil
+}
+
+// isStreaming tells whether response must reach client as soon as upstream writes it,
+// e.g. Server-Sent Events or any response of unknown length.
+func isStreaming(resp *http.Response) bool {
+	mediaType, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";")
+	return strings.TrimSpace(mediaType) == "text/event-stream" || resp.ContentLength == -1
+}
+
+func copyBody(w http.ResponseWriter, body io.Reader, flush bool) error {
+	rc := http.NewResponseController(w)
+	buf := make([]byte, 32*1024)
+
+	if flush {
+		if err := rc.Flush(); err != nil {
+			return err
+		}
+	}
+
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+			if flush {
+				if err := rc.Flush(); err != nil {
+					return err
+				}
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %w", errUpstreamBody, err)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package router
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sunnyyssh/designing-software-cw3/gateway/upstream"
+)
+
+// newGateway serves handler behind gateway location /api/.
+func newGateway(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+
+	backend := httptest.NewServer(handler)
+	t.Cleanup(backend.Close)
+
+	pool, err := upstream.NewPool(&upstream.Config{Targets: []upstream.Target{{URL: backend.URL + "/"}}}, slog.Default())
+	if err != nil {
+		t.Fatalf("new pool: %s", err)
+	}
+
+	gateway := httptest.NewServer(New(&Config{
+		Locations: []Location{{
+			Name:      "/api/",
+			Match:     Match{Prefix: "/api/"},
+			Rewrite:   Rewrite{StripPrefix: "/api/"},
+			Upstreams: pool,
+		}},
+	}, slog.Default()))
+	t.Cleanup(gateway.Close)
+
+	return gateway
+}
+
+func TestProxyForwardsRequest(t *testing.T) {
+	gateway := newGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/order/all" || r.URL.RawQuery != "limit=5" {
+			t.Errorf("url = %s, want /order/all?limit=5", r.URL)
+		}
+		for _, name := range []string{"Connection", "Keep-Alive", "X-Hop", "Upgrade"} {
+			if got := r.Header.Get(name); got != "" {
+				t.Errorf("%s = %q, want it removed", name, got)
+			}
+		}
+		if got := r.Header.Get("X-End"); got != "kept" {
+			t.Errorf("X-End = %q, want kept", got)
+		}
+		if got := r.Header.Get("X-Forwarded-For"); got != "10.0.0.1, 127.0.0.1" {
+			t.Errorf("X-Forwarded-For = %q, want client appended", got)
+		}
+		if got := r.Header.Get("X-Forwarded-Proto"); got != "http" {
+			t.Errorf("X-Forwarded-Proto = %q, want http", got)
+		}
+		if got := r.Header.Get("X-Forwarded-Host"); got != "shop.example.com" {
+			t.Errorf("X-Forwarded-Host = %q, want shop.example.com", got)
+		}
+
+		w.Header().Set("Connection", "X-Upstream-Hop")
+		w.Header().Set("X-Upstream-Hop", "secret")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{}`))
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, gateway.URL+"/api/order/all?limit=5", nil)
+	req.Host = "shop.example.com"
+	req.Header.Set("Connection", "X-Hop")
+	req.Header.Set("X-Hop", "secret")
+	req.Header.Set("Keep-Alive", "timeout=5")
+	req.Header.Set("X-End", "kept")
+	req.Header.Set("X-Forwarded-For", "10.0.0.1")
+	req.Header.Set("X-Forwarded-Proto", "https")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("code = %d, want %d", resp.StatusCode, http.StatusCreated)
+	}
+	if got := resp.Header.Get("X-Upstream-Hop"); got != "" {
+		t.Errorf("X-Upstream-Hop = %q, want it removed", got)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+}
+
+func TestProxyStreamsRequestBody(t *testing.T) {
+	tests := []struct {
+		name          string
+		contentLength int64
+	}{
+		{name: "known length", contentLength: 11},
+		{name: "chunked", contentLength: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newGateway(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.ContentLength != tt.contentLength {
+					t.Errorf("content length = %d, want %d", r.ContentLength, tt.contentLength)
+				}
+				body, _ := io.ReadAll(r.Body)
+				if string(body) != "hello world" {
+					t.Errorf("body = %q, want hello world", body)
+				}
+				w.WriteHeader(http.StatusNoContent)
+			})
+
+			// Reader without known size makes client send chunked body.
+			var body io.Reader = strings.NewReader("hello world")
+			if tt.contentLength < 0 {
+				body = io.MultiReader(body)
+			}
+
+			resp, err := http.Post(gateway.URL+"/api/upload", "text/plain", body)
+			if err != nil {
+				t.Fatalf("request: %s", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusNoContent {
+				t.Errorf("code = %d, want %d", resp.StatusCode, http.StatusNoContent)
+			}
+		})
+	}
+}
+
+func TestProxyForwardsTrailers(t *testing.T) {
+	gateway := newGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Te"); got != "trailers" {
+			t.Errorf("Te = %q, want trailers", got)
+		}
+
+		w.Header().Set("Trailer", "X-Checksum")
+		w.Write([]byte("data"))
+		w.Header().Set("X-Checksum", "abc")
+		w.Header().Set(http.TrailerPrefix+"X-Late", "late")
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, gateway.URL+"/api/file", nil)
+	req.Header.Set("Te", "trailers")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %s", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "data" {
+		t.Errorf("body = %q, want data", body)
+	}
+	if got := resp.Trailer.Get("X-Checksum"); got != "abc" {
+		t.Errorf("X-Checksum trailer = %q, want abc", got)
+	}
+	if got := resp.Trailer.Get("X-Late"); got != "late" {
+		t.Errorf("X-Late trailer = %q, want late", got)
+	}
+}
+
+func TestProxyFlushesStreams(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	gateway := newGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+
+		// Stream goes on until the first event has reached client.
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+
+	resp, err := http.Get(gateway.URL + "/api/events")
+	if err != nil {
+		t.Fatalf("request: %s", err)
+	}
+	defer resp.Body.Close()
+
+	line := make(chan string, 1)
+	go func() {
+		s, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		line <- s
+	}()
+
+	select {
+	case got := <-line:
+		if got != "data: first\n" {
+			t.Errorf("line = %q, want the first event", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("event wasn't flushed to client")
+	}
+}
+
+func TestProxyRequestID(t *testing.T) {
+	tests := []struct {
+		name   string
+		sent   string
+		accept bool
+	}{
+		{name: "accepted", sent: "order-42", accept: true},
+		{name: "missing"},
+		{name: "invalid", sent: "two words"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var forwarded string
+			gateway := newGateway(t, func(w http.ResponseWriter, r *http.Request) {
+				forwarded = r.Header.Get(HeaderRequestID)
+				// Upstream echoing ID mustn't duplicate it.
+				w.Header().Set(HeaderRequestID, forwarded)
+				w.WriteHeader(http.StatusNoContent)
+			})
+
+			req, _ := http.NewRequest(http.MethodGet, gateway.URL+"/api/order/all", nil)
+			if tt.sent != "" {
+				req.Header.Set(HeaderRequestID, tt.sent)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request: %s", err)
+			}
+			resp.Body.Close()
+
+			got := resp.Header.Values(HeaderRequestID)
+			if len(got) != 1 || got[0] != forwarded {
+				t.Fatalf("response IDs = %q, want only forwarded %q", got, forwarded)
+			}
+			if tt.accept && forwarded != tt.sent {
+				t.Errorf("forwarded ID = %q, want %q", forwarded, tt.sent)
+			}
+			if !tt.accept && (forwarded == "" || forwarded == tt.sent) {
+				t.Errorf("forwarded ID = %q, want a generated one", forwarded)
+			}
+		})
+	}
+}
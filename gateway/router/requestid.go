@@ -0,0 +1,37 @@
+package router
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// HeaderRequestID identifies request across gateway, services and messages they send.
+const HeaderRequestID = "X-Request-ID"
+
+// maxRequestIDLength bounds IDs accepted from clients.
+const maxRequestIDLength = 128
+
+// requestID returns ID client has sent or a new one if it's missing or invalid.
+func requestID(req *http.Request) string {
+	if id := req.Header.Get(HeaderRequestID); validRequestID(id) {
+		return id
+	}
+
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// validRequestID tells whether id is short and consists of visible ASCII characters, so it's safe to log and forward.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
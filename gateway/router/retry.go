@@ -0,0 +1,155 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/sunnyyssh/designing-software-cw3/gateway/upstream"
+)
+
+type Timeouts struct {
+	// Connect limits establishing connection to upstream. Zero means no limit.
+	Connect time.Duration
+	// Read limits waiting for response headers. Bodies aren't limited, so streams stay open. Zero means no limit.
+	Read time.Duration
+}
+
+type Retry struct {
+	// Attempts is a number of retries after the first try. Zero disables retries.
+	Attempts int
+	// Backoff is a delay before the first retry. It doubles with every retry up to MaxBackoff.
+	// Actual delay is random up to it, so retries of many clients don't come at once.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// delay returns random delay before retry. The first retry has zero attempt.
+func (r *Retry) delay(attempt int) time.Duration {
+	d := r.Backoff << min(attempt, 30)
+	if r.MaxBackoff > 0 && (d > r.MaxBackoff || d <= 0) {
+		d = r.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return rand.N(d)
+}
+
+func newClient(timeouts Timeouts) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   timeouts.Connect,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.ResponseHeaderTimeout = timeouts.Read
+
+	return &http.Client{Transport: transport}
+}
+
+// isIdempotent tells whether request with method may be sent twice. POST and PATCH may not.
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// isRetriable tells whether answer says upstream failed rather than rejected the request.
+func isRetriable(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+// isTimeout tells whether upstream didn't answer in time.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// maxReplayBody is the largest request body kept in memory, so request can be retried.
+// Larger bodies and ones of unknown length are streamed to upstream once.
+const maxReplayBody = 64 << 10
+
+// roundTrip sends request to upstreams of pool picked for location. Idempotent requests are retried on another pick
+// after connection errors and 502, 503 and 504 answers. Returned upstream must be released when response is read.
+func (r *Router) roundTrip(
+	req *http.Request, loc *Location, pool *upstream.Pool, path string, logger *slog.Logger,
+) (*http.Response, *upstream.Upstream, error) {
+	attempts := 1
+	if isIdempotent(req.Method) {
+		attempts += loc.Retry.Attempts
+	}
+
+	var replay []byte
+	if attempts > 1 && req.ContentLength != 0 {
+		if req.ContentLength < 0 || req.ContentLength > maxReplayBody {
+			attempts = 1
+		} else {
+			var err error
+			if replay, err = io.ReadAll(req.Body); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		target, err := pool.Acquire(req)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var body io.Reader
+		switch {
+		case replay != nil:
+			body = bytes.NewReader(replay)
+		case req.ContentLength != 0:
+			body = req.Body
+		}
+
+		routeReq, err := buildReq(req, target, path, body, &loc.Headers.Request)
+		if err != nil {
+			pool.Release(target, 0, nil)
+			return nil, nil, err
+		}
+
+		last := attempt+1 >= attempts
+
+		resp, err := loc.client.Do(routeReq)
+		if err == nil && (last || !isRetriable(resp.StatusCode)) {
+			r.metrics.upstreamError(loc.Name, target.URL, resp.StatusCode, nil)
+			return resp, target, nil
+		}
+
+		if err == nil {
+			resp.Body.Close()
+			pool.Release(target, resp.StatusCode, nil)
+			r.metrics.upstreamError(loc.Name, target.URL, resp.StatusCode, nil)
+			logger.WarnContext(req.Context(), "retrying request", "url", target.URL, "code", resp.StatusCode, "attempt", attempt+1)
+		} else {
+			pool.Release(target, 0, err)
+			if req.Context().Err() != nil {
+				return nil, nil, err
+			}
+			r.metrics.upstreamError(loc.Name, target.URL, 0, err)
+			if last {
+				return nil, nil, err
+			}
+			logger.WarnContext(req.Context(), "retrying request", "url", target.URL, "error", err, "attempt", attempt+1)
+		}
+		r.metrics.retries.Inc(loc.Name)
+
+		select {
+		case <-req.Context().Done():
+			return nil, nil, req.Context().Err()
+		case <-time.After(loc.Retry.delay(attempt)):
+		}
+	}
+}
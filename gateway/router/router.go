@@ -1,115 +1,608 @@
 package router
 
 import (
-	"bytes"
-	"io"
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/sunnyyssh/designing-software-cw3/gateway/apikey"
+	"github.com/sunnyyssh/designing-software-cw3/gateway/auth"
+	"github.com/sunnyyssh/designing-software-cw3/gateway/cache"
+	"github.com/sunnyyssh/designing-software-cw3/gateway/cors"
+	"github.com/sunnyyssh/designing-software-cw3/gateway/metrics"
+	"github.com/sunnyyssh/designing-software-cw3/gateway/ratelimit"
+	"github.com/sunnyyssh/designing-software-cw3/gateway/upstream"
 )
 
 type Config struct {
 	Locations []Location
+	// Verifier checks bearer tokens. Tokens aren't accepted if it's nil.
+	Verifier *auth.Verifier
+	// APIKeys checks API keys. Keys aren't accepted if it's nil.
+	APIKeys *apikey.Keyring
+	// RateLimitStore keeps rate limit buckets. Buckets are kept in memory if it's nil.
+	RateLimitStore ratelimit.Store
+	// Cache keeps responses of locations with cache policy. Nothing is cached if it's nil.
+	Cache *cache.Cache
+	// Metrics count served requests. Metrics aren't exposed if it's nil.
+	Metrics *Metrics
+	// TLS configures HTTPS. Plain HTTP only is served if it's nil.
+	TLS *TLS
 }
 
 type Location struct {
-	Prefix string
-	URL    string
+	// Name identifies location in logs, metrics and admin API.
+	Name    string
+	Match   Match
+	Rewrite Rewrite
+	Headers HeaderRules
+	// Upstreams serve location unless it has Split.
+	Upstreams *upstream.Pool
+	// Split divides traffic between groups of upstreams. Nil routes everything to Upstreams.
+	Split *Split
+	// Mirror sends copies of requests to shadow upstreams. May be nil.
+	Mirror *Mirror
+	// Composite answers with sections fetched from other locations. Location has no upstreams then.
+	Composite *Composite
+	Auth      auth.Rule
+	// Cache enables caching of GET responses.
+	Cache *cache.Policy
+	// CORS is a policy for browser requests. Gateway answers preflights itself. Nil passes CORS to upstream.
+	CORS *cors.Policy
+	// RateLimits must all allow request for it to be routed.
+	RateLimits []ratelimit.Rule
+	Timeouts   Timeouts
+	Retry      Retry
+
+	client *http.Client
 }
 
 type Router struct {
-	// Sorted by prefix length
-	locs   []Location
-	logger *slog.Logger
+	// Sorted in the order they are tried, see compareLocations.
+	locs     []Location
+	verifier *auth.Verifier
+	keys     *apikey.Keyring
+	limits   ratelimit.Store
+	cache    *cache.Cache
+	metrics  *Metrics
+	tls      *TLS
+	logger   *slog.Logger
 }
 
 func New(config *Config, logger *slog.Logger) *Router {
+	locs := slices.Clone(config.Locations)
+	slices.SortStableFunc(locs, compareLocations)
+
+	for i := range locs {
+		locs[i].client = newClient(locs[i].Timeouts)
+
+		if m := locs[i].Mirror; m != nil {
+			m.client = newClient(Timeouts{Connect: locs[i].Timeouts.Connect})
+			m.inFlight = make(chan struct{}, maxMirrorInFlight)
+		}
+	}
+
+	limits := config.RateLimitStore
+	if limits == nil {
+		limits = ratelimit.NewMemoryStore()
+	}
+
+	m := config.Metrics
+	if m == nil {
+		m = NewMetrics(metrics.NewRegistry())
+	}
+
 	return &Router{
-		locs:   config.Locations,
-		logger: logger,
+		locs:     locs,
+		verifier: config.Verifier,
+		keys:     config.APIKeys,
+		limits:   limits,
+		cache:    config.Cache,
+		metrics:  m,
+		tls:      config.TLS,
+		logger:   logger,
 	}
 }
 
+// Run runs health checks of location upstreams, JWKS and certificates reloading until ctx is done.
+func (r *Router) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+
+	if r.tls != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := r.tls.Certs.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				r.logger.Error("certificates reloading stopped", "error", err)
+			}
+		}()
+	}
+
+	if r.verifier != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := r.verifier.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				r.logger.Error("JWKS reloading stopped", "error", err)
+			}
+		}()
+	}
+
+	for _, loc := range r.locs {
+		for _, pool := range loc.pools() {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := pool.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+					r.logger.Error("upstream health checks stopped", "location", loc.Name, "error", err)
+				}
+			}()
+		}
+	}
+
+	wg.Wait()
+	return ctx.Err()
+}
+
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	logger := r.logger.With("path", req.URL.Path, "method", req.Method)
+	// Request ID is set before anything else, so every response and log record carries it.
+	id := requestID(req)
+	req.Header.Set(HeaderRequestID, id)
+	w.Header().Set(HeaderRequestID, id)
+
+	logger := r.logger.With("request_id", id, "path", req.URL.Path, "method", req.Method)
 
 	logger.InfoContext(req.Context(), "requiest received")
 
-	var (
-		loc       Location
-		routePath string
-		ok        bool
-	)
+	sw := &statusWriter{ResponseWriter: w}
+	w = sw
+
+	loc := r.match(req)
 
-	for _, l := range r.locs {
-		if routePath, ok = strings.CutPrefix(req.URL.Path, l.Prefix); ok {
-			loc = l
-			break
+	location := unmatched
+	if loc != nil {
+		location = loc.Name
+	}
+	defer r.metrics.start(location, req.Method, sw)()
+
+	if r.redirectHTTPS(w, req) {
+		logger.InfoContext(req.Context(), "request redirected to HTTPS")
+		return
+	}
+	r.setHSTS(w, req)
+
+	if loc == nil {
+		notFound(w)
+		return
+	}
+
+	// Preflights carry no credentials, so they are answered before auth.
+	if loc.CORS != nil {
+		if cors.IsPreflight(req) {
+			r.preflight(w, req, loc, logger)
+			return
 		}
+		loc.CORS.SetHeaders(w.Header(), req)
 	}
 
+	key, ok := r.authenticate(w, req, loc, logger)
 	if !ok {
-		notFound(w)
 		return
 	}
 
-	routeReq, err := buildReq(req, &loc, routePath)
-	if err != nil {
-		internalServerError(w)
+	if !r.limit(w, req, loc, key, logger) {
 		return
 	}
 
-	resp, err := http.DefaultClient.Do(routeReq)
-	if err != nil {
-		slog.Error("failed to route request", "url", loc.URL, "error", err)
+	cacheKey, cacheable := r.cacheKey(req, loc)
+	if cacheable && !cache.Bypass(req) {
+		now := time.Now()
+		if entry := r.cache.Get(cacheKey, req, now); entry != nil {
+			entry.Serve(w, req, now)
+			logger.InfoContext(req.Context(), "request served from cache", "code", entry.Status)
+			return
+		}
+	}
+
+	if loc.Composite != nil {
+		r.serveComposite(w, req, loc, logger)
+		return
+	}
+
+	pool := loc.Upstreams
+	if loc.Split != nil {
+		group := loc.Split.pick(w.Header(), req, loc.Name)
+		pool = group.Upstreams
+		logger = logger.With("group", group.Name)
+		defer r.metrics.startGroup(loc.Name, group.Name, sw)()
+	}
+
+	routePath := loc.Rewrite.apply(req.URL.Path)
+
+	// Shadow result is compared with what client got, including failures.
+	var primary primaryResult
+	if loc.Mirror != nil && loc.Mirror.sampled(req) {
+		if report := r.startMirror(req, loc, routePath, logger); report != nil {
+			defer func() { report(primary) }()
+		}
+	}
+
+	resp, target, err := r.roundTrip(req, loc, pool, routePath, logger)
+	switch {
+	case errors.Is(err, upstream.ErrNoHealthyUpstream) || errors.Is(err, upstream.ErrCircuitOpen):
+		logger.WarnContext(req.Context(), "no upstream to route request", "location", loc.Name, "error", err)
+		serviceUnavailable(w)
+		return
+
+	case isTimeout(err):
+		logger.ErrorContext(req.Context(), "upstream timed out", "location", loc.Name, "error", err)
+		gatewayTimeout(w)
+		return
+
+	case err != nil:
+		logger.ErrorContext(req.Context(), "failed to route request", "location", loc.Name, "error", err)
 		badGateway(w)
 		return
 	}
+	// Request is in flight until response is copied, so long streams count for least-connections balancing.
+	defer pool.Release(target, resp.StatusCode, nil)
+
+	if loc.CORS != nil {
+		cors.RemoveHeaders(resp.Header)
+	}
+	// Client gets ID gateway has set, and cached responses don't carry ID of the request they were stored for.
+	resp.Header.Del(HeaderRequestID)
 
-	if err := write(w, resp); err != nil {
-		slog.Error("failed to copy response", "error", err)
-		internalServerError(w)
+	var (
+		recorder *cache.Recorder
+		ttl      time.Duration
+	)
+	if cacheable {
+		w.Header().Set("X-Cache", "MISS")
+		if ttl = cache.TTL(resp, loc.Cache.TTL); ttl > 0 {
+			recorder = cache.NewRecorder(resp.Body, r.cache.MaxEntryBytes())
+			resp.Body = recorder
+		}
+	}
+
+	primary.status = resp.StatusCode
+
+	var primaryBody *cache.Recorder
+	if loc.Mirror != nil && loc.Mirror.Compare {
+		primaryBody = recordPrimary(resp)
+	}
+
+	if err := write(w, resp, &loc.Headers.Response); err != nil {
+		logger.ErrorContext(req.Context(), "failed to copy response", "error", err)
+		// Response is already started, so it's aborted for client not to take it as complete.
+		if errors.Is(err, errUpstreamBody) && req.Context().Err() == nil {
+			panic(http.ErrAbortHandler)
+		}
 		return
 	}
 
+	if primaryBody != nil {
+		primary.body, primary.recorded = primaryBody.Body()
+	}
+
+	if recorder != nil {
+		if body, ok := recorder.Body(); ok {
+			now := time.Now()
+			r.cache.Put(cacheKey, cache.NewEntry(req, resp, body, now, ttl), now)
+		}
+	}
+
 	logger.InfoContext(req.Context(), "request served", "code", resp.StatusCode)
 }
 
-func write(w http.ResponseWriter, resp *http.Response) error {
-	for key, vals := range resp.Header {
-		for _, val := range vals {
-			w.Header().Add(key, val)
-		}
+// cacheKey returns key of request response. It's false if response must not be cached.
+func (r *Router) cacheKey(req *http.Request, loc *Location) (cache.Key, bool) {
+	if r.cache == nil || loc.Cache == nil {
+		return cache.Key{}, false
+	}
+
+	// Gateway has verified the user by now.
+	user := req.Header.Get(auth.HeaderUserID)
+	if !cache.Cacheable(req, user) {
+		return cache.Key{}, false
 	}
 
-	w.WriteHeader(resp.StatusCode)
+	return cache.Key{Location: loc.Name, User: user, URI: req.URL.RequestURI()}, true
+}
 
-	defer resp.Body.Close()
-	_, err := io.Copy(w, resp.Body)
-	if err != nil {
-		return err
+// match returns the first location matching request or nil if there is none.
+func (r *Router) match(req *http.Request) *Location {
+	// Preflight is matched as the request it asks about, so locations limited to methods answer it too.
+	if cors.IsPreflight(req) {
+		asked := *req
+		asked.Method = req.Header.Get("Access-Control-Request-Method")
+		req = &asked
 	}
 
+	for i := range r.locs {
+		if r.locs[i].Match.matches(req) {
+			return &r.locs[i]
+		}
+	}
 	return nil
 }
 
-func buildReq(baseReq *http.Request, loc *Location, path string) (*http.Request, error) {
-	defer baseReq.Body.Close()
+func (r *Router) preflight(w http.ResponseWriter, req *http.Request, loc *Location, logger *slog.Logger) {
+	if !loc.CORS.Preflight(w.Header(), req) {
+		logger.InfoContext(req.Context(), "preflight is rejected", "origin", req.Header.Get("Origin"))
+		forbidden(w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LocationStatus is a snapshot of location upstreams. Locations with split have groups instead of upstreams,
+// composite locations have neither.
+type LocationStatus struct {
+	Name      string            `json:"name"`
+	Upstreams []upstream.Status `json:"upstreams,omitempty"`
+	Groups    []GroupStatus     `json:"groups,omitempty"`
+}
+
+type GroupStatus struct {
+	Name      string            `json:"name"`
+	Weight    int               `json:"weight"`
+	Upstreams []upstream.Status `json:"upstreams"`
+}
+
+func (r *Router) Status() []LocationStatus {
+	res := make([]LocationStatus, 0, len(r.locs))
+	for _, loc := range r.locs {
+		res = append(res, loc.status())
+	}
+	return res
+}
+
+func (loc *Location) status() LocationStatus {
+	if loc.Composite != nil {
+		return LocationStatus{Name: loc.Name}
+	}
+	if loc.Split == nil {
+		return LocationStatus{Name: loc.Name, Upstreams: loc.Upstreams.Status()}
+	}
+
+	weights := loc.Split.Weights()
+
+	st := LocationStatus{Name: loc.Name}
+	for _, g := range loc.Split.Groups {
+		st.Groups = append(st.Groups, GroupStatus{
+			Name:      g.Name,
+			Weight:    weights[g.Name],
+			Upstreams: g.Upstreams.Status(),
+		})
+	}
+	return st
+}
+
+// pools returns all upstream pools of location including shadow one. Composite location has none.
+func (loc *Location) pools() []*upstream.Pool {
+	var pools []*upstream.Pool
+	switch {
+	case loc.Split != nil:
+		for _, g := range loc.Split.Groups {
+			pools = append(pools, g.Upstreams)
+		}
+	case loc.Upstreams != nil:
+		pools = append(pools, loc.Upstreams)
+	}
+
+	if loc.Mirror != nil {
+		pools = append(pools, loc.Mirror.Upstreams)
+	}
+	return pools
+}
+
+// SetWeights changes weights of split groups of location until config is reloaded.
+func (r *Router) SetWeights(location string, weights map[string]int) (LocationStatus, error) {
+	i := slices.IndexFunc(r.locs, func(loc Location) bool { return loc.Name == location })
+	if i < 0 {
+		return LocationStatus{}, fmt.Errorf("%w: %s", ErrLocationNotFound, location)
+	}
+
+	loc := &r.locs[i]
+	if loc.Split == nil {
+		return LocationStatus{}, fmt.Errorf("%w: %s", ErrNoSplit, location)
+	}
 
-	body := &bytes.Buffer{}
-	if _, err := io.Copy(body, baseReq.Body); err != nil {
-		return nil, err
+	if err := loc.Split.SetWeights(weights); err != nil {
+		return LocationStatus{}, err
 	}
 
-	req, err := http.NewRequest(baseReq.Method, loc.URL+path, body)
+	r.logger.Info("split weights changed", "location", location, "weights", loc.Split.Weights())
+	return loc.status(), nil
+}
+
+// authenticate replaces identity headers of request with verified ones. It returns API key of request if it has one.
+// It writes error response and returns false if request doesn't satisfy location auth rule.
+func (r *Router) authenticate(
+	w http.ResponseWriter, req *http.Request, loc *Location, logger *slog.Logger,
+) (*apikey.Key, bool) {
+	key, code := r.verify(req, loc, logger)
+	switch code {
+	case http.StatusUnauthorized:
+		unauthorized(w)
+		return nil, false
+	case http.StatusForbidden:
+		forbidden(w)
+		return nil, false
+	case http.StatusServiceUnavailable:
+		serviceUnavailable(w)
+		return nil, false
+	default:
+		return key, true
+	}
+}
+
+// verify replaces identity headers of request with verified ones. It returns API key of request if it has one,
+// and 401, 403 or 503 status if request doesn't satisfy location auth rule or can't be checked, zero otherwise.
+// API key is checked only if request has no bearer token and location accepts keys.
+func (r *Router) verify(req *http.Request, loc *Location, logger *slog.Logger) (*apikey.Key, int) {
+	// Only gateway tells services who the caller is.
+	req.Header.Del(auth.HeaderUserID)
+	req.Header.Del(auth.HeaderUserRoles)
+	req.Header.Del(apikey.HeaderKeyID)
+	req.Header.Del(auth.HeaderClientCN)
+
+	// Client certificate is required in addition to token or API key, not instead of them.
+	if loc.Auth.ClientCert != nil {
+		name, err := loc.Auth.ClientCert.Verify(req.TLS)
+		if err != nil {
+			logger.InfoContext(req.Context(), "client certificate is rejected", "error", err)
+			return nil, http.StatusForbidden
+		}
+		req.Header.Set(auth.HeaderClientCN, name)
+	}
+
+	if req.Header.Get(apikey.Header) != "" && req.Header.Get("Authorization") == "" &&
+		r.keys != nil && loc.Auth.APIKeyScopes != nil {
+		return r.verifyKey(req, loc, logger)
+	}
+
+	required := loc.Auth.Required || len(loc.Auth.Roles) > 0
+
+	if r.verifier == nil {
+		if required {
+			return nil, http.StatusUnauthorized
+		}
+		return nil, 0
+	}
+
+	id, err := r.verifier.Authenticate(req)
+	if errors.Is(err, auth.ErrNoToken) && !required {
+		return nil, 0
+	}
 	if err != nil {
-		return nil, err
+		logger.InfoContext(req.Context(), "request is not authenticated", "error", err)
+		return nil, http.StatusUnauthorized
+	}
+
+	if !id.HasAnyRole(loc.Auth.Roles) {
+		logger.InfoContext(req.Context(), "request is forbidden", "subject", id.Subject, "roles", id.Roles)
+		return nil, http.StatusForbidden
+	}
+
+	req.Header.Set(auth.HeaderUserID, id.Subject)
+	if len(id.Roles) > 0 {
+		req.Header.Set(auth.HeaderUserRoles, strings.Join(id.Roles, ","))
+	}
+
+	return nil, 0
+}
+
+// verifyKey checks API key of request and its scopes for request method. Key owner is the caller.
+func (r *Router) verifyKey(req *http.Request, loc *Location, logger *slog.Logger) (*apikey.Key, int) {
+	key, err := r.keys.Authenticate(req.Context(), req.Header.Get(apikey.Header), time.Now())
+	if errors.Is(err, apikey.ErrInvalidKey) {
+		logger.InfoContext(req.Context(), "API key is not valid")
+		return nil, http.StatusUnauthorized
+	}
+	if err != nil {
+		logger.ErrorContext(req.Context(), "API key store failed", "error", err)
+		return nil, http.StatusServiceUnavailable
+	}
+
+	// Roles are granted to users, keys are limited by scopes instead.
+	scopes, ok := loc.Auth.APIKeyScopes[req.Method]
+	if !ok || !key.HasAnyScope(scopes) {
+		logger.InfoContext(req.Context(), "API key is forbidden", "key_id", key.ID, "scopes", key.Scopes)
+		return nil, http.StatusForbidden
+	}
+
+	req.Header.Set(auth.HeaderUserID, key.Owner)
+	req.Header.Set(apikey.HeaderKeyID, key.ID)
+
+	return key, 0
+}
+
+// limit takes tokens of request from buckets of location rules and of its API key. Headers describe
+// the tightest bucket. It writes error response and returns false if any bucket is empty.
+// Store errors don't reject requests: gateway rather serves too much than nothing.
+func (r *Router) limit(w http.ResponseWriter, req *http.Request, loc *Location, key *apikey.Key, logger *slog.Logger) bool {
+	type bucket struct {
+		rule *ratelimit.Rule
+		key  string
+	}
+
+	var (
+		buckets []bucket
+		keyID   string
+	)
+	if key != nil {
+		keyID = key.ID
+	}
+
+	for i := range loc.RateLimits {
+		rule := &loc.RateLimits[i]
+		if !rule.Applies(req.Method) {
+			continue
+		}
+
+		buckets = append(buckets, bucket{
+			rule: rule,
+			key:  loc.Name + "|" + strconv.Itoa(i) + "|" + rule.RequestKey(req, req.Header.Get(auth.HeaderUserID), keyID),
+		})
+	}
+
+	// Limit of API key is shared by all locations.
+	if key != nil && key.RateLimit != nil {
+		per := time.Duration(key.RateLimit.Per)
+		buckets = append(buckets, bucket{
+			rule: &ratelimit.Rule{
+				Key:    ratelimit.KeyAPIKey,
+				Limit:  ratelimit.Limit{Rate: float64(key.RateLimit.Requests) / per.Seconds(), Burst: key.RateLimit.Requests},
+				Window: per,
+			},
+			key: "api_key|" + key.ID,
+		})
+	}
+
+	var (
+		tightest    *ratelimit.Rule
+		tightestRes ratelimit.Result
+		now         = time.Now()
+	)
+
+	for _, b := range buckets {
+		res, err := r.limits.Take(req.Context(), b.key, b.rule.Limit, now)
+		if err != nil {
+			logger.ErrorContext(req.Context(), "rate limit store failed", "error", err)
+			continue
+		}
+
+		tighter := tightest == nil ||
+			(!res.Allowed && tightestRes.Allowed) ||
+			(res.Allowed == tightestRes.Allowed && res.Remaining < tightestRes.Remaining)
+		if tighter {
+			tightest, tightestRes = b.rule, res
+		}
+	}
+
+	if tightest == nil {
+		return true
 	}
 
-	req.URL.RawQuery = baseReq.URL.RawQuery
-	req.Header = baseReq.Header
+	ratelimit.SetHeaders(w.Header(), tightest, tightestRes)
 
-	return req, nil
+	if !tightestRes.Allowed {
+		logger.InfoContext(req.Context(), "request is rate limited", "key", tightest.Key)
+		tooManyRequests(w)
+		return false
+	}
+
+	return true
 }
 
 var notFoundBody = []byte(`{"error":"Not Found","code":404}`)
@@ -147,3 +640,64 @@ func badGateway(w http.ResponseWriter) error {
 
 	return nil
 }
+
+var serviceUnavailableBody = []byte(`{"error":"Service unavailable","code":503}`)
+
+func serviceUnavailable(w http.ResponseWriter) error {
+	w.WriteHeader(503)
+	_, err := w.Write(serviceUnavailableBody)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+var gatewayTimeoutBody = []byte(`{"error":"Gateway timeout","code":504}`)
+
+func gatewayTimeout(w http.ResponseWriter) error {
+	w.WriteHeader(504)
+	_, err := w.Write(gatewayTimeoutBody)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+var unauthorizedBody = []byte(`{"error":"Unauthorized","code":401}`)
+
+func unauthorized(w http.ResponseWriter) error {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	w.WriteHeader(401)
+	_, err := w.Write(unauthorizedBody)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+var forbiddenBody = []byte(`{"error":"Forbidden","code":403}`)
+
+func forbidden(w http.ResponseWriter) error {
+	w.WriteHeader(403)
+	_, err := w.Write(forbiddenBody)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+var tooManyRequestsBody = []byte(`{"error":"Too many requests","code":429}`)
+
+func tooManyRequests(w http.ResponseWriter) error {
+	w.WriteHeader(429)
+	_, err := w.Write(tooManyRequestsBody)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,195 @@
+package router
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	mathrand "math/rand/v2"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sunnyyssh/designing-software-cw3/gateway/auth"
+	"github.com/sunnyyssh/designing-software-cw3/gateway/upstream"
+)
+
+var (
+	ErrLocationNotFound = errors.New("location not found")
+	ErrNoSplit          = errors.New("location has no traffic split")
+)
+
+// Group is a named set of upstreams location traffic is split between, e.g. stable and canary versions of a service.
+type Group struct {
+	Name      string
+	Weight    int
+	Upstreams *upstream.Pool
+}
+
+type StickyKind string
+
+const (
+	// StickyNone picks group for every request anew.
+	StickyNone StickyKind = ""
+	// StickyUser keeps verified user in the same group. Anonymous requests aren't sticky.
+	StickyUser StickyKind = "user"
+	// StickyCookie keeps client in the same group by cookie gateway sets on the first response.
+	StickyCookie StickyKind = "cookie"
+)
+
+type Sticky struct {
+	By     StickyKind
+	Cookie string
+}
+
+// Override sends requests with matching header to group regardless of weights, e.g. X-Canary: true of testers.
+// Header must be present if Value is empty.
+type Override struct {
+	Header string
+	Value  string
+	Group  string
+}
+
+// Split divides location traffic between groups by weights. Sticky clients are hashed onto groups laid out
+// in their order, so growing weight of a group moves clients only into it while others stay where they are.
+type Split struct {
+	Groups    []Group
+	Sticky    Sticky
+	Overrides []Override
+
+	// weights are adjusted at runtime, they are indexed as Groups.
+	weights atomic.Pointer[[]int]
+	// mu serializes SetWeights, so concurrent changes of different groups don't overwrite each other.
+	mu sync.Mutex
+}
+
+func NewSplit(groups []Group, sticky Sticky, overrides []Override) *Split {
+	s := &Split{
+		Groups:    groups,
+		Sticky:    sticky,
+		Overrides: overrides,
+	}
+
+	weights := make([]int, 0, len(groups))
+	for _, g := range groups {
+		weights = append(weights, g.Weight)
+	}
+	s.weights.Store(&weights)
+
+	return s
+}
+
+// Weights returns current weights of groups by their names.
+func (s *Split) Weights() map[string]int {
+	weights := *s.weights.Load()
+
+	res := make(map[string]int, len(s.Groups))
+	for i, g := range s.Groups {
+		res[g.Name] = weights[i]
+	}
+	return res
+}
+
+// SetWeights changes weights of named groups, other groups keep theirs.
+// At least one group must have positive weight afterwards.
+func (s *Split) SetWeights(weights map[string]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next := slices.Clone(*s.weights.Load())
+
+	for name, w := range weights {
+		i := slices.IndexFunc(s.Groups, func(g Group) bool { return g.Name == name })
+		if i < 0 {
+			return fmt.Errorf("unknown group %q", name)
+		}
+		if w < 0 {
+			return fmt.Errorf("weight of group %q must not be negative", name)
+		}
+		next[i] = w
+	}
+
+	total := 0
+	for _, w := range next {
+		total += w
+	}
+	if total <= 0 {
+		return errors.New("at least one group must have positive weight")
+	}
+
+	s.weights.Store(&next)
+	return nil
+}
+
+// pick returns group for request of location. Cookie of a new sticky client is set on response header.
+func (s *Split) pick(header http.Header, req *http.Request, location string) *Group {
+	for _, o := range s.Overrides {
+		values := req.Header.Values(o.Header)
+		if (o.Value == "" && len(values) > 0) || (o.Value != "" && slices.Contains(values, o.Value)) {
+			if i := slices.IndexFunc(s.Groups, func(g Group) bool { return g.Name == o.Group }); i >= 0 {
+				return &s.Groups[i]
+			}
+		}
+	}
+
+	weights := *s.weights.Load()
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+	if total <= 0 {
+		return &s.Groups[0]
+	}
+
+	var point int
+	if key := s.stickyKey(header, req); key != "" {
+		// Location is hashed too, so client isn't put to canaries of all locations at once.
+		h := fnv.New64a()
+		h.Write([]byte(location + "\x00" + key))
+		point = int(h.Sum64() % uint64(total))
+	} else {
+		point = mathrand.N(total)
+	}
+
+	for i, w := range weights {
+		if point < w {
+			return &s.Groups[i]
+		}
+		point -= w
+	}
+	return &s.Groups[len(s.Groups)-1]
+}
+
+// stickyKey identifies client. It's empty if request isn't sticky.
+func (s *Split) stickyKey(header http.Header, req *http.Request) string {
+	switch s.Sticky.By {
+	case StickyUser:
+		// Gateway has verified the user by now.
+		return req.Header.Get(auth.HeaderUserID)
+
+	case StickyCookie:
+		if c, err := req.Cookie(s.Sticky.Cookie); err == nil && c.Value != "" {
+			return c.Value
+		}
+
+		var b [16]byte
+		rand.Read(b[:])
+		id := hex.EncodeToString(b[:])
+
+		cookie := &http.Cookie{
+			Name:     s.Sticky.Cookie,
+			Value:    id,
+			Path:     "/",
+			MaxAge:   365 * 24 * 60 * 60,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		}
+		header.Add("Set-Cookie", cookie.String())
+		return id
+
+	default:
+		return ""
+	}
+}
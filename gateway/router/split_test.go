@@ -0,0 +1,100 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/sunnyyssh/designing-software-cw3/gateway/auth"
+)
+
+func TestSplitPick(t *testing.T) {
+	split := NewSplit(
+		[]Group{{Name: "canary", Weight: 10}, {Name: "stable", Weight: 90}},
+		Sticky{By: StickyUser},
+		[]Override{{Header: "X-Canary", Value: "true", Group: "canary"}},
+	)
+
+	pick := func(user string, header http.Header) string {
+		req := httptest.NewRequest(http.MethodGet, "/order/all", nil)
+		for name, vals := range header {
+			req.Header[name] = vals
+		}
+		if user != "" {
+			req.Header.Set(auth.HeaderUserID, user)
+		}
+		return split.pick(http.Header{}, req, "/order/").Name
+	}
+
+	if got := pick("", http.Header{"X-Canary": {"true"}}); got != "canary" {
+		t.Errorf("override picked %s, want canary", got)
+	}
+
+	assigned := make(map[string]string)
+	canaries := 0
+	for i := range 1000 {
+		user := fmt.Sprintf("user-%d", i)
+		assigned[user] = pick(user, nil)
+		if assigned[user] == "canary" {
+			canaries++
+		}
+	}
+	if canaries < 50 || canaries > 150 {
+		t.Errorf("%d of 1000 users are in canary, want about 100", canaries)
+	}
+
+	for user, group := range assigned {
+		if got := pick(user, nil); got != group {
+			t.Fatalf("user %s moved from %s to %s", user, group, got)
+		}
+	}
+
+	// Growing canary only moves users into it.
+	if err := split.SetWeights(map[string]int{"canary": 50, "stable": 50}); err != nil {
+		t.Fatalf("set weights: %s", err)
+	}
+	for user, group := range assigned {
+		if got := pick(user, nil); group == "canary" && got != "canary" {
+			t.Fatalf("user %s left canary after it grew", user)
+		}
+	}
+
+	if err := split.SetWeights(map[string]int{"canary": 0, "stable": 0}); err == nil {
+		t.Error("zero total weight is accepted")
+	}
+	if err := split.SetWeights(map[string]int{"beta": 1}); err == nil {
+		t.Error("unknown group is accepted")
+	}
+}
+
+func TestSplitSetWeightsConcurrently(t *testing.T) {
+	groups := make([]Group, 0, 20)
+	for i := range 20 {
+		groups = append(groups, Group{Name: fmt.Sprint("g", i), Weight: 1})
+	}
+	split := NewSplit(groups, Sticky{}, nil)
+
+	var wg sync.WaitGroup
+	for i := range groups {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for w := 1; w <= 200; w++ {
+				if err := split.SetWeights(map[string]int{groups[i].Name: w + i}); err != nil {
+					t.Errorf("set weight: %s", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	weights := split.Weights()
+	for i, g := range groups {
+		if weights[g.Name] != 200+i {
+			t.Errorf("weight of %s = %d, want %d: concurrent update is lost", g.Name, weights[g.Name], 200+i)
+		}
+	}
+}
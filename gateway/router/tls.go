@@ -0,0 +1,76 @@
+package router
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sunnyyssh/designing-software-cw3/gateway/certs"
+)
+
+var errNoTLS = errors.New("TLS is not configured")
+
+// TLS configures HTTPS listener and how routes treat plain HTTP.
+type TLS struct {
+	Certs      *certs.Store
+	MinVersion uint16
+	// RedirectHTTP answers plain HTTP requests with permanent redirect to HTTPS on HTTPSPort.
+	RedirectHTTP bool
+	HTTPSPort    int
+	// HSTS is Strict-Transport-Security value of HTTPS responses. Empty doesn't set it.
+	HSTS string
+}
+
+// TLSConfig returns config of TLS handshake with certificates of the router. HTTP/2 is preferred.
+// Client certificates are asked for but not required, locations require them.
+func (r *Router) TLSConfig() (*tls.Config, error) {
+	if r.tls == nil {
+		return nil, errNoTLS
+	}
+
+	cfg := &tls.Config{
+		MinVersion:     r.tls.MinVersion,
+		GetCertificate: r.tls.Certs.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+
+	if pool := r.tls.Certs.ClientCAs(); pool != nil {
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return cfg, nil
+}
+
+// redirectHTTPS sends plain HTTP request to HTTPS. It returns false if request needn't be redirected.
+// Redirect keeps method and body, so API clients following it don't turn POST into GET.
+func (r *Router) redirectHTTPS(w http.ResponseWriter, req *http.Request) bool {
+	if r.tls == nil || !r.tls.RedirectHTTP || req.TLS != nil {
+		return false
+	}
+
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+
+	if r.tls.HTTPSPort != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(r.tls.HTTPSPort))
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	http.Redirect(w, req, "https://"+host+req.URL.RequestURI(), http.StatusPermanentRedirect)
+	return true
+}
+
+// setHSTS tells browsers to use only HTTPS with the host. Plain HTTP responses mustn't carry it.
+func (r *Router) setHSTS(w http.ResponseWriter, req *http.Request) {
+	if r.tls != nil && r.tls.HSTS != "" && req.TLS != nil {
+		w.Header().Set("Strict-Transport-Security", r.tls.HSTS)
+	}
+}
@@ -0,0 +1,50 @@
+package router
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectHTTPS(t *testing.T) {
+	for _, tt := range []struct {
+		port int
+		host string
+		want string
+	}{
+		{443, "shop.example.com", "https://shop.example.com/order/all?limit=5"},
+		{443, "shop.example.com:80", "https://shop.example.com/order/all?limit=5"},
+		{8443, "shop.example.com:8080", "https://shop.example.com:8443/order/all?limit=5"},
+		{443, "[::1]:80", "https://[::1]/order/all?limit=5"},
+	} {
+		r := New(&Config{TLS: &TLS{RedirectHTTP: true, HTTPSPort: tt.port, HSTS: "max-age=60"}}, slog.Default())
+
+		req := httptest.NewRequest(http.MethodPost, "/order/all?limit=5", nil)
+		req.Host = tt.host
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusPermanentRedirect || rec.Header().Get("Location") != tt.want {
+			t.Errorf("%s: got %d to %s, want 308 to %s", tt.host, rec.Code, rec.Header().Get("Location"), tt.want)
+		}
+		if got := rec.Header().Get("Strict-Transport-Security"); got != "" {
+			t.Errorf("%s: plain HTTP response has HSTS %q", tt.host, got)
+		}
+	}
+
+	r := New(&Config{TLS: &TLS{RedirectHTTP: true, HTTPSPort: 443, HSTS: "max-age=60"}}, slog.Default())
+
+	req := httptest.NewRequest(http.MethodGet, "/order/all", nil)
+	req.TLS = &tls.ConnectionState{}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("HTTPS request: got %d, want 404 of no location", rec.Code)
+	}
+	if got := rec.Header().Get("Strict-Transport-Security"); got != "max-age=60" {
+		t.Errorf("HSTS = %q, want max-age=60", got)
+	}
+}
@@ -0,0 +1,176 @@
+package upstream
+
+import (
+	"cmp"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type BalancerKind string
+
+const (
+	RoundRobin BalancerKind = "round_robin"
+	LeastConn  BalancerKind = "least_conn"
+	Hash       BalancerKind = "hash"
+)
+
+// balancer picks available upstream for request. It returns nil if there is none.
+type balancer interface {
+	pick(req *http.Request, now time.Time) *Upstream
+}
+
+func newBalancer(kind BalancerKind, hashHeader string, upstreams []*Upstream) (balancer, error) {
+	switch kind {
+	case RoundRobin, "":
+		return newRoundRobin(upstreams), nil
+	case LeastConn:
+		return &leastConn{upstreams: upstreams}, nil
+	case Hash:
+		if hashHeader == "" {
+			return nil, fmt.Errorf("%s balancer requires hash header", Hash)
+		}
+		return newHashRing(hashHeader, upstreams), nil
+	default:
+		return nil, fmt.Errorf("unknown balancer %q", kind)
+	}
+}
+
+// roundRobin is smooth weighted round-robin: heavier upstreams get more requests, but not in a row.
+type roundRobin struct {
+	upstreams []*Upstream
+
+	mu      sync.Mutex
+	current []int
+}
+
+func newRoundRobin(upstreams []*Upstream) *roundRobin {
+	return &roundRobin{
+		upstreams: upstreams,
+		current:   make([]int, len(upstreams)),
+	}
+}
+
+func (b *roundRobin) pick(_ *http.Request, now time.Time) *Upstream {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	best, total := -1, 0
+	for i, u := range b.upstreams {
+		if !u.Available(now) {
+			continue
+		}
+
+		b.current[i] += u.Weight
+		total += u.Weight
+
+		if best == -1 || b.current[i] > b.current[best] {
+			best = i
+		}
+	}
+
+	if best == -1 {
+		return nil
+	}
+
+	b.current[best] -= total
+	return b.upstreams[best]
+}
+
+// leastConn picks upstream with the least requests in flight per weight unit.
+type leastConn struct {
+	upstreams []*Upstream
+	// next rotates the start, so ties are spread between upstreams.
+	next atomic.Uint64
+}
+
+func (b *leastConn) pick(_ *http.Request, now time.Time) *Upstream {
+	start := int(b.next.Add(1) % uint64(len(b.upstreams)))
+
+	var best *Upstream
+	for i := range b.upstreams {
+		u := b.upstreams[(start+i)%len(b.upstreams)]
+		if !u.Available(now) {
+			continue
+		}
+
+		if best == nil || u.InFlight()*int64(best.Weight) < best.InFlight()*int64(u.Weight) {
+			best = u
+		}
+	}
+
+	return best
+}
+
+// virtualNodes is a number of ring points per weight unit. More points spread keys more evenly.
+const virtualNodes = 100
+
+type ringPoint struct {
+	hash     uint64
+	upstream *Upstream
+}
+
+// hashRing sends requests with the same header value to the same upstream while it's available.
+// Requests without the header are balanced with round-robin.
+type hashRing struct {
+	header   string
+	ring     []ringPoint
+	fallback *roundRobin
+}
+
+func newHashRing(header string, upstreams []*Upstream) *hashRing {
+	ring := make([]ringPoint, 0)
+	for _, u := range upstreams {
+		for i := range u.Weight * virtualNodes {
+			ring = append(ring, ringPoint{hash: hash(u.URL + "#" + strconv.Itoa(i)), upstream: u})
+		}
+	}
+
+	slices.SortFunc(ring, func(a, b ringPoint) int { return cmp.Compare(a.hash, b.hash) })
+
+	return &hashRing{
+		header:   header,
+		ring:     ring,
+		fallback: newRoundRobin(upstreams),
+	}
+}
+
+func (b *hashRing) pick(req *http.Request, now time.Time) *Upstream {
+	key := req.Header.Get(b.header)
+	if key == "" {
+		return b.fallback.pick(req, now)
+	}
+
+	h := hash(key)
+	start, _ := slices.BinarySearchFunc(b.ring, h, func(p ringPoint, h uint64) int { return cmp.Compare(p.hash, h) })
+
+	// Unavailable upstream passes its keys to the next one on the ring.
+	for i := range b.ring {
+		p := b.ring[(start+i)%len(b.ring)]
+		if p.upstream.Available(now) {
+			return p.upstream
+		}
+	}
+
+	return nil
+}
+
+// hash returns well-mixed hash of s. FNV sums of strings differing only in the last bytes, as ring points
+// and user IDs do, differ in few bits, so they are mixed with MurmurHash3 finalizer to spread over the ring.
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+
+	k := h.Sum64()
+	k ^= k >> 33
+	k *= 0xff51afd7ed558ccd
+	k ^= k >> 33
+	k *= 0xc4ceb9fe1a85ec53
+	k ^= k >> 33
+	return k
+}
@@ -0,0 +1,150 @@
+package upstream
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+type Breaker struct {
+	// FailureThreshold is a number of failed requests in a row which opens the circuit. Zero disables breaker.
+	FailureThreshold int
+	// OpenFor is how long open circuit rejects requests before trial requests are let through.
+	OpenFor time.Duration
+	// HalfOpenRequests is a number of trial requests in flight at once. Their success in a row closes the circuit.
+	HalfOpenRequests int
+}
+
+// breaker stops sending requests to failing upstream for a while, so it fails fast instead of timing out.
+type breaker struct {
+	cfg *Breaker
+
+	mu       sync.Mutex
+	state    BreakerState
+	fails    int
+	openedAt time.Time
+	// trials are trial requests in flight, successes are succeeded ones while circuit is half-open.
+	trials    int
+	successes int
+}
+
+func (b *breaker) disabled() bool {
+	return b.cfg.FailureThreshold == 0
+}
+
+// ready tells whether request would be let through now.
+func (b *breaker) ready(now time.Time) bool {
+	if b.disabled() {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		return !now.Before(b.openedAt.Add(b.cfg.OpenFor))
+	case BreakerHalfOpen:
+		return b.trials < b.cfg.HalfOpenRequests
+	default:
+		return true
+	}
+}
+
+// acquire lets request through. Open circuit becomes half-open when OpenFor passes.
+func (b *breaker) acquire(now time.Time) bool {
+	if b.disabled() {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && !now.Before(b.openedAt.Add(b.cfg.OpenFor)) {
+		b.state = BreakerHalfOpen
+		b.trials, b.successes = 0, 0
+	}
+
+	switch b.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		if b.trials >= b.cfg.HalfOpenRequests {
+			return false
+		}
+		b.trials++
+		return true
+	default:
+		return true
+	}
+}
+
+// release records result of request. It tells whether circuit is opened by it.
+// Neutral results only free the trial slot.
+func (b *breaker) release(now time.Time, failed, neutral bool) (opened bool) {
+	if b.disabled() {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerClosed:
+		if neutral {
+			return false
+		}
+		if !failed {
+			b.fails = 0
+			return false
+		}
+
+		b.fails++
+		if b.fails >= b.cfg.FailureThreshold {
+			b.open(now)
+			return true
+		}
+
+	case BreakerHalfOpen:
+		b.trials--
+		if neutral {
+			return false
+		}
+		if failed {
+			b.open(now)
+			return true
+		}
+
+		b.successes++
+		if b.successes >= b.cfg.HalfOpenRequests {
+			b.state = BreakerClosed
+			b.fails = 0
+		}
+	}
+
+	// Results of requests sent before circuit opened are ignored.
+	return false
+}
+
+func (b *breaker) open(now time.Time) {
+	b.state = BreakerOpen
+	b.openedAt = now
+	b.fails = 0
+}
+
+func (b *breaker) current() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
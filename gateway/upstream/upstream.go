@@ -0,0 +1,309 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	neturl "net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrNoHealthyUpstream = errors.New("no healthy upstream")
+
+type Target struct {
+	URL string
+	// Weight is a share of requests target gets relative to other targets. Zero means 1.
+	Weight int
+}
+
+type HealthCheck struct {
+	// Path is probed with GET relative to target URL. Empty path disables active health checks.
+	Path     string
+	Interval time.Duration
+	Timeout  time.Duration
+	// UnhealthyThreshold is a number of failed probes in a row after which target is marked unhealthy.
+	UnhealthyThreshold int
+	// HealthyThreshold is a number of successful probes in a row after which unhealthy target is marked healthy.
+	HealthyThreshold int
+}
+
+type Passive struct {
+	// MaxFails is a number of connection errors in a row after which target is ejected. Zero disables ejection.
+	MaxFails int
+	// EjectFor is how long ejected target gets no requests.
+	EjectFor time.Duration
+}
+
+type Config struct {
+	Targets  []Target
+	Balancer BalancerKind
+	// HashHeader is a request header consistent hashing is made on.
+	HashHeader  string
+	HealthCheck HealthCheck
+	Passive     Passive
+	Breaker     Breaker
+}
+
+// Upstream is a single target with its health state.
+type Upstream struct {
+	URL    string
+	Weight int
+
+	// healthy is set by active health checks.
+	healthy  atomic.Bool
+	inFlight atomic.Int64
+
+	mu sync.Mutex
+	// probes counts successful (positive) or failed (negative) probes in a row.
+	probes       int
+	fails        int
+	ejectedUntil time.Time
+
+	breaker breaker
+}
+
+// Available tells whether upstream may get requests now.
+func (u *Upstream) Available(now time.Time) bool {
+	if !u.healthy.Load() {
+		return false
+	}
+
+	u.mu.Lock()
+	ejected := now.Before(u.ejectedUntil)
+	u.mu.Unlock()
+
+	return !ejected && u.breaker.ready(now)
+}
+
+func (u *Upstream) Healthy() bool {
+	return u.healthy.Load()
+}
+
+func (u *Upstream) Breaker() BreakerState {
+	return u.breaker.current()
+}
+
+func (u *Upstream) InFlight() int64 {
+	return u.inFlight.Load()
+}
+
+// Status is a snapshot of upstream state.
+type Status struct {
+	URL      string       `json:"url"`
+	Weight   int          `json:"weight"`
+	Healthy  bool         `json:"healthy"`
+	Ejected  bool         `json:"ejected"`
+	InFlight int64        `json:"in_flight"`
+	Breaker  BreakerState `json:"breaker"`
+}
+
+func (u *Upstream) Status(now time.Time) Status {
+	u.mu.Lock()
+	ejected := now.Before(u.ejectedUntil)
+	u.mu.Unlock()
+
+	return Status{
+		URL:      u.URL,
+		Weight:   u.Weight,
+		Healthy:  u.Healthy(),
+		Ejected:  ejected,
+		InFlight: u.InFlight(),
+		Breaker:  u.Breaker(),
+	}
+}
+
+// Pool balances requests between upstreams of a location.
+type Pool struct {
+	upstreams []*Upstream
+	balancer  balancer
+	cfg       *Config
+	client    *http.Client
+	logger    *slog.Logger
+}
+
+func NewPool(cfg *Config, logger *slog.Logger) (*Pool, error) {
+	if len(cfg.Targets) == 0 {
+		return nil, errors.New("no upstream targets")
+	}
+
+	if cfg.Breaker.FailureThreshold < 0 || (cfg.Breaker.FailureThreshold > 0 && cfg.Breaker.HalfOpenRequests < 1) {
+		return nil, errors.New("breaker requires positive failure threshold and half-open requests")
+	}
+
+	upstreams := make([]*Upstream, 0, len(cfg.Targets))
+	for _, t := range cfg.Targets {
+		weight := t.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		if weight < 0 {
+			return nil, fmt.Errorf("upstream %s has negative weight", t.URL)
+		}
+
+		u := &Upstream{
+			URL:     t.URL,
+			Weight:  weight,
+			breaker: breaker{cfg: &cfg.Breaker, state: BreakerClosed},
+		}
+		u.healthy.Store(true)
+		upstreams = append(upstreams, u)
+	}
+
+	b, err := newBalancer(cfg.Balancer, cfg.HashHeader, upstreams)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Pool{
+		upstreams: upstreams,
+		balancer:  b,
+		cfg:       cfg,
+		client:    &http.Client{Timeout: cfg.HealthCheck.Timeout},
+		logger:    logger,
+	}, nil
+}
+
+func (p *Pool) Upstreams() []*Upstream {
+	return p.upstreams
+}
+
+func (p *Pool) Status() []Status {
+	now := time.Now()
+
+	res := make([]Status, 0, len(p.upstreams))
+	for _, u := range p.upstreams {
+		res = append(res, u.Status(now))
+	}
+	return res
+}
+
+// Acquire picks upstream for request. Release must be called when request is done.
+func (p *Pool) Acquire(req *http.Request) (*Upstream, error) {
+	now := time.Now()
+
+	u := p.balancer.pick(req, now)
+	if u == nil {
+		return nil, ErrNoHealthyUpstream
+	}
+
+	// Other request may have taken the last trial slot of half-open circuit since pick.
+	if !u.breaker.acquire(now) {
+		return nil, ErrCircuitOpen
+	}
+
+	u.inFlight.Add(1)
+	return u, nil
+}
+
+// Release reports result of request to upstream. Status is zero if request wasn't answered or wasn't sent.
+// Connection errors count towards passive ejection, they and 502, 503 and 504 answers count towards circuit breaking.
+func (p *Pool) Release(u *Upstream, status int, connErr error) {
+	u.inFlight.Add(-1)
+
+	// Requests cancelled by clients say nothing about upstream.
+	cancelled := errors.Is(connErr, context.Canceled)
+
+	failed := connErr != nil || status == http.StatusBadGateway ||
+		status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+	if u.breaker.release(time.Now(), failed, cancelled || (status == 0 && connErr == nil)) {
+		p.logger.Warn("upstream circuit opened", "url", u.URL, "status", status, "error", connErr)
+	}
+
+	if p.cfg.Passive.MaxFails == 0 || cancelled {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if connErr == nil {
+		if status != 0 {
+			u.fails = 0
+		}
+		return
+	}
+
+	u.fails++
+	if u.fails >= p.cfg.Passive.MaxFails {
+		u.fails = 0
+		u.ejectedUntil = time.Now().Add(p.cfg.Passive.EjectFor)
+		p.logger.Warn("upstream ejected after connection errors", "url", u.URL, "until", u.ejectedUntil, "error", connErr)
+	}
+}
+
+// Run probes upstreams until ctx is done. It returns at once if active health checks are disabled.
+func (p *Pool) Run(ctx context.Context) error {
+	hc := p.cfg.HealthCheck
+	if hc.Path == "" {
+		return nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-time.Tick(hc.Interval):
+			var wg sync.WaitGroup
+			for _, u := range p.upstreams {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					p.check(ctx, u)
+				}()
+			}
+			wg.Wait()
+		}
+	}
+}
+
+func (p *Pool) check(ctx context.Context, u *Upstream) {
+	hc := p.cfg.HealthCheck
+
+	url, err := neturl.JoinPath(u.URL, hc.Path)
+	if err == nil {
+		err = p.probe(ctx, url)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if err == nil {
+		u.probes = max(u.probes, 0) + 1
+	} else {
+		u.probes = min(u.probes, 0) - 1
+	}
+
+	switch {
+	case !u.healthy.Load() && u.probes >= hc.HealthyThreshold:
+		u.healthy.Store(true)
+		p.logger.Info("upstream is healthy", "url", u.URL)
+
+	case u.healthy.Load() && -u.probes >= hc.UnhealthyThreshold:
+		u.healthy.Store(false)
+		p.logger.Warn("upstream is unhealthy", "url", u.URL, "error", err)
+	}
+}
+
+// probe treats any answer but 5xx as healthy, so services without dedicated health endpoint may be probed.
+func (p *Pool) probe(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("health check responded with %d", resp.StatusCode)
+	}
+	return nil
+}
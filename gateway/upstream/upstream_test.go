@@ -0,0 +1,255 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func newUpstreams(weights ...int) []*Upstream {
+	res := make([]*Upstream, 0, len(weights))
+	for i, w := range weights {
+		u := &Upstream{
+			URL:     "http://upstream-" + strconv.Itoa(i) + ":8080/",
+			Weight:  w,
+			breaker: breaker{cfg: &Breaker{}, state: BreakerClosed},
+		}
+		u.healthy.Store(true)
+		res = append(res, u)
+	}
+	return res
+}
+
+func TestBreaker(t *testing.T) {
+	b := &breaker{cfg: &Breaker{FailureThreshold: 2, OpenFor: 10 * time.Second, HalfOpenRequests: 2}, state: BreakerClosed}
+	now := testNow
+
+	mustAcquire := func(want bool) {
+		t.Helper()
+		if got := b.acquire(now); got != want {
+			t.Fatalf("acquire in %s state = %t, want %t", b.current(), got, want)
+		}
+	}
+	wantState := func(want BreakerState) {
+		t.Helper()
+		if got := b.current(); got != want {
+			t.Fatalf("state = %s, want %s", got, want)
+		}
+	}
+
+	// Success resets failures, so they must be in a row.
+	mustAcquire(true)
+	b.release(now, true, false)
+	mustAcquire(true)
+	b.release(now, false, false)
+	mustAcquire(true)
+	b.release(now, true, false)
+	wantState(BreakerClosed)
+
+	// Neutral result neither resets nor counts.
+	mustAcquire(true)
+	b.release(now, false, true)
+	mustAcquire(true)
+	if opened := b.release(now, true, false); !opened {
+		t.Fatal("second failure in a row hasn't opened circuit")
+	}
+	wantState(BreakerOpen)
+
+	// Requests sent before circuit opened don't change it.
+	if opened := b.release(now, true, false); opened {
+		t.Error("late failure reopened circuit")
+	}
+
+	now = now.Add(5 * time.Second)
+	if b.ready(now) {
+		t.Error("open circuit is ready")
+	}
+	mustAcquire(false)
+
+	now = now.Add(5 * time.Second)
+	if !b.ready(now) {
+		t.Error("circuit isn't ready after OpenFor")
+	}
+
+	// Only HalfOpenRequests trials are let through.
+	mustAcquire(true)
+	wantState(BreakerHalfOpen)
+	mustAcquire(true)
+	if b.ready(now) {
+		t.Error("half-open circuit without free trial slots is ready")
+	}
+	mustAcquire(false)
+
+	// Neutral result frees the trial slot.
+	b.release(now, false, true)
+	wantState(BreakerHalfOpen)
+	mustAcquire(true)
+
+	// Trials must all succeed.
+	b.release(now, false, false)
+	wantState(BreakerHalfOpen)
+	b.release(now, false, false)
+	wantState(BreakerClosed)
+
+	// Failed trial opens circuit again.
+	mustAcquire(true)
+	b.release(now, true, false)
+	mustAcquire(true)
+	b.release(now, true, false)
+	wantState(BreakerOpen)
+
+	now = now.Add(10 * time.Second)
+	mustAcquire(true)
+	if opened := b.release(now, true, false); !opened {
+		t.Error("failed trial hasn't opened circuit")
+	}
+	wantState(BreakerOpen)
+	mustAcquire(false)
+}
+
+func TestPoolReleaseCancelledFreesTrialSlot(t *testing.T) {
+	pool, err := NewPool(&Config{
+		Targets: []Target{{URL: "http://upstream:8080/"}},
+		Breaker: Breaker{FailureThreshold: 1, OpenFor: time.Millisecond, HalfOpenRequests: 1},
+	}, slog.Default())
+	if err != nil {
+		t.Fatalf("new pool: %s", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	u, err := pool.Acquire(req)
+	if err != nil {
+		t.Fatalf("acquire: %s", err)
+	}
+	pool.Release(u, http.StatusBadGateway, nil)
+	if got := u.Breaker(); got != BreakerOpen {
+		t.Fatalf("state = %s, want open", got)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+
+	for _, connErr := range []error{context.Canceled, nil} {
+		// Client went away or request wasn't sent: the trial says nothing and the next request takes its slot.
+		u, err := pool.Acquire(req)
+		if err != nil {
+			t.Fatalf("acquire trial: %s", err)
+		}
+		if _, err := pool.Acquire(req); !errors.Is(err, ErrNoHealthyUpstream) && !errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("second trial: err = %v, want no upstream", err)
+		}
+		pool.Release(u, 0, connErr)
+		if got := u.Breaker(); got != BreakerHalfOpen {
+			t.Fatalf("state after neutral trial = %s, want half_open", got)
+		}
+	}
+
+	u, err = pool.Acquire(req)
+	if err != nil {
+		t.Fatalf("acquire trial: %s", err)
+	}
+	pool.Release(u, http.StatusOK, nil)
+	if got := u.Breaker(); got != BreakerClosed {
+		t.Errorf("state after successful trial = %s, want closed", got)
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	upstreams := newUpstreams(5, 1, 1)
+	b := newRoundRobin(upstreams)
+
+	// Heavy upstream gets most requests, but light ones are interleaved.
+	want := []int{0, 0, 1, 0, 2, 0, 0}
+	for round := range 3 {
+		for i, w := range want {
+			if got := b.pick(nil, testNow); got != upstreams[w] {
+				t.Fatalf("round %d pick %d = %s, want %s", round, i, got.URL, upstreams[w].URL)
+			}
+		}
+	}
+
+	// Unavailable upstream is skipped and others share its requests.
+	upstreams[0].healthy.Store(false)
+	counts := make(map[*Upstream]int)
+	for range 10 {
+		counts[b.pick(nil, testNow)]++
+	}
+	if counts[upstreams[0]] != 0 || counts[upstreams[1]] != 5 || counts[upstreams[2]] != 5 {
+		t.Errorf("picks = %v, want 5 for each available upstream", counts)
+	}
+
+	for _, u := range upstreams {
+		u.healthy.Store(false)
+	}
+	if got := b.pick(nil, testNow); got != nil {
+		t.Errorf("pick without available upstreams = %s, want nil", got.URL)
+	}
+}
+
+func TestHashRing(t *testing.T) {
+	upstreams := newUpstreams(1, 1, 1)
+	b := newHashRing("X-User-ID", upstreams)
+
+	request := func(key string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if key != "" {
+			req.Header.Set("X-User-ID", key)
+		}
+		return req
+	}
+
+	owners := make(map[string]*Upstream)
+	counts := make(map[*Upstream]int)
+	for i := range 300 {
+		key := "user-" + strconv.Itoa(i)
+		owners[key] = b.pick(request(key), testNow)
+		counts[owners[key]]++
+
+		if again := b.pick(request(key), testNow); again != owners[key] {
+			t.Fatalf("key %s is sent to %s and then to %s", key, owners[key].URL, again.URL)
+		}
+	}
+	for _, u := range upstreams {
+		if counts[u] < 50 {
+			t.Errorf("%s owns %d of 300 keys, want them spread", u.URL, counts[u])
+		}
+	}
+
+	// Keys of unavailable upstream move to others, other keys stay.
+	failed := upstreams[0]
+	failed.healthy.Store(false)
+	for key, owner := range owners {
+		got := b.pick(request(key), testNow)
+		switch {
+		case got == nil || got == failed:
+			t.Fatalf("key %s is sent to unavailable upstream", key)
+		case owner != failed && got != owner:
+			t.Errorf("key %s moved from available %s to %s", key, owner.URL, got.URL)
+		}
+	}
+
+	// Keys come back when upstream recovers.
+	failed.healthy.Store(true)
+	for key, owner := range owners {
+		if got := b.pick(request(key), testNow); got != owner {
+			t.Errorf("key %s is sent to %s after recovery, want %s", key, got.URL, owner.URL)
+		}
+	}
+
+	// Requests without key are balanced with round-robin.
+	counts = make(map[*Upstream]int)
+	for range 30 {
+		counts[b.pick(request(""), testNow)]++
+	}
+	for _, u := range upstreams {
+		if counts[u] != 10 {
+			t.Errorf("%s got %d of 30 requests without key, want 10", u.URL, counts[u])
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/sunnyyssh/designing-software-cw3/inventory/internal/model"
+	"github.com/sunnyyssh/designing-software-cw3/shared/inbox"
+	"github.com/sunnyyssh/designing-software-cw3/shared/txcontext"
+)
+
+type InventoryService interface {
+	ServeStock(context.Context, *model.StockMessage) error
+}
+
+func NewInboxHandler(service InventoryService) inbox.HandlerFunc {
+	return func(ctx context.Context, tx pgx.Tx, messages ...inbox.Message) error {
+		ctx = txcontext.WithTx(ctx, tx)
+
+		for _, msg := range messages {
+			var stockMsg model.StockMessage
+			if err := json.Unmarshal(msg.Message, &stockMsg); err != nil {
+				return err
+			}
+
+			if err := service.ServeStock(ctx, &stockMsg); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,49 @@
+package model
+
+import "github.com/gofrs/uuid"
+
+type Stock struct {
+	SKU       string `json:"sku"`
+	Available int64  `json:"available"`
+	Reserved  int64  `json:"reserved"`
+}
+
+type ReservationStatus string
+
+const (
+	ReservationReserved  ReservationStatus = "reserved"
+	ReservationRejected  ReservationStatus = "rejected"
+	ReservationReleased  ReservationStatus = "released"
+	ReservationCommitted ReservationStatus = "committed"
+)
+
+type ReservationItem struct {
+	OrderID  uuid.UUID
+	SKU      string
+	Quantity int64
+	Status   ReservationStatus
+}
+
+type StockAction string
+
+const (
+	StockReserve StockAction = "reserve"
+	StockRelease StockAction = "release"
+	StockCommit  StockAction = "commit"
+)
+
+type StockItem struct {
+	SKU      string `json:"sku"`
+	Quantity int64  `json:"quantity"`
+}
+
+type StockMessage struct {
+	OrderID uuid.UUID   `json:"order_id"`
+	Action  StockAction `json:"action"`
+	Items   []StockItem `json:"items,omitempty"`
+}
+
+type StockReservedMessage struct {
+	ID     uuid.UUID         `json:"id"`
+	Status ReservationStatus `json:"status"`
+}
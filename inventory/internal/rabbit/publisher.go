@@ -0,0 +1,56 @@
+package rabbit
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/sunnyyssh/designing-software-cw3/shared/requestid"
+)
+
+type Publisher struct {
+	ch *amqp091.Channel
+	q  *amqp091.Queue
+}
+
+func NewPublisher(ch *amqp091.Channel, q *amqp091.Queue) *Publisher {
+	return &Publisher{
+		ch: ch,
+		q:  q,
+	}
+}
+
+func (p *Publisher) Publish(ctx context.Context, destination string, msgs ...any) (err error) {
+	if destination == "" {
+		destination = p.q.Name
+	}
+
+	// Request ID travels as correlation ID, so listener may restore it.
+	requestID, _ := requestid.FromContext(ctx)
+
+	for _, msg := range msgs {
+		body, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+
+		err = p.ch.PublishWithContext(ctx,
+			"",          // exchange (используем default)
+			destination, // routing key (имя очереди)
+			false,       // mandatory
+			false,       // immediate
+			amqp091.Publishing{
+				Timestamp:     time.Now(),
+				ContentType:   "application/json",
+				CorrelationId: requestID,
+				Body:          []byte(body),
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,46 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/sunnyyssh/designing-software-cw3/inventory/internal/model"
+	"github.com/sunnyyssh/designing-software-cw3/shared/httplib"
+)
+
+type InventoryService interface {
+	GetStock(ctx context.Context, sku string) (*model.Stock, error)
+	ListStock(ctx context.Context) ([]model.Stock, error)
+	SetAvailable(ctx context.Context, sku string, available int64) (*model.Stock, error)
+}
+
+type StockHandler struct {
+	service InventoryService
+}
+
+type setAvailableRequest struct {
+	Available int64 `json:"available" validate:"min=0"`
+}
+
+func NewStockHandler(service InventoryService) *StockHandler {
+	httplib.RegisterBody[setAvailableRequest]()
+
+	return &StockHandler{service}
+}
+
+func (h *StockHandler) GetStock(req *http.Request) (any, error) {
+	return h.service.GetStock(req.Context(), req.PathValue("sku"))
+}
+
+func (h *StockHandler) ListStock(req *http.Request) (any, error) {
+	return h.service.ListStock(req.Context())
+}
+
+func (h *StockHandler) SetAvailable(req *http.Request) (any, error) {
+	request, err := httplib.UnmarshalBody[setAvailableRequest](req)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.service.SetAvailable(req.Context(), req.PathValue("sku"), request.Available)
+}
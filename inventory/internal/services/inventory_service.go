@@ -0,0 +1,214 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/sunnyyssh/designing-software-cw3/inventory/internal/model"
+	"github.com/sunnyyssh/designing-software-cw3/inventory/internal/storage"
+	"github.com/sunnyyssh/designing-software-cw3/shared/errs"
+)
+
+type InventoryService struct {
+	storage *storage.Storage
+}
+
+func NewInventoryService(storage *storage.Storage) *InventoryService {
+	return &InventoryService{storage}
+}
+
+func (s *InventoryService) GetStock(ctx context.Context, sku string) (_ *model.Stock, err error) {
+	repo, endTx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer endTx(ctx, &err)
+
+	return repo.Stock().Get(ctx, sku)
+}
+
+func (s *InventoryService) ListStock(ctx context.Context) (_ []model.Stock, err error) {
+	repo, endTx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer endTx(ctx, &err)
+
+	return repo.Stock().List(ctx)
+}
+
+// SetAvailable sets amount of stock available for new reservations. Reserved stock is kept as is.
+func (s *InventoryService) SetAvailable(ctx context.Context, sku string, available int64) (_ *model.Stock, err error) {
+	repo, endTx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer endTx(ctx, &err)
+
+	stock, err := repo.Stock().GetForUpdate(ctx, sku)
+	if err != nil {
+		if !errs.IsNotFound(err) {
+			return nil, err
+		}
+		stock = &model.Stock{SKU: sku}
+	}
+
+	stock.Available = available
+
+	if err := repo.Stock().Upsert(ctx, stock); err != nil {
+		return nil, err
+	}
+
+	return stock, nil
+}
+
+func (s *InventoryService) ServeStock(ctx context.Context, msg *model.StockMessage) (err error) {
+	switch msg.Action {
+	case model.StockReserve:
+		return s.reserve(ctx, msg)
+	case model.StockRelease:
+		return s.finish(ctx, msg, model.ReservationReleased)
+	case model.StockCommit:
+		return s.finish(ctx, msg, model.ReservationCommitted)
+	default:
+		return fmt.Errorf("unknown stock action %q of order %s", msg.Action, msg.OrderID)
+	}
+}
+
+func (s *InventoryService) reserve(ctx context.Context, msg *model.StockMessage) (err error) {
+	repo, endTx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer endTx(ctx, &err)
+
+	reply := func(status model.ReservationStatus) error {
+		return repo.Outbox().Add(ctx, model.StockReservedMessage{
+			ID:     msg.OrderID,
+			Status: status,
+		})
+	}
+
+	// Rejection is saved as empty rejected reservation, so the resent command is rejected again
+	// even if stock is replenished by then, as the order is already cancelled.
+	reject := func() error {
+		err := repo.Reservation().Create(ctx, []model.ReservationItem{{
+			OrderID: msg.OrderID,
+			Status:  model.ReservationRejected,
+		}})
+		if err != nil {
+			return err
+		}
+		return reply(model.ReservationRejected)
+	}
+
+	existing, err := repo.Reservation().List(ctx, msg.OrderID)
+	if err != nil {
+		return err
+	}
+	// Command is resent, so the reply might be lost. Reservation is already made, it's replied again.
+	if len(existing) > 0 {
+		if existing[0].Status == model.ReservationReleased || existing[0].Status == model.ReservationRejected {
+			return reply(model.ReservationRejected)
+		}
+		return reply(model.ReservationReserved)
+	}
+
+	quantities := make(map[string]int64)
+	skus := make([]string, 0, len(msg.Items))
+	for _, item := range msg.Items {
+		if _, ok := quantities[item.SKU]; !ok {
+			skus = append(skus, item.SKU)
+		}
+		quantities[item.SKU] += item.Quantity
+	}
+	// Rows are locked in the same order as everywhere, so concurrent reservations of the same SKUs don't deadlock.
+	slices.Sort(skus)
+
+	// Either all items are reserved or none of them.
+	stocks := make([]*model.Stock, 0, len(skus))
+	for _, sku := range skus {
+		stock, err := repo.Stock().GetForUpdate(ctx, sku)
+		if err != nil {
+			if errs.IsNotFound(err) {
+				return reject()
+			}
+			return err
+		}
+
+		if stock.Available < quantities[sku] {
+			return reject()
+		}
+		stocks = append(stocks, stock)
+	}
+
+	items := make([]model.ReservationItem, 0, len(stocks))
+	for _, stock := range stocks {
+		stock.Available -= quantities[stock.SKU]
+		stock.Reserved += quantities[stock.SKU]
+
+		if err := repo.Stock().Upsert(ctx, stock); err != nil {
+			return err
+		}
+
+		items = append(items, model.ReservationItem{
+			OrderID:  msg.OrderID,
+			SKU:      stock.SKU,
+			Quantity: quantities[stock.SKU],
+			Status:   model.ReservationReserved,
+		})
+	}
+
+	if err := repo.Reservation().Create(ctx, items); err != nil {
+		return err
+	}
+
+	return reply(model.ReservationReserved)
+}
+
+// finish releases reserved stock back or commits it as sold.
+func (s *InventoryService) finish(ctx context.Context, msg *model.StockMessage, status model.ReservationStatus) (err error) {
+	repo, endTx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer endTx(ctx, &err)
+
+	items, err := repo.Reservation().List(ctx, msg.OrderID)
+	if err != nil {
+		return err
+	}
+
+	// Release may come before the reservation, e.g. when order timed out.
+	// Empty released reservation is saved so the late one is rejected.
+	if len(items) == 0 && status == model.ReservationReleased {
+		return repo.Reservation().Create(ctx, []model.ReservationItem{{
+			OrderID: msg.OrderID,
+			Status:  model.ReservationReleased,
+		}})
+	}
+
+	for _, item := range items {
+		// Reservation is already finished.
+		if item.Status != model.ReservationReserved {
+			return nil
+		}
+
+		stock, err := repo.Stock().GetForUpdate(ctx, item.SKU)
+		if err != nil {
+			return err
+		}
+
+		stock.Reserved -= item.Quantity
+		if status == model.ReservationReleased {
+			stock.Available += item.Quantity
+		}
+
+		if err := repo.Stock().Upsert(ctx, stock); err != nil {
+			return err
+		}
+	}
+
+	return repo.Reservation().SetStatus(ctx, msg.OrderID, status)
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/sunnyyssh/designing-software-cw3/inventory/internal/model"
+	"github.com/sunnyyssh/designing-software-cw3/shared/errs"
+	sharedoutbox "github.com/sunnyyssh/designing-software-cw3/shared/outbox"
+)
+
+type Repository interface {
+	Stock() StockRepository
+	Reservation() ReservationRepository
+	Outbox() Outbox
+}
+
+type repository struct {
+	db pgx.Tx
+}
+
+func (r *repository) Stock() StockRepository {
+	return &stockRepository{r.db}
+}
+
+func (r *repository) Reservation() ReservationRepository {
+	return &reservationRepository{r.db}
+}
+
+func (r *repository) Outbox() Outbox {
+	return &outbox{r.db}
+}
+
+type StockRepository interface {
+	Get(ctx context.Context, sku string) (*model.Stock, error)
+	// GetForUpdate locks stock row until the end of transaction.
+	GetForUpdate(ctx context.Context, sku string) (*model.Stock, error)
+	List(context.Context) ([]model.Stock, error)
+	Upsert(context.Context, *model.Stock) error
+}
+
+type stockRepository struct {
+	db pgx.Tx
+}
+
+func (r *stockRepository) Get(ctx context.Context, sku string) (*model.Stock, error) {
+	return r.get(ctx, `SELECT available, reserved FROM stock WHERE sku = $1`, sku)
+}
+
+func (r *stockRepository) GetForUpdate(ctx context.Context, sku string) (*model.Stock, error) {
+	return r.get(ctx, `SELECT available, reserved FROM stock WHERE sku = $1 FOR UPDATE`, sku)
+}
+
+func (r *stockRepository) get(ctx context.Context, q string, sku string) (*model.Stock, error) {
+	stock := &model.Stock{
+		SKU: sku,
+	}
+
+	if err := r.db.QueryRow(ctx, q, sku).Scan(&stock.Available, &stock.Reserved); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errs.NotFound("stock of sku %s not found", sku)
+		}
+		return nil, err
+	}
+
+	return stock, nil
+}
+
+func (r *stockRepository) List(ctx context.Context) ([]model.Stock, error) {
+	rows, err := r.db.Query(ctx, `SELECT sku, available, reserved FROM stock ORDER BY sku`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]model.Stock, 0)
+
+	for rows.Next() {
+		var stock model.Stock
+		if err := rows.Scan(&stock.SKU, &stock.Available, &stock.Reserved); err != nil {
+			return nil, err
+		}
+		res = append(res, stock)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (r *stockRepository) Upsert(ctx context.Context, stock *model.Stock) error {
+	q := `INSERT INTO stock (sku, available, reserved) VALUES ($1, $2, $3)
+		ON CONFLICT (sku) DO UPDATE SET available = EXCLUDED.available, reserved = EXCLUDED.reserved`
+	_, err := r.db.Exec(ctx, q, stock.SKU, stock.Available, stock.Reserved)
+	return err
+}
+
+type ReservationRepository interface {
+	List(ctx context.Context, orderID uuid.UUID) ([]model.ReservationItem, error)
+	Create(context.Context, []model.ReservationItem) error
+	SetStatus(ctx context.Context, orderID uuid.UUID, status model.ReservationStatus) error
+}
+
+type reservationRepository struct {
+	db pgx.Tx
+}
+
+func (r *reservationRepository) List(ctx context.Context, orderID uuid.UUID) ([]model.ReservationItem, error) {
+	q := `SELECT sku, quantity, status FROM reservations WHERE order_id = $1 ORDER BY sku`
+	rows, err := r.db.Query(ctx, q, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]model.ReservationItem, 0)
+
+	for rows.Next() {
+		item := model.ReservationItem{
+			OrderID: orderID,
+		}
+		if err := rows.Scan(&item.SKU, &item.Quantity, &item.Status); err != nil {
+			return nil, err
+		}
+		res = append(res, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (r *reservationRepository) Create(ctx context.Context, items []model.ReservationItem) error {
+	q := `INSERT INTO reservations (order_id, sku, quantity, status) VALUES ($1, $2, $3, $4)`
+	for _, item := range items {
+		if _, err := r.db.Exec(ctx, q, item.OrderID, item.SKU, item.Quantity, item.Status); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *reservationRepository) SetStatus(ctx context.Context, orderID uuid.UUID, status model.ReservationStatus) error {
+	_, err := r.db.Exec(ctx, `UPDATE reservations SET status = $2 WHERE order_id = $1`, orderID, status)
+	return err
+}
+
+type Outbox interface {
+	Add(context.Context, any) error
+}
+
+type outbox struct {
+	db pgx.Tx
+}
+
+func (o *outbox) Add(ctx context.Context, msg any) error {
+	return sharedoutbox.Add(ctx, o.db, "", msg)
+}
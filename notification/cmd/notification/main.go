@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/sunnyyssh/designing-software-cw3/notification/internal/handlers"
+	"github.com/sunnyyssh/designing-software-cw3/notification/internal/model"
+	"github.com/sunnyyssh/designing-software-cw3/notification/internal/rabbit"
+	"github.com/sunnyyssh/designing-software-cw3/notification/internal/rest"
+	"github.com/sunnyyssh/designing-software-cw3/notification/internal/services"
+	"github.com/sunnyyssh/designing-software-cw3/notification/internal/storage"
+	"github.com/sunnyyssh/designing-software-cw3/shared/auth"
+	"github.com/sunnyyssh/designing-software-cw3/shared/httplib"
+	"github.com/sunnyyssh/designing-software-cw3/shared/inbox"
+	"github.com/sunnyyssh/designing-software-cw3/shared/requestid"
+)
+
+func run(ctx context.Context, logger *slog.Logger) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	r := httplib.NewServer()
+
+	db, err := pgxpool.New(ctx, os.Getenv("PG_CONN_STRING"))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	for range 10 {
+		if err = db.Ping(ctx); err == nil {
+			break
+		}
+		logger.Warn("Failed to connect to PostgreSQL, retrying in 2 seconds...", "error", err)
+		time.Sleep(2 * time.Second)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		_, err = db.Exec(ctx, migration)
+		if err != nil {
+			return fmt.Errorf("failed to apply migration \"%s\": %w", migration, err)
+		}
+	}
+
+	rabbitMQConnString := os.Getenv("RABBITMQ_CONN_STRING")
+
+	var conn *amqp091.Connection
+
+	for i := 0; i < 10; i++ {
+		conn, err = amqp091.Dial(rabbitMQConnString)
+		if err == nil {
+			break
+		}
+		logger.Warn("Failed to connect to RabbitMQ, retrying in 2 seconds...", "error", err)
+		time.Sleep(2 * time.Second)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	logger.Info("Successfully connected to RabbitMQ")
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	st := storage.NewStorage(db)
+
+	for _, name := range []string{model.QueueOrderToNotification, model.QueuePaymentToNotification} {
+		q, err := ch.QueueDeclare(
+			name,  // name
+			true,  // durable
+			false, // delete when unused
+			false, // exclusive
+			false, // no-wait
+			nil,   // arguments
+		)
+		if err != nil {
+			return err
+		}
+
+		queueListener := rabbit.NewListener(db, ch, &q, logger)
+		go func() {
+			if err := queueListener.Run(ctx); err != nil {
+				logger.Error("listening queue failed", "error", err)
+			}
+		}()
+	}
+
+	service := services.NewNotificationService(st)
+
+	inboxWorker := inbox.NewWorker(
+		db,
+		handlers.NewInboxHandler(service),
+		&inbox.Config{
+			Period:    1 * time.Second,
+			BatchSize: 1,
+		},
+		logger,
+	)
+	go func() {
+		if err := inboxWorker.Run(ctx); err != nil {
+			if errors.Is(err, context.Canceled) {
+				logger.Info("inbox worker gracefully stopped")
+			} else {
+				logger.Error("inbox worker failed and stopped", "error", err)
+			}
+		}
+	}()
+
+	channels := map[model.Channel]services.Channel{
+		model.ChannelInApp: services.NewInAppChannel(st),
+	}
+
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		channels[model.ChannelEmail] = services.NewEmailChannel(&services.SMTPConfig{
+			Addr:     addr,
+			From:     envOrDefault("SMTP_FROM", "notification@localhost"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		})
+	} else {
+		logger.Warn("SMTP_ADDR is not set, email notifications are disabled")
+	}
+
+	dispatcher := services.NewDispatcher(
+		st,
+		channels,
+		&services.DispatcherConfig{
+			Period:      1 * time.Second,
+			BatchSize:   10,
+			MaxAttempts: 5,
+			RetryAfter:  30 * time.Second,
+			Timeout:     30 * time.Second,
+		},
+		logger,
+	)
+	go func() {
+		if err := dispatcher.Run(ctx); err != nil {
+			if errors.Is(err, context.Canceled) {
+				logger.Info("notification dispatcher gracefully stopped")
+			} else {
+				logger.Error("notification dispatcher failed and stopped", "error", err)
+			}
+		}
+	}()
+
+	handler := rest.NewNotificationHandler(service)
+
+	r.Mount("/notification").
+		Use(auth.MiddlewareUserID).
+		GET("/all", handler.ListNotifications).
+		POST("/{id}/read", handler.MarkRead)
+
+	r.Mount("/preferences").
+		Use(auth.MiddlewareUserID).
+		GET("", handler.GetPreferences).
+		PUT("", handler.UpdatePreferences)
+
+	if err := http.ListenAndServe(":8080", r); err != nil {
+		return err
+	}
+	return nil
+}
+
+func envOrDefault(key, defaultValue string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	return defaultValue
+}
+
+func main() {
+	// Default logger is replaced, so records logged by httplib carry request ID too.
+	logger := slog.New(requestid.NewHandler(slog.NewTextHandler(os.Stderr, nil)))
+	slog.SetDefault(logger)
+
+	ctx := context.Background()
+
+	if err := run(ctx, logger); err != nil {
+		logger.ErrorContext(ctx, "run failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS inbox (
+		id SERIAL,
+		source VARCHAR(255) NOT NULL DEFAULT '',
+		message JSONB NOT NULL
+	)`,
+
+	`CREATE TABLE IF NOT EXISTS notifications (
+		id UUID PRIMARY KEY,
+		user_id UUID NOT NULL,
+		kind VARCHAR(64) NOT NULL,
+		dedup_key VARCHAR(255) NOT NULL UNIQUE,
+		subject TEXT NOT NULL,
+		body TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+
+	`CREATE TABLE IF NOT EXISTS deliveries (
+		id BIGSERIAL PRIMARY KEY,
+		notification_id UUID NOT NULL REFERENCES notifications (id),
+		channel VARCHAR(32) NOT NULL,
+		status VARCHAR(32) NOT NULL,
+		attempts INT NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMPTZ NOT NULL,
+		last_error TEXT,
+		UNIQUE (notification_id, channel)
+	)`,
+
+	`CREATE INDEX IF NOT EXISTS deliveries_status_next_attempt_at_idx ON deliveries (status, next_attempt_at)`,
+
+	`CREATE TABLE IF NOT EXISTS feed (
+		notification_id UUID PRIMARY KEY REFERENCES notifications (id),
+		user_id UUID NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		read_at TIMESTAMPTZ
+	)`,
+
+	`CREATE INDEX IF NOT EXISTS feed_user_id_created_at_idx ON feed (user_id, created_at)`,
+
+	`CREATE TABLE IF NOT EXISTS preferences (
+		user_id UUID PRIMARY KEY,
+		email VARCHAR(320) NOT NULL DEFAULT '',
+		channels TEXT[] NOT NULL,
+		muted TEXT[] NOT NULL,
+		low_balance_threshold BIGINT NOT NULL
+	)`,
+
+	`ALTER TABLE inbox ADD COLUMN IF NOT EXISTS request_id VARCHAR(128) NOT NULL DEFAULT ''`,
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/sunnyyssh/designing-software-cw3/notification/internal/model"
+	"github.com/sunnyyssh/designing-software-cw3/shared/inbox"
+	"github.com/sunnyyssh/designing-software-cw3/shared/txcontext"
+)
+
+type NotificationService interface {
+	HandleOrderEvent(context.Context, *model.OrderEvent) error
+	HandleBalance(context.Context, *model.BalanceMessage) error
+}
+
+func NewInboxHandler(service NotificationService) inbox.HandlerFunc {
+	return func(ctx context.Context, tx pgx.Tx, messages ...inbox.Message) error {
+		ctx = txcontext.WithTx(ctx, tx)
+
+		for _, msg := range messages {
+			if err := handleMessage(ctx, service, msg); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+func handleMessage(ctx context.Context, service NotificationService, msg inbox.Message) error {
+	switch msg.Source {
+	case model.QueueOrderToNotification:
+		var event model.OrderEvent
+		if err := json.Unmarshal(msg.Message, &event); err != nil {
+			return err
+		}
+
+		return service.HandleOrderEvent(ctx, &event)
+
+	case model.QueuePaymentToNotification:
+		var balanceMsg model.BalanceMessage
+		if err := json.Unmarshal(msg.Message, &balanceMsg); err != nil {
+			return err
+		}
+
+		return service.HandleBalance(ctx, &balanceMsg)
+
+	default:
+		return fmt.Errorf("message from unknown queue %q", msg.Source)
+	}
+}
@@ -0,0 +1,126 @@
+package model
+
+import (
+	"slices"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+const (
+	QueueOrderToNotification   = "order_to_notification"
+	QueuePaymentToNotification = "payment_to_notification"
+)
+
+type OrderStatus string
+
+const (
+	StatusFinished  OrderStatus = "finished"
+	StatusCancelled OrderStatus = "cancelled"
+)
+
+type CancelReason struct {
+	Code    string `json:"code"`
+	Details string `json:"details,omitempty"`
+}
+
+// OrderEvent is a final status of order sent by order service.
+type OrderEvent struct {
+	ID           int64         `json:"id"`
+	OrderID      uuid.UUID     `json:"order_id"`
+	UserID       uuid.UUID     `json:"user_id"`
+	Status       OrderStatus   `json:"status"`
+	CancelReason *CancelReason `json:"cancel_reason,omitempty"`
+	CreatedAt    time.Time     `json:"created_at"`
+}
+
+// BalanceMessage is a change of account balance sent by payment service.
+type BalanceMessage struct {
+	ID      uuid.UUID `json:"id"`
+	UserID  uuid.UUID `json:"user_id"`
+	OrderID uuid.UUID `json:"order_id"`
+	// Amount is a change of balance. It's negative when account is debited.
+	Amount  int64 `json:"amount"`
+	Balance int64 `json:"balance"`
+}
+
+type Kind string
+
+const (
+	KindOrderPaid      Kind = "order_paid"
+	KindOrderCancelled Kind = "order_cancelled"
+	KindBalanceLow     Kind = "balance_low"
+)
+
+var Kinds = []Kind{KindOrderPaid, KindOrderCancelled, KindBalanceLow}
+
+type Channel string
+
+const (
+	ChannelInApp Channel = "in_app"
+	ChannelEmail Channel = "email"
+)
+
+var Channels = []Channel{ChannelInApp, ChannelEmail}
+
+// Notification is a rendered message for user. It's delivered through every channel user enabled.
+type Notification struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+	Kind   Kind      `json:"kind"`
+	// DedupKey identifies event notification is made of, so the same event isn't notified twice.
+	DedupKey  string    `json:"-"`
+	Subject   string    `json:"subject"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+	// ReadAt is set when in-app notification is read.
+	ReadAt *time.Time `json:"read_at,omitempty"`
+}
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending DeliveryStatus = "pending"
+	DeliverySent    DeliveryStatus = "sent"
+	DeliveryFailed  DeliveryStatus = "failed"
+)
+
+// Delivery is a sending of notification through a channel.
+type Delivery struct {
+	ID             int64
+	NotificationID uuid.UUID
+	Channel        Channel
+	Status         DeliveryStatus
+	Attempts       int
+	NextAttemptAt  time.Time
+	LastError      *string
+}
+
+// DefaultLowBalanceThreshold is used until user sets own one.
+const DefaultLowBalanceThreshold = 100
+
+type Preferences struct {
+	UserID uuid.UUID `json:"user_id"`
+	// Email is an address for email channel. Email isn't sent if it's empty.
+	Email    string    `json:"email"`
+	Channels []Channel `json:"channels"`
+	// Muted are kinds of notifications user doesn't want to receive.
+	Muted []Kind `json:"muted"`
+	// LowBalanceThreshold is a balance below which user is notified.
+	LowBalanceThreshold int64 `json:"low_balance_threshold"`
+}
+
+// DefaultPreferences are preferences of user who hasn't set them. Only in-app notifications are enabled.
+func DefaultPreferences(userID uuid.UUID) *Preferences {
+	return &Preferences{
+		UserID:              userID,
+		Channels:            []Channel{ChannelInApp},
+		Muted:               []Kind{},
+		LowBalanceThreshold: DefaultLowBalanceThreshold,
+	}
+}
+
+// Wants tells whether user wants notifications of kind.
+func (p *Preferences) Wants(kind Kind) bool {
+	return !slices.Contains(p.Muted, kind)
+}
@@ -0,0 +1,118 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"net/mail"
+	"strconv"
+
+	"github.com/gofrs/uuid"
+	"github.com/sunnyyssh/designing-software-cw3/notification/internal/model"
+	"github.com/sunnyyssh/designing-software-cw3/shared/auth"
+	"github.com/sunnyyssh/designing-software-cw3/shared/errs"
+	"github.com/sunnyyssh/designing-software-cw3/shared/httplib"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+type NotificationService interface {
+	ListNotifications(ctx context.Context, userID uuid.UUID, unreadOnly bool, limit int) ([]model.Notification, error)
+	MarkRead(ctx context.Context, userID, id uuid.UUID) (*model.Notification, error)
+	GetPreferences(ctx context.Context, userID uuid.UUID) (*model.Preferences, error)
+	UpdatePreferences(ctx context.Context, prefs *model.Preferences) (*model.Preferences, error)
+}
+
+// NotificationHandler serves in-app notifications and preferences of authenticated user.
+type NotificationHandler struct {
+	service NotificationService
+}
+
+type updatePreferencesRequest struct {
+	Email               string          `json:"email" validate:"max=320"`
+	Channels            []model.Channel `json:"channels" validate:"max=10"`
+	Muted               []model.Kind    `json:"muted" validate:"max=10"`
+	LowBalanceThreshold int64           `json:"low_balance_threshold" validate:"min=0"`
+}
+
+// Validate checks email is a bare address, e.g. user@example.com. Empty email means user has no address.
+func (r *updatePreferencesRequest) Validate() error {
+	if r.Email == "" {
+		return nil
+	}
+	if addr, err := mail.ParseAddress(r.Email); err != nil || addr.Address != r.Email {
+		return errs.Unprocessable(errs.FieldError{Field: "email", Message: "must be email address"})
+	}
+	return nil
+}
+
+func NewNotificationHandler(service NotificationService) *NotificationHandler {
+	httplib.RegisterBody[updatePreferencesRequest]()
+
+	return &NotificationHandler{service}
+}
+
+// ListNotifications returns user feed, latest first. It's filtered with optional unread and limit query parameters.
+func (h *NotificationHandler) ListNotifications(req *http.Request) (any, error) {
+	query := req.URL.Query()
+
+	unreadOnly := false
+	if s := query.Get("unread"); s != "" {
+		var err error
+		unreadOnly, err = strconv.ParseBool(s)
+		if err != nil {
+			return nil, errs.BadRequest("unread must be boolean")
+		}
+	}
+
+	limit := defaultListLimit
+	if s := query.Get("limit"); s != "" {
+		var err error
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			return nil, errs.BadRequest("limit must be integer from 1 to %d", maxListLimit)
+		}
+	}
+
+	userID := auth.MustUserIDFromContext(req.Context())
+
+	return h.service.ListNotifications(req.Context(), userID, unreadOnly, limit)
+}
+
+func (h *NotificationHandler) MarkRead(req *http.Request) (any, error) {
+	id, err := uuid.FromString(req.PathValue("id"))
+	if err != nil {
+		return nil, errs.BadRequest("id UUID path value must be specified: %s", err)
+	}
+
+	return h.service.MarkRead(req.Context(), auth.MustUserIDFromContext(req.Context()), id)
+}
+
+func (h *NotificationHandler) GetPreferences(req *http.Request) (any, error) {
+	return h.service.GetPreferences(req.Context(), auth.MustUserIDFromContext(req.Context()))
+}
+
+func (h *NotificationHandler) UpdatePreferences(req *http.Request) (any, error) {
+	request, err := httplib.UnmarshalBody[updatePreferencesRequest](req)
+	if err != nil {
+		return nil, err
+	}
+
+	prefs := &model.Preferences{
+		UserID:              auth.MustUserIDFromContext(req.Context()),
+		Email:               request.Email,
+		Channels:            request.Channels,
+		Muted:               request.Muted,
+		LowBalanceThreshold: request.LowBalanceThreshold,
+	}
+	if prefs.Channels == nil {
+		prefs.Channels = []model.Channel{}
+	}
+	if prefs.Muted == nil {
+		prefs.Muted = []model.Kind{}
+	}
+
+	return h.service.UpdatePreferences(req.Context(), prefs)
+}
@@ -0,0 +1,61 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/sunnyyssh/designing-software-cw3/notification/internal/model"
+	"github.com/sunnyyssh/designing-software-cw3/shared/auth"
+	"github.com/sunnyyssh/designing-software-cw3/shared/httplib"
+)
+
+type fakeNotificationService struct {
+	NotificationService
+	// prefs are the last updated preferences.
+	prefs *model.Preferences
+}
+
+func (s *fakeNotificationService) UpdatePreferences(
+	ctx context.Context, prefs *model.Preferences,
+) (*model.Preferences, error) {
+	s.prefs = prefs
+	return prefs, nil
+}
+
+func TestUpdatePreferences(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		email    string
+		wantCode int
+	}{
+		{"address", "user@example.com", 200},
+		{"empty", "", 200},
+		{"no domain", "user", 422},
+		{"display name", "User <user@example.com>", 422},
+		{"two addresses", "user@example.com, other@example.com", 422},
+		{"header injection", "user@example.com\r\nBcc: other@example.com", 422},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &fakeNotificationService{}
+			handler := auth.MiddlewareUserID(httplib.HandlerJSON(NewNotificationHandler(service).UpdatePreferences))
+
+			body := `{"email": ` + strconv.Quote(tt.email) + `}`
+			req := httptest.NewRequest(http.MethodPut, "/notification/preferences", strings.NewReader(body))
+			req.Header.Set(auth.HeaderUserID, uuid.Must(uuid.NewV4()).String())
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if updated := service.prefs != nil; updated != (tt.wantCode == 200) {
+				t.Errorf("preferences updated = %t, want %t", updated, tt.wantCode == 200)
+			}
+		})
+	}
+}
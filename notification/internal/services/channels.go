@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/sunnyyssh/designing-software-cw3/notification/internal/model"
+	"github.com/sunnyyssh/designing-software-cw3/notification/internal/storage"
+)
+
+// Channel delivers notification to user. It's called outside of any transaction and the same notification
+// may be sent again if recording the result fails, so channels storing data must do it idempotently.
+type Channel interface {
+	Send(ctx context.Context, n *model.Notification, prefs *model.Preferences) error
+}
+
+// ErrNoAddress is returned when user hasn't set an address for the channel.
+var ErrNoAddress = errors.New("user has no address for the channel")
+
+// InAppChannel puts notification to user feed served by REST.
+type InAppChannel struct {
+	storage *storage.Storage
+}
+
+func NewInAppChannel(storage *storage.Storage) *InAppChannel {
+	return &InAppChannel{storage}
+}
+
+func (c *InAppChannel) Send(ctx context.Context, n *model.Notification, _ *model.Preferences) (err error) {
+	repo, endTx, err := c.storage.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer endTx(ctx, &err)
+
+	return repo.Feed().Add(ctx, n)
+}
+
+// smtpDialTimeout limits connecting to SMTP server when send context has no deadline.
+const smtpDialTimeout = 10 * time.Second
+
+type SMTPConfig struct {
+	// Addr is host:port of SMTP server.
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+// EmailChannel sends notifications through SMTP server.
+type EmailChannel struct {
+	cfg *SMTPConfig
+}
+
+func NewEmailChannel(cfg *SMTPConfig) *EmailChannel {
+	return &EmailChannel{cfg}
+}
+
+func (c *EmailChannel) Send(ctx context.Context, n *model.Notification, prefs *model.Preferences) error {
+	if prefs.Email == "" {
+		return ErrNoAddress
+	}
+
+	if err := c.send(ctx, prefs.Email, c.message(n, prefs.Email)); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+
+	return nil
+}
+
+// send does what smtp.SendMail does, but the whole conversation is bound to ctx,
+// so a hung server doesn't stall the dispatcher.
+func (c *EmailChannel) send(ctx context.Context, to string, msg []byte) error {
+	dialer := &net.Dialer{Timeout: smtpDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+	// Cancellation without deadline interrupts the conversation too.
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	host, _, _ := net.SplitHostPort(c.cfg.Addr)
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if c.cfg.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", c.cfg.Username, c.cfg.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(c.cfg.From); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+func (c *EmailChannel) message(n *model.Notification, to string) []byte {
+	var msg strings.Builder
+
+	fmt.Fprintf(&msg, "From: %s\r\n", c.cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", n.Subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", n.CreatedAt.Format(time.RFC1123Z))
+	// Message-ID is stable, so mail servers may drop resent duplicates.
+	fmt.Fprintf(&msg, "Message-ID: <%s@notification>\r\n", n.ID)
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(n.Body, "\n", "\r\n"))
+	msg.WriteString("\r\n")
+
+	return []byte(msg.String())
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/sunnyyssh/designing-software-cw3/notification/internal/model"
+)
+
+// fakeSMTP accepts a single mail and sends it to the channel.
+type fakeSMTP struct {
+	ln    net.Listener
+	mails chan fakeMail
+}
+
+type fakeMail struct {
+	from string
+	to   []string
+	data string
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &fakeSMTP{ln: ln, mails: make(chan fakeMail, 1)}
+	go s.serve()
+	return s
+}
+
+func (s *fakeSMTP) serve() {
+	conn, err := s.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	tp := textproto.NewConn(conn)
+	var mail fakeMail
+
+	tp.PrintfLine("220 localhost fake SMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(cmd) {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250 localhost")
+		case "MAIL":
+			mail.from = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			mail.to = append(mail.to, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			mail.data = string(data)
+			tp.PrintfLine("250 OK")
+			s.mails <- mail
+		case "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+		default:
+			tp.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+func TestEmailChannelSendsMail(t *testing.T) {
+	server := newFakeSMTP(t)
+
+	channel := NewEmailChannel(&SMTPConfig{
+		Addr: server.ln.Addr().String(),
+		From: "notification@localhost",
+	})
+
+	n := &model.Notification{
+		ID:        uuid.Must(uuid.NewV4()),
+		Subject:   "Order is paid",
+		Body:      "Your order is paid.",
+		CreatedAt: time.Now(),
+	}
+	prefs := &model.Preferences{Email: "user@example.com"}
+
+	if err := channel.Send(context.Background(), n, prefs); err != nil {
+		t.Fatalf("send: %s", err)
+	}
+
+	var mail fakeMail
+	select {
+	case mail = <-server.mails:
+	case <-time.After(5 * time.Second):
+		t.Fatal("mail wasn't received")
+	}
+
+	if mail.from != "notification@localhost" {
+		t.Errorf("from = %q, want notification@localhost", mail.from)
+	}
+	if len(mail.to) != 1 || mail.to[0] != prefs.Email {
+		t.Errorf("to = %v, want [%s]", mail.to, prefs.Email)
+	}
+
+	msg, err := textproto.NewReader(bufio.NewReader(strings.NewReader(mail.data))).ReadMIMEHeader()
+	if err != nil {
+		t.Fatalf("read headers: %s", err)
+	}
+	if got := msg.Get("Subject"); got != n.Subject {
+		t.Errorf("subject = %q, want %q", got, n.Subject)
+	}
+	if !strings.Contains(mail.data, n.Body) {
+		t.Errorf("mail %q doesn't contain body %q", mail.data, n.Body)
+	}
+}
+
+func TestEmailChannelRequiresAddress(t *testing.T) {
+	channel := NewEmailChannel(&SMTPConfig{Addr: "127.0.0.1:1"})
+
+	err := channel.Send(context.Background(), &model.Notification{}, &model.Preferences{})
+	if !errors.Is(err, ErrNoAddress) {
+		t.Fatalf("err = %v, want %v", err, ErrNoAddress)
+	}
+}
+
+func TestEmailChannelStopsOnHungServer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	// Server accepts connection but never greets.
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		t.Cleanup(func() { conn.Close() })
+	}()
+
+	channel := NewEmailChannel(&SMTPConfig{Addr: ln.Addr().String(), From: "notification@localhost"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = channel.Send(ctx, &model.Notification{ID: uuid.Must(uuid.NewV4())}, &model.Preferences{Email: "user@example.com"})
+	if err == nil {
+		t.Fatal("send to hung server succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("send returned after %s, want about context timeout", elapsed)
+	}
+}
+
+func TestRender(t *testing.T) {
+	orderID := uuid.Must(uuid.NewV4())
+
+	_, body, err := render(model.KindOrderCancelled, &model.OrderEvent{
+		OrderID: orderID,
+		Status:  model.StatusCancelled,
+		CancelReason: &model.CancelReason{
+			Code:    "insufficient_funds",
+			Details: "account balance 10 is less than order amount 50",
+		},
+	})
+	if err != nil {
+		t.Fatalf("render: %s", err)
+	}
+
+	want := "Your order " + orderID.String() + " is cancelled: there is not enough money on your account" +
+		" (account balance 10 is less than order amount 50). If you were charged, the money is refunded."
+	if body != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+}
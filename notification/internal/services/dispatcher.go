@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/sunnyyssh/designing-software-cw3/notification/internal/model"
+	"github.com/sunnyyssh/designing-software-cw3/notification/internal/storage"
+)
+
+type DispatcherConfig struct {
+	Period    time.Duration
+	BatchSize int
+	// MaxAttempts is how many times delivery is tried before it's marked failed.
+	MaxAttempts int
+	// RetryAfter is a delay after the first failed attempt. It grows linearly with attempts.
+	RetryAfter time.Duration
+	// Timeout of single sending through a channel.
+	Timeout time.Duration
+}
+
+// Dispatcher sends pending deliveries through their channels.
+type Dispatcher struct {
+	storage  *storage.Storage
+	channels map[model.Channel]Channel
+	cfg      *DispatcherConfig
+	logger   *slog.Logger
+}
+
+func NewDispatcher(
+	storage *storage.Storage,
+	channels map[model.Channel]Channel,
+	cfg *DispatcherConfig,
+	logger *slog.Logger,
+) *Dispatcher {
+	return &Dispatcher{
+		storage:  storage,
+		channels: channels,
+		cfg:      cfg,
+		logger:   logger,
+	}
+}
+
+func (d *Dispatcher) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-time.Tick(d.cfg.Period):
+			cnt, err := d.singleRun(ctx)
+			if err != nil {
+				d.logger.ErrorContext(ctx, "dispatching notifications failed", "error", err)
+				continue
+			}
+
+			if cnt == 0 {
+				d.logger.DebugContext(ctx, "dispatching notifications", "cnt", cnt)
+			} else {
+				d.logger.InfoContext(ctx, "dispatching notifications", "cnt", cnt)
+			}
+		}
+	}
+}
+
+// singleRun claims a batch of due deliveries and sends them. Claim, sending and recording of every result are
+// separate transactions, so no row lock is held while channels send.
+func (d *Dispatcher) singleRun(ctx context.Context) (int, error) {
+	jobs, err := d.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range jobs {
+		if err := d.deliver(ctx, &jobs[i]); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(jobs), nil
+}
+
+type job struct {
+	delivery     model.Delivery
+	notification *model.Notification
+	prefs        *model.Preferences
+}
+
+// claim leases a batch of due deliveries and loads what is needed to send them.
+func (d *Dispatcher) claim(ctx context.Context) (_ []job, err error) {
+	repo, endTx, err := d.storage.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer endTx(ctx, &err)
+
+	// The batch is sent one by one, so the lease covers all of it.
+	lease := d.cfg.Timeout * time.Duration(d.cfg.BatchSize+1)
+
+	deliveries, err := repo.Delivery().Claim(ctx, d.cfg.BatchSize, lease)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]job, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		n, err := repo.Notification().Get(ctx, delivery.NotificationID)
+		if err != nil {
+			return nil, err
+		}
+
+		prefs, err := repo.Preferences().Get(ctx, n.UserID)
+		if err != nil {
+			return nil, err
+		}
+
+		jobs = append(jobs, job{delivery: delivery, notification: n, prefs: prefs})
+	}
+
+	return jobs, nil
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, j *job) error {
+	delivery, n := &j.delivery, j.notification
+
+	logger := d.logger.With("notification_id", n.ID, "channel", delivery.Channel, "kind", n.Kind)
+
+	sendCtx, cancel := context.WithTimeout(ctx, d.cfg.Timeout)
+	err := d.send(sendCtx, n, j.prefs, delivery.Channel)
+	cancel()
+
+	delivery.Attempts++
+
+	if err == nil {
+		delivery.Status = model.DeliverySent
+		delivery.LastError = nil
+		logger.InfoContext(ctx, "notification delivered")
+
+		return d.update(ctx, delivery)
+	}
+
+	logger.WarnContext(ctx, "notification delivery failed", "attempt", delivery.Attempts, "error", err)
+
+	errText := err.Error()
+	delivery.LastError = &errText
+	delivery.NextAttemptAt = time.Now().Add(time.Duration(delivery.Attempts) * d.cfg.RetryAfter)
+
+	// Missing address or channel won't appear by retrying.
+	if delivery.Attempts >= d.cfg.MaxAttempts || errors.Is(err, ErrNoAddress) || errors.Is(err, errNoChannel) {
+		delivery.Status = model.DeliveryFailed
+	}
+
+	return d.update(ctx, delivery)
+}
+
+func (d *Dispatcher) update(ctx context.Context, delivery *model.Delivery) (err error) {
+	repo, endTx, err := d.storage.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer endTx(ctx, &err)
+
+	return repo.Delivery().Update(ctx, delivery)
+}
+
+var errNoChannel = errors.New("channel is not configured")
+
+func (d *Dispatcher) send(ctx context.Context, n *model.Notification, prefs *model.Preferences, name model.Channel) error {
+	channel, ok := d.channels[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", errNoChannel, name)
+	}
+
+	return channel.Send(ctx, n, prefs)
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/gofrs/uuid"
+	"github.com/sunnyyssh/designing-software-cw3/notification/internal/model"
+	"github.com/sunnyyssh/designing-software-cw3/notification/internal/storage"
+	"github.com/sunnyyssh/designing-software-cw3/shared/errs"
+	"github.com/sunnyyssh/designing-software-cw3/shared/txcontext"
+)
+
+type NotificationService struct {
+	storage *storage.Storage
+}
+
+func NewNotificationService(storage *storage.Storage) *NotificationService {
+	return &NotificationService{storage}
+}
+
+// HandleOrderEvent notifies user that order is paid or cancelled.
+func (s *NotificationService) HandleOrderEvent(ctx context.Context, event *model.OrderEvent) error {
+	var kind model.Kind
+	switch event.Status {
+	case model.StatusFinished:
+		kind = model.KindOrderPaid
+	case model.StatusCancelled:
+		kind = model.KindOrderCancelled
+	default:
+		return nil
+	}
+
+	dedupKey := fmt.Sprintf("order:%s:%s", event.OrderID, event.Status)
+
+	return s.notify(ctx, event.UserID, kind, dedupKey, event)
+}
+
+// HandleBalance notifies user when balance falls below the threshold.
+// User is notified once until balance gets above the threshold again.
+func (s *NotificationService) HandleBalance(ctx context.Context, msg *model.BalanceMessage) (err error) {
+	repo, endTx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer endTx(ctx, &err)
+
+	prefs, err := repo.Preferences().Get(ctx, msg.UserID)
+	if err != nil {
+		return err
+	}
+
+	threshold := prefs.LowBalanceThreshold
+	if msg.Balance >= threshold || msg.Balance-msg.Amount < threshold {
+		return nil
+	}
+
+	data := balanceData{
+		BalanceMessage: *msg,
+		Threshold:      threshold,
+	}
+
+	return s.notify(txcontext.WithTx(ctx, repo.Tx()), msg.UserID, model.KindBalanceLow, "balance:"+msg.ID.String(), data)
+}
+
+// notify renders notification and schedules its delivery to channels enabled by user.
+// Notification with the same dedup key is made only once.
+func (s *NotificationService) notify(
+	ctx context.Context, userID uuid.UUID, kind model.Kind, dedupKey string, data any,
+) (err error) {
+	repo, endTx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer endTx(ctx, &err)
+
+	prefs, err := repo.Preferences().Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !prefs.Wants(kind) {
+		return nil
+	}
+
+	subject, body, err := render(kind, data)
+	if err != nil {
+		return err
+	}
+
+	n := &model.Notification{
+		ID:       uuid.Must(uuid.NewV4()),
+		UserID:   userID,
+		Kind:     kind,
+		DedupKey: dedupKey,
+		Subject:  subject,
+		Body:     body,
+	}
+
+	created, err := repo.Notification().Create(ctx, n)
+	if err != nil || !created {
+		return err
+	}
+
+	for _, channel := range prefs.Channels {
+		if channel == model.ChannelEmail && prefs.Email == "" {
+			continue
+		}
+
+		err := repo.Delivery().Create(ctx, &model.Delivery{
+			NotificationID: n.ID,
+			Channel:        channel,
+			Status:         model.DeliveryPending,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *NotificationService) ListNotifications(
+	ctx context.Context, userID uuid.UUID, unreadOnly bool, limit int,
+) (_ []model.Notification, err error) {
+	repo, endTx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer endTx(ctx, &err)
+
+	return repo.Feed().List(ctx, userID, unreadOnly, limit)
+}
+
+func (s *NotificationService) MarkRead(ctx context.Context, userID, id uuid.UUID) (_ *model.Notification, err error) {
+	repo, endTx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer endTx(ctx, &err)
+
+	return repo.Feed().MarkRead(ctx, userID, id)
+}
+
+func (s *NotificationService) GetPreferences(ctx context.Context, userID uuid.UUID) (_ *model.Preferences, err error) {
+	repo, endTx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer endTx(ctx, &err)
+
+	return repo.Preferences().Get(ctx, userID)
+}
+
+func (s *NotificationService) UpdatePreferences(ctx context.Context, prefs *model.Preferences) (_ *model.Preferences, err error) {
+	for _, channel := range prefs.Channels {
+		if !slices.Contains(model.Channels, channel) {
+			return nil, errs.Unprocessable(errs.FieldError{Field: "channels", Message: fmt.Sprintf("unknown channel %q", channel)})
+		}
+	}
+	for _, kind := range prefs.Muted {
+		if !slices.Contains(model.Kinds, kind) {
+			return nil, errs.Unprocessable(errs.FieldError{Field: "muted", Message: fmt.Sprintf("unknown kind %q", kind)})
+		}
+	}
+	if slices.Contains(prefs.Channels, model.ChannelEmail) && prefs.Email == "" {
+		return nil, errs.Unprocessable(errs.FieldError{Field: "email", Message: "is required for email channel"})
+	}
+
+	repo, endTx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer endTx(ctx, &err)
+
+	if err := repo.Preferences().Upsert(ctx, prefs); err != nil {
+		return nil, err
+	}
+
+	return prefs, nil
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/sunnyyssh/designing-software-cw3/notification/internal/model"
+)
+
+type messageTemplate struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+// templates render notifications of every kind. Data of order kinds is model.OrderEvent,
+// data of balance kinds is balanceData.
+var templates = map[model.Kind]messageTemplate{
+	model.KindOrderPaid: mustTemplate(model.KindOrderPaid,
+		`Order {{.OrderID}} is paid`,
+		`Your order {{.OrderID}} is paid and will be shipped soon.`,
+	),
+	model.KindOrderCancelled: mustTemplate(model.KindOrderCancelled,
+		`Order {{.OrderID}} is cancelled`,
+		`Your order {{.OrderID}} is cancelled{{with .CancelReason}}: {{reason .Code}}{{with .Details}} ({{.}}){{end}}{{end}}.`+
+			` If you were charged, the money is refunded.`,
+	),
+	model.KindBalanceLow: mustTemplate(model.KindBalanceLow,
+		`Your balance is running low`,
+		`Your balance is {{.Balance}} now, which is below {{.Threshold}}. Top up your account to keep ordering.`,
+	),
+}
+
+type balanceData struct {
+	model.BalanceMessage
+	Threshold int64
+}
+
+// reasons are human readable descriptions of order cancel reasons. Unknown codes are shown as is.
+var reasons = map[string]string{
+	"account_not_found":  "you have no account",
+	"insufficient_funds": "there is not enough money on your account",
+	"payment_voided":     "payment is voided",
+	"out_of_stock":       "some items are out of stock",
+	"timed_out":          "it wasn't paid in time",
+}
+
+var templateFuncs = template.FuncMap{
+	"reason": func(code string) string {
+		if text, ok := reasons[code]; ok {
+			return text
+		}
+		return strings.ReplaceAll(code, "_", " ")
+	},
+}
+
+func mustTemplate(kind model.Kind, subject, body string) messageTemplate {
+	return messageTemplate{
+		subject: template.Must(template.New(string(kind) + "_subject").Funcs(templateFuncs).Parse(subject)),
+		body:    template.Must(template.New(string(kind) + "_body").Funcs(templateFuncs).Parse(body)),
+	}
+}
+
+// render returns subject and body of notification of kind.
+func render(kind model.Kind, data any) (string, string, error) {
+	tmpl, ok := templates[kind]
+	if !ok {
+		return "", "", fmt.Errorf("no template for notification kind %q", kind)
+	}
+
+	var subject, body strings.Builder
+
+	if err := tmpl.subject.Execute(&subject, data); err != nil {
+		return "", "", fmt.Errorf("failed to render %s subject: %w", kind, err)
+	}
+	if err := tmpl.body.Execute(&body, data); err != nil {
+		return "", "", fmt.Errorf("failed to render %s body: %w", kind, err)
+	}
+
+	return subject.String(), body.String(), nil
+}
@@ -0,0 +1,251 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/sunnyyssh/designing-software-cw3/notification/internal/model"
+	"github.com/sunnyyssh/designing-software-cw3/shared/errs"
+)
+
+type Repository interface {
+	Tx() pgx.Tx
+	Notification() NotificationRepository
+	Feed() FeedRepository
+	Delivery() DeliveryRepository
+	Preferences() PreferencesRepository
+}
+
+type repository struct {
+	db pgx.Tx
+}
+
+func (r *repository) Tx() pgx.Tx {
+	return r.db
+}
+
+func (r *repository) Notification() NotificationRepository {
+	return &notificationRepository{r.db}
+}
+
+func (r *repository) Feed() FeedRepository {
+	return &feedRepository{r.db}
+}
+
+func (r *repository) Delivery() DeliveryRepository {
+	return &deliveryRepository{r.db}
+}
+
+func (r *repository) Preferences() PreferencesRepository {
+	return &preferencesRepository{r.db}
+}
+
+type NotificationRepository interface {
+	Get(ctx context.Context, id uuid.UUID) (*model.Notification, error)
+	// Create saves notification unless one with the same dedup key exists. It tells whether notification is saved.
+	Create(context.Context, *model.Notification) (bool, error)
+}
+
+type notificationRepository struct {
+	db pgx.Tx
+}
+
+func (r *notificationRepository) Get(ctx context.Context, id uuid.UUID) (*model.Notification, error) {
+	var n model.Notification
+
+	q := `SELECT id, user_id, kind, dedup_key, subject, body, created_at FROM notifications WHERE id = $1`
+	err := r.db.QueryRow(ctx, q, id).Scan(&n.ID, &n.UserID, &n.Kind, &n.DedupKey, &n.Subject, &n.Body, &n.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errs.NotFound("notification with id %s not found", id)
+		}
+		return nil, err
+	}
+
+	return &n, nil
+}
+
+func (r *notificationRepository) Create(ctx context.Context, n *model.Notification) (bool, error) {
+	q := `INSERT INTO notifications (id, user_id, kind, dedup_key, subject, body) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (dedup_key) DO NOTHING
+		RETURNING created_at`
+	err := r.db.QueryRow(ctx, q, n.ID, n.UserID, n.Kind, n.DedupKey, n.Subject, n.Body).Scan(&n.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// FeedRepository keeps notifications delivered to in-app channel.
+type FeedRepository interface {
+	Add(context.Context, *model.Notification) error
+	List(ctx context.Context, userID uuid.UUID, unreadOnly bool, limit int) ([]model.Notification, error)
+	MarkRead(ctx context.Context, userID, id uuid.UUID) (*model.Notification, error)
+}
+
+type feedRepository struct {
+	db pgx.Tx
+}
+
+func (r *feedRepository) Add(ctx context.Context, n *model.Notification) error {
+	q := `INSERT INTO feed (notification_id, user_id, created_at) VALUES ($1, $2, $3)
+		ON CONFLICT (notification_id) DO NOTHING`
+	_, err := r.db.Exec(ctx, q, n.ID, n.UserID, n.CreatedAt)
+	return err
+}
+
+const feedColumns = `n.id, n.user_id, n.kind, n.subject, n.body, f.created_at, f.read_at`
+
+func (r *feedRepository) List(
+	ctx context.Context, userID uuid.UUID, unreadOnly bool, limit int,
+) ([]model.Notification, error) {
+	q := `SELECT ` + feedColumns + ` FROM feed f JOIN notifications n ON n.id = f.notification_id
+		WHERE f.user_id = $1 AND (NOT $2 OR f.read_at IS NULL)
+		ORDER BY f.created_at DESC LIMIT $3`
+	rows, err := r.db.Query(ctx, q, userID, unreadOnly, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]model.Notification, 0)
+
+	for rows.Next() {
+		n, err := scanFeed(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *n)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (r *feedRepository) MarkRead(ctx context.Context, userID, id uuid.UUID) (*model.Notification, error) {
+	q := `WITH f AS (
+			UPDATE feed SET read_at = COALESCE(read_at, now()) WHERE user_id = $1 AND notification_id = $2
+			RETURNING notification_id, created_at, read_at
+		)
+		SELECT ` + feedColumns + ` FROM f JOIN notifications n ON n.id = f.notification_id`
+
+	n, err := scanFeed(r.db.QueryRow(ctx, q, userID, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errs.NotFound("notification with id %s not found", id)
+		}
+		return nil, err
+	}
+
+	return n, nil
+}
+
+func scanFeed(row pgx.Row) (*model.Notification, error) {
+	var n model.Notification
+	if err := row.Scan(&n.ID, &n.UserID, &n.Kind, &n.Subject, &n.Body, &n.CreatedAt, &n.ReadAt); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+type DeliveryRepository interface {
+	Create(context.Context, *model.Delivery) error
+	// Claim returns deliveries due to be sent and postpones them by lease, so other dispatchers skip them
+	// while they are sent. Deliveries left by a stopped dispatcher are due again when the lease is over.
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]model.Delivery, error)
+	Update(context.Context, *model.Delivery) error
+}
+
+type deliveryRepository struct {
+	db pgx.Tx
+}
+
+func (r *deliveryRepository) Create(ctx context.Context, d *model.Delivery) error {
+	q := `INSERT INTO deliveries (notification_id, channel, status, next_attempt_at) VALUES ($1, $2, $3, now())
+		ON CONFLICT (notification_id, channel) DO NOTHING`
+	_, err := r.db.Exec(ctx, q, d.NotificationID, d.Channel, d.Status)
+	return err
+}
+
+func (r *deliveryRepository) Claim(ctx context.Context, limit int, lease time.Duration) ([]model.Delivery, error) {
+	q := `UPDATE deliveries SET next_attempt_at = now() + make_interval(secs => $3)
+		WHERE id IN (
+			SELECT id FROM deliveries
+			WHERE status = $1 AND next_attempt_at <= now()
+			ORDER BY next_attempt_at LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, notification_id, channel, status, attempts, next_attempt_at, last_error`
+	rows, err := r.db.Query(ctx, q, model.DeliveryPending, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]model.Delivery, 0)
+
+	for rows.Next() {
+		var d model.Delivery
+		err := rows.Scan(&d.ID, &d.NotificationID, &d.Channel, &d.Status, &d.Attempts, &d.NextAttemptAt, &d.LastError)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (r *deliveryRepository) Update(ctx context.Context, d *model.Delivery) error {
+	q := `UPDATE deliveries SET status = $2, attempts = $3, next_attempt_at = $4, last_error = $5 WHERE id = $1`
+	_, err := r.db.Exec(ctx, q, d.ID, d.Status, d.Attempts, d.NextAttemptAt, d.LastError)
+	return err
+}
+
+type PreferencesRepository interface {
+	// Get returns preferences of user. Default ones are returned if user hasn't set them.
+	Get(ctx context.Context, userID uuid.UUID) (*model.Preferences, error)
+	Upsert(context.Context, *model.Preferences) error
+}
+
+type preferencesRepository struct {
+	db pgx.Tx
+}
+
+func (r *preferencesRepository) Get(ctx context.Context, userID uuid.UUID) (*model.Preferences, error) {
+	p := &model.Preferences{UserID: userID}
+
+	q := `SELECT email, channels, muted, low_balance_threshold FROM preferences WHERE user_id = $1`
+	err := r.db.QueryRow(ctx, q, userID).Scan(&p.Email, &p.Channels, &p.Muted, &p.LowBalanceThreshold)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.DefaultPreferences(userID), nil
+		}
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (r *preferencesRepository) Upsert(ctx context.Context, p *model.Preferences) error {
+	q := `INSERT INTO preferences (user_id, email, channels, muted, low_balance_threshold) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id) DO UPDATE
+		SET email = EXCLUDED.email, channels = EXCLUDED.channels, muted = EXCLUDED.muted,
+			low_balance_threshold = EXCLUDED.low_balance_threshold`
+	_, err := r.db.Exec(ctx, q, p.UserID, p.Email, p.Channels, p.Muted, p.LowBalanceThreshold)
+	return err
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"errors"
+	"expvar"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -11,17 +12,20 @@ import (
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rabbitmq/amqp091-go"
+	"github.com/sunnyyssh/designing-software-cw3/order/internal/events"
+	"github.com/sunnyyssh/designing-software-cw3/order/internal/handlers"
+	"github.com/sunnyyssh/designing-software-cw3/order/internal/model"
 	"github.com/sunnyyssh/designing-software-cw3/order/internal/rabbit"
 	"github.com/sunnyyssh/designing-software-cw3/order/internal/rest"
 	"github.com/sunnyyssh/designing-software-cw3/order/internal/services"
 	"github.com/sunnyyssh/designing-software-cw3/order/internal/storage"
+	"github.com/sunnyyssh/designing-software-cw3/shared/auth"
 	"github.com/sunnyyssh/designing-software-cw3/shared/httplib"
+	"github.com/sunnyyssh/designing-software-cw3/shared/inbox"
 	"github.com/sunnyyssh/designing-software-cw3/shared/outbox"
-)
-
-const (
-	QueueOrderToPayment = "order_to_payment"
-	QueuePaymentToOrder = "payment_to_order"
+	"github.com/sunnyyssh/designing-software-cw3/shared/requestid"
+	"github.com/sunnyyssh/designing-software-cw3/shared/saga"
+	"github.com/sunnyyssh/designing-software-cw3/shared/webhook"
 )
 
 func run(ctx context.Context, logger *slog.Logger) error {
@@ -79,12 +83,12 @@ func run(ctx context.Context, logger *slog.Logger) error {
 	defer ch.Close()
 
 	qSend, err := ch.QueueDeclare(
-		QueueOrderToPayment, // name
-		true,                // durable (очередь переживет перезапуск брокера)
-		false,               // delete when unused
-		false,               // exclusive
-		false,               // no-wait
-		nil,                 // arguments
+		model.QueueOrderToPayment, // name
+		true,                      // durable (очередь переживет перезапуск брокера)
+		false,                     // delete when unused
+		false,                     // exclusive
+		false,                     // no-wait
+		nil,                       // arguments
 	)
 	if err != nil {
 		return err
@@ -92,13 +96,49 @@ func run(ctx context.Context, logger *slog.Logger) error {
 
 	queuePublisher := rabbit.NewPublisher(ch, &qSend)
 
+	_, err = ch.QueueDeclare(
+		model.QueueOrderToInventory, // name
+		true,                        // durable
+		false,                       // delete when unused
+		false,                       // exclusive
+		false,                       // no-wait
+		nil,                         // arguments
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = ch.QueueDeclare(
+		model.QueueOrderToNotification, // name
+		true,                           // durable
+		false,                          // delete when unused
+		false,                          // exclusive
+		false,                          // no-wait
+		nil,                            // arguments
+	)
+	if err != nil {
+		return err
+	}
+
 	qReceive, err := ch.QueueDeclare(
-		QueuePaymentToOrder, // name
-		true,                // durable
-		false,               // delete when unused
-		false,               // exclusive
-		false,               // no-wait
-		nil,                 // arguments
+		model.QueuePaymentToOrder, // name
+		true,                      // durable
+		false,                     // delete when unused
+		false,                     // exclusive
+		false,                     // no-wait
+		nil,                       // arguments
+	)
+	if err != nil {
+		return err
+	}
+
+	qReceiveInventory, err := ch.QueueDeclare(
+		model.QueueInventoryToOrder, // name
+		true,                        // durable
+		false,                       // delete when unused
+		false,                       // exclusive
+		false,                       // no-wait
+		nil,                         // arguments
 	)
 	if err != nil {
 		return err
@@ -106,12 +146,79 @@ func run(ctx context.Context, logger *slog.Logger) error {
 
 	st := storage.NewStorage(db)
 
-	service := services.NewOrderService(st)
+	orchestrator := saga.NewOrchestrator(
+		db,
+		&saga.Config{
+			Period:     5 * time.Second,
+			RetryAfter: 30 * time.Second,
+			BatchSize:  10,
+		},
+		logger,
+		services.NewOrderSaga(st),
+	)
+	go func() {
+		if err := orchestrator.Run(ctx); err != nil {
+			if errors.Is(err, context.Canceled) {
+				logger.Info("saga orchestrator gracefully stopped")
+			} else {
+				logger.Error("saga orchestrator failed and stopped", "error", err)
+			}
+		}
+	}()
+
+	orderTimeout, err := durationFromEnv("ORDER_TIMEOUT", 5*time.Minute)
+	if err != nil {
+		return err
+	}
+
+	service := services.NewOrderService(st, orchestrator, orderTimeout)
 
-	queueListener := rabbit.NewListener(service, ch, &qReceive, logger)
+	sweeper := services.NewSweeper(
+		st,
+		orchestrator,
+		&services.SweeperConfig{
+			Period:        5 * time.Second,
+			StatusGrace:   30 * time.Second,
+			LegacyTimeout: orderTimeout,
+			BatchSize:     10,
+		},
+		logger,
+	)
 	go func() {
-		if err := queueListener.Run(ctx); err != nil {
-			logger.ErrorContext(ctx, "listening queue failed", "error", err)
+		if err := sweeper.Run(ctx); err != nil {
+			if errors.Is(err, context.Canceled) {
+				logger.Info("sweeper gracefully stopped")
+			} else {
+				logger.Error("sweeper failed and stopped", "error", err)
+			}
+		}
+	}()
+
+	for _, q := range []*amqp091.Queue{&qReceive, &qReceiveInventory} {
+		queueListener := rabbit.NewListener(db, ch, q, logger)
+		go func() {
+			if err := queueListener.Run(ctx); err != nil {
+				logger.ErrorContext(ctx, "listening queue failed", "error", err)
+			}
+		}()
+	}
+
+	inboxWorker := inbox.NewWorker(
+		db,
+		handlers.NewInboxHandler(service),
+		&inbox.Config{
+			Period:    1 * time.Second,
+			BatchSize: 1,
+		},
+		logger,
+	)
+	go func() {
+		if err := inboxWorker.Run(ctx); err != nil {
+			if errors.Is(err, context.Canceled) {
+				logger.Info("inbox worker gracefully stopped")
+			} else {
+				logger.Error("inbox worker failed and stopped", "error", err)
+			}
 		}
 	}()
 
@@ -125,12 +232,55 @@ func run(ctx context.Context, logger *slog.Logger) error {
 		logger,
 	)
 
+	broker := events.NewBroker(db, logger)
+	go func() {
+		if err := broker.Run(ctx); err != nil {
+			if errors.Is(err, context.Canceled) {
+				logger.Info("events broker gracefully stopped")
+			} else {
+				logger.Error("events broker failed and stopped", "error", err)
+			}
+		}
+	}()
+
+	dispatcher := webhook.NewDispatcher(
+		db,
+		webhook.NewSender(webhook.NewClient()),
+		webhookConfig,
+		logger,
+	)
+	go func() {
+		if err := dispatcher.Run(ctx); err != nil {
+			if errors.Is(err, context.Canceled) {
+				logger.Info("webhook dispatcher gracefully stopped")
+			} else {
+				logger.Error("webhook dispatcher failed and stopped", "error", err)
+			}
+		}
+	}()
+
 	handler := rest.NewOrderHandler(service)
+	eventsHandler := rest.NewEventsHandler(service, broker)
 
 	r.Mount("/order").
 		GET("/{orderId}", handler.GetOrder).
 		GET("/all", handler.ListOrders).
-		POST("", handler.CreateOrder)
+		HandleFunc("GET", "/{orderId}/events", eventsHandler.OrderEvents)
+
+	r.Mount("/order").
+		Use(auth.MiddlewareUserID).
+		POST("", handler.CreateOrder).
+		HandleFunc("GET", "/events", eventsHandler.UserEvents)
+
+	webhook.NewHandler(webhook.NewStore(db), model.WebhookEvents...).Mount(r.Mount("/webhook"))
+
+	catalogHandler := rest.NewCatalogHandler(services.NewCatalogService(st))
+
+	r.Mount("/product").
+		GET("/{sku}", catalogHandler.GetProduct).
+		GET("/all", catalogHandler.ListProducts).
+		PUT("/{sku}", catalogHandler.PutProduct).
+		DELETE("/{sku}", catalogHandler.DeleteProduct)
 
 	go func() {
 		if err := outboxWorker.Run(ctx); err != nil {
@@ -142,14 +292,44 @@ func run(ctx context.Context, logger *slog.Logger) error {
 		}
 	}()
 
-	if err := http.ListenAndServe(":8080", r); err != nil {
+	mux := http.NewServeMux()
+	mux.Handle("/", r)
+	mux.Handle("GET /debug/vars", expvar.Handler())
+
+	if err := http.ListenAndServe(":8080", mux); err != nil {
 		return err
 	}
 	return nil
 }
 
+var webhookConfig = &webhook.Config{
+	Period:       1 * time.Second,
+	BatchSize:    10,
+	Timeout:      10 * time.Second,
+	MaxAttempts:  10,
+	Backoff:      5 * time.Second,
+	MaxBackoff:   1 * time.Hour,
+	DisableAfter: 20,
+}
+
+func durationFromEnv(key string, defaultValue time.Duration) (time.Duration, error) {
+	val := os.Getenv(key)
+	if val == "" {
+		return defaultValue, nil
+	}
+
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return d, nil
+}
+
 func main() {
-	logger := slog.Default()
+	// Default logger is replaced, so records logged by httplib carry request ID too.
+	logger := slog.New(requestid.NewHandler(slog.NewTextHandler(os.Stderr, nil)))
+	slog.SetDefault(logger)
+
 	ctx := context.Background()
 
 	if err := run(ctx, logger); err != nil {
@@ -176,4 +356,107 @@ var migrations = []string{
 		id SERIAL,
 		message JSONB NOT NULL
 	)`,
+
+	`CREATE TABLE IF NOT EXISTS products (
+		sku VARCHAR(64) PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		price BIGINT NOT NULL
+	)`,
+
+	`CREATE TABLE IF NOT EXISTS order_items (
+		order_id UUID NOT NULL REFERENCES orders (id),
+		position INT NOT NULL,
+		sku VARCHAR(64) NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		quantity BIGINT NOT NULL,
+		unit_price BIGINT NOT NULL,
+		PRIMARY KEY (order_id, position)
+	)`,
+
+	`ALTER TABLE outbox ADD COLUMN IF NOT EXISTS destination VARCHAR(255) NOT NULL DEFAULT ''`,
+
+	`CREATE TABLE IF NOT EXISTS inbox (
+		id SERIAL,
+		source VARCHAR(255) NOT NULL DEFAULT '',
+		message JSONB NOT NULL
+	)`,
+
+	`CREATE TABLE IF NOT EXISTS sagas (
+		id UUID PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		step INT NOT NULL,
+		status VARCHAR(32) NOT NULL,
+		data JSONB NOT NULL,
+		attempts INT NOT NULL DEFAULT 0,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+
+	`CREATE INDEX IF NOT EXISTS sagas_status_updated_at_idx ON sagas (status, updated_at)`,
+
+	`ALTER TABLE orders ADD COLUMN IF NOT EXISTS deadline TIMESTAMPTZ`,
+
+	`ALTER TABLE orders ADD COLUMN IF NOT EXISTS status_requested BOOLEAN NOT NULL DEFAULT false`,
+
+	`CREATE INDEX IF NOT EXISTS orders_status_deadline_idx ON orders (status, deadline)`,
+
+	`ALTER TABLE orders ADD COLUMN IF NOT EXISTS cancel_reason VARCHAR(64)`,
+
+	`ALTER TABLE orders ADD COLUMN IF NOT EXISTS cancel_details TEXT`,
+
+	`ALTER TABLE sagas ADD COLUMN IF NOT EXISTS failure JSONB`,
+
+	`CREATE TABLE IF NOT EXISTS order_events (
+		id BIGSERIAL PRIMARY KEY,
+		order_id UUID NOT NULL,
+		user_id UUID NOT NULL,
+		status VARCHAR(255) NOT NULL,
+		cancel_reason JSONB,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+
+	`CREATE INDEX IF NOT EXISTS order_events_order_id_idx ON order_events (order_id, id)`,
+
+	`CREATE INDEX IF NOT EXISTS order_events_user_id_idx ON order_events (user_id, id)`,
+
+	`CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+		id UUID PRIMARY KEY,
+		user_id UUID NOT NULL,
+		url TEXT NOT NULL,
+		events TEXT[] NOT NULL,
+		secret VARCHAR(64) NOT NULL,
+		active BOOLEAN NOT NULL DEFAULT true,
+		failures INT NOT NULL DEFAULT 0,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+
+	`CREATE INDEX IF NOT EXISTS webhook_subscriptions_user_id_idx ON webhook_subscriptions (user_id)`,
+
+	`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id BIGSERIAL PRIMARY KEY,
+		subscription_id UUID NOT NULL REFERENCES webhook_subscriptions (id) ON DELETE CASCADE,
+		event_id UUID NOT NULL,
+		event_type VARCHAR(255) NOT NULL,
+		payload JSONB NOT NULL,
+		status VARCHAR(32) NOT NULL,
+		attempts INT NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMPTZ NOT NULL,
+		last_status_code INT,
+		last_error TEXT,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		delivered_at TIMESTAMPTZ
+	)`,
+
+	`CREATE INDEX IF NOT EXISTS webhook_deliveries_status_next_attempt_at_idx ON webhook_deliveries (status, next_attempt_at)`,
+
+	`CREATE INDEX IF NOT EXISTS webhook_deliveries_subscription_id_idx ON webhook_deliveries (subscription_id, id)`,
+
+	`ALTER TABLE outbox ADD COLUMN IF NOT EXISTS request_id VARCHAR(128) NOT NULL DEFAULT ''`,
+
+	`ALTER TABLE inbox ADD COLUMN IF NOT EXISTS request_id VARCHAR(128) NOT NULL DEFAULT ''`,
+
+	`ALTER TABLE sagas ADD COLUMN IF NOT EXISTS request_id VARCHAR(128) NOT NULL DEFAULT ''`,
+
+	`ALTER TABLE orders ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now()`,
+
+	`CREATE INDEX IF NOT EXISTS orders_user_id_created_at_idx ON orders (user_id, created_at DESC)`,
 }
@@ -0,0 +1,132 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sunnyyssh/designing-software-cw3/order/internal/model"
+	"github.com/sunnyyssh/designing-software-cw3/order/internal/storage"
+)
+
+const subscriberBuffer = 16
+
+type Filter func(*model.OrderEvent) bool
+
+type subscriber struct {
+	filter Filter
+	ch     chan model.OrderEvent
+}
+
+// Broker listens to events notified by any order replica via PostgreSQL NOTIFY
+// and fans them out to local subscribers.
+type Broker struct {
+	db     *pgxpool.Pool
+	logger *slog.Logger
+
+	mu   sync.Mutex
+	subs map[*subscriber]struct{}
+}
+
+func NewBroker(db *pgxpool.Pool, logger *slog.Logger) *Broker {
+	return &Broker{
+		db:     db,
+		logger: logger,
+		subs:   make(map[*subscriber]struct{}),
+	}
+}
+
+// Subscribe returns channel of events matching filter. The channel is closed when subscriber
+// is too slow to read events or when cancel is called. Missed events should be read from storage.
+func (b *Broker) Subscribe(filter Filter) (<-chan model.OrderEvent, func()) {
+	sub := &subscriber{
+		filter: filter,
+		ch:     make(chan model.OrderEvent, subscriberBuffer),
+	}
+
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+
+	cancel := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, ok := b.subs[sub]; ok {
+			delete(b.subs, sub)
+			close(sub.ch)
+		}
+	}
+
+	return sub.ch, cancel
+}
+
+// Run listens to notifications until ctx is done. Connection is reestablished on failures.
+func (b *Broker) Run(ctx context.Context) error {
+	for {
+		err := b.listen(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		b.logger.ErrorContext(ctx, "listening order events failed, retrying in 2 seconds...", "error", err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+func (b *Broker) listen(ctx context.Context) error {
+	poolConn, err := b.db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// Connection in LISTEN state must not return to the pool.
+	conn := poolConn.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+storage.EventsChannel); err != nil {
+		return err
+	}
+
+	b.logger.InfoContext(ctx, "listening order events", "channel", storage.EventsChannel)
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		var event model.OrderEvent
+		if err := json.Unmarshal([]byte(notification.Payload), &event); err != nil {
+			b.logger.ErrorContext(ctx, "invalid order event notification", "payload", notification.Payload, "error", err)
+			continue
+		}
+
+		b.publish(&event)
+	}
+}
+
+func (b *Broker) publish(event *model.OrderEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subs {
+		if !sub.filter(event) {
+			continue
+		}
+
+		select {
+		case sub.ch <- *event:
+		default:
+			delete(b.subs, sub)
+			close(sub.ch)
+		}
+	}
+}
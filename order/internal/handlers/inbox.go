@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/sunnyyssh/designing-software-cw3/order/internal/model"
+	"github.com/sunnyyssh/designing-software-cw3/shared/inbox"
+	"github.com/sunnyyssh/designing-software-cw3/shared/txcontext"
+)
+
+type OrderService interface {
+	SetOrderStatus(ctx context.Context, msg *model.OrderServedMessage) error
+	HandleStockReserved(ctx context.Context, id uuid.UUID, status model.ReservationStatus) error
+}
+
+func NewInboxHandler(service OrderService) inbox.HandlerFunc {
+	return func(ctx context.Context, tx pgx.Tx, messages ...inbox.Message) error {
+		ctx = txcontext.WithTx(ctx, tx)
+
+		for _, msg := range messages {
+			if err := handleMessage(ctx, service, msg); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+func handleMessage(ctx context.Context, service OrderService, msg inbox.Message) error {
+	switch msg.Source {
+	case model.QueuePaymentToOrder:
+		var servedMsg model.OrderServedMessage
+		if err := json.Unmarshal(msg.Message, &servedMsg); err != nil {
+			return err
+		}
+
+		return service.SetOrderStatus(ctx, &servedMsg)
+
+	case model.QueueInventoryToOrder:
+		var reservedMsg model.StockReservedMessage
+		if err := json.Unmarshal(msg.Message, &reservedMsg); err != nil {
+			return err
+		}
+
+		return service.HandleStockReserved(ctx, reservedMsg.ID, reservedMsg.Status)
+
+	default:
+		return fmt.Errorf("message from unknown queue %q", msg.Source)
+	}
+}
@@ -1,30 +1,197 @@
 package model
 
-import "github.com/gofrs/uuid"
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
 
 type OrderStatus string
 
 const (
 	StatusNew       OrderStatus = "new"
+	StatusReserved  OrderStatus = "reserved"
 	StatusFinished  OrderStatus = "finished"
 	StatusCancelled OrderStatus = "cancelled"
 )
 
+const (
+	QueueOrderToPayment   = "order_to_payment"
+	QueuePaymentToOrder   = "payment_to_order"
+	QueueOrderToInventory = "order_to_inventory"
+	QueueInventoryToOrder = "inventory_to_order"
+	// QueueOrderToNotification receives final order events to notify users about.
+	QueueOrderToNotification = "order_to_notification"
+)
+
+// OrderEvent is a change of order status.
+type OrderEvent struct {
+	ID           int64         `json:"id"`
+	OrderID      uuid.UUID     `json:"order_id"`
+	UserID       uuid.UUID     `json:"user_id"`
+	Status       OrderStatus   `json:"status"`
+	CancelReason *CancelReason `json:"cancel_reason,omitempty"`
+	CreatedAt    time.Time     `json:"created_at"`
+}
+
+// Webhook event types sent to subscribers of order events.
+const (
+	WebhookOrderCreated   = "order.created"
+	WebhookOrderFinished  = "order.finished"
+	WebhookOrderCancelled = "order.cancelled"
+)
+
+var WebhookEvents = []string{WebhookOrderCreated, WebhookOrderFinished, WebhookOrderCancelled}
+
+// WebhookEvent returns webhook event type of order event. Intermediate statuses aren't sent.
+func (e *OrderEvent) WebhookEvent() (string, bool) {
+	switch e.Status {
+	case StatusNew:
+		return WebhookOrderCreated, true
+	case StatusFinished:
+		return WebhookOrderFinished, true
+	case StatusCancelled:
+		return WebhookOrderCancelled, true
+	default:
+		return "", false
+	}
+}
+
+// Final tells whether status won't change anymore.
+func (s OrderStatus) Final() bool {
+	return s == StatusFinished || s == StatusCancelled
+}
+
+type Product struct {
+	SKU   string `json:"sku"`
+	Name  string `json:"name"`
+	Price int64  `json:"price"`
+}
+
 type Order struct {
 	ID          uuid.UUID   `json:"id"`
 	UserID      uuid.UUID   `json:"user_id"`
 	Description string      `json:"description"`
+	Items       []OrderItem `json:"items"`
 	Amount      int64       `json:"amount"`
 	Status      OrderStatus `json:"order_status"`
+	// Deadline is the time order is cancelled at if it's not finished. It's nil for old orders.
+	Deadline *time.Time `json:"deadline,omitempty"`
+	// CancelReason tells why order is cancelled. It's nil for other statuses and for old orders.
+	CancelReason *CancelReason `json:"cancel_reason,omitempty"`
+	// StatusRequested is set when payment is asked for the outcome of the expired order.
+	StatusRequested bool `json:"-"`
+	// CreatedAt is set by storage. Orders created before it was tracked have time of the migration.
+	CreatedAt time.Time `json:"created_at"`
 }
 
+type CancelReasonCode string
+
+const (
+	// ReasonUnknown is set when cancellation reason wasn't told, e.g. by older payment versions.
+	ReasonUnknown           CancelReasonCode = "unknown"
+	ReasonAccountNotFound   CancelReasonCode = "account_not_found"
+	ReasonInsufficientFunds CancelReasonCode = "insufficient_funds"
+	ReasonPaymentVoided     CancelReasonCode = "payment_voided"
+	ReasonOutOfStock        CancelReasonCode = "out_of_stock"
+	ReasonTimedOut          CancelReasonCode = "timed_out"
+)
+
+type CancelReason struct {
+	Code    CancelReasonCode `json:"code"`
+	Details string           `json:"details,omitempty"`
+}
+
+// OrderItem keeps product name and price as they were when order was created,
+// so catalog changes don't affect existing orders.
+type OrderItem struct {
+	SKU       string `json:"sku"`
+	Name      string `json:"name"`
+	Quantity  int64  `json:"quantity"`
+	UnitPrice int64  `json:"unit_price"`
+}
+
+type PaymentCommand string
+
+const (
+	PaymentCharge PaymentCommand = "charge"
+	// PaymentStatusRequest asks payment to resend result of the charge if it was made.
+	PaymentStatusRequest PaymentCommand = "status_request"
+	// PaymentVoid cancels the charge: money is refunded and late charge is rejected.
+	PaymentVoid PaymentCommand = "void"
+)
+
 type OrderMessage struct {
-	ID     uuid.UUID `json:"id"`
-	UserID uuid.UUID `json:"user_id"`
-	Amount int64     `json:"amount"`
+	Type   PaymentCommand `json:"type,omitempty"`
+	ID     uuid.UUID      `json:"id"`
+	UserID uuid.UUID      `json:"user_id,omitempty"`
+	Amount int64          `json:"amount,omitempty"`
 }
 
 type OrderServedMessage struct {
 	ID     uuid.UUID   `json:"id"`
 	Status OrderStatus `json:"status"`
+	// Reason and Details are set for cancelled orders.
+	Reason  CancelReasonCode `json:"reason,omitempty"`
+	Details string           `json:"details,omitempty"`
+}
+
+// UnmarshalJSON decodes message keeping compatibility with payment versions which don't send reason.
+func (m *OrderServedMessage) UnmarshalJSON(data []byte) error {
+	type plain OrderServedMessage
+
+	var msg plain
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return err
+	}
+
+	if msg.Status == StatusCancelled && msg.Reason == "" {
+		msg.Reason = ReasonUnknown
+	}
+
+	*m = OrderServedMessage(msg)
+	return nil
+}
+
+func (m *OrderServedMessage) CancelReason() *CancelReason {
+	if m.Status != StatusCancelled {
+		return nil
+	}
+
+	return &CancelReason{
+		Code:    m.Reason,
+		Details: m.Details,
+	}
+}
+
+type StockAction string
+
+const (
+	StockReserve StockAction = "reserve"
+	StockRelease StockAction = "release"
+	StockCommit  StockAction = "commit"
+)
+
+type StockItem struct {
+	SKU      string `json:"sku"`
+	Quantity int64  `json:"quantity"`
+}
+
+type StockMessage struct {
+	OrderID uuid.UUID   `json:"order_id"`
+	Action  StockAction `json:"action"`
+	Items   []StockItem `json:"items,omitempty"`
+}
+
+type ReservationStatus string
+
+const (
+	ReservationReserved ReservationStatus = "reserved"
+	ReservationRejected ReservationStatus = "rejected"
+)
+
+type StockReservedMessage struct {
+	ID     uuid.UUID         `json:"id"`
+	Status ReservationStatus `json:"status"`
 }
@@ -2,31 +2,27 @@ package rabbit
 
 import (
 	"context"
-	"encoding/json"
 	"log/slog"
 
-	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rabbitmq/amqp091-go"
-	"github.com/sunnyyssh/designing-software-cw3/order/internal/model"
+	"github.com/sunnyyssh/designing-software-cw3/shared/inbox"
+	"github.com/sunnyyssh/designing-software-cw3/shared/requestid"
 )
 
-type OrderService interface {
-	SetOrderStatus(ctx context.Context, id uuid.UUID, status model.OrderStatus) (err error)
-}
-
 type Listener struct {
-	service OrderService
-	ch      *amqp091.Channel
-	q       *amqp091.Queue
-	logger  *slog.Logger
+	db     *pgxpool.Pool
+	ch     *amqp091.Channel
+	q      *amqp091.Queue
+	logger *slog.Logger
 }
 
-func NewListener(service OrderService, ch *amqp091.Channel, q *amqp091.Queue, logger *slog.Logger) *Listener {
+func NewListener(db *pgxpool.Pool, ch *amqp091.Channel, q *amqp091.Queue, logger *slog.Logger) *Listener {
 	return &Listener{
-		service: service,
-		ch:      ch,
-		q:       q,
-		logger:  logger,
+		db:     db,
+		ch:     ch,
+		q:      q,
+		logger: logger,
 	}
 }
 
@@ -46,16 +42,21 @@ func (l *Listener) Run(ctx context.Context) error {
 		return err
 	}
 
+	logger.InfoContext(ctx, "consuming queue")
+
 LOOP:
 	for {
 		select {
 		case msg, ok := <-msgs:
 			if !ok {
+				logger.Info("stopping")
 				break LOOP
 			}
 
-			logger.Info("message received", "timestamp", msg.Timestamp)
-			if err = l.handleMessage(ctx, msg, logger); err != nil {
+			msgCtx := requestid.WithID(ctx, msg.CorrelationId)
+
+			logger.InfoContext(msgCtx, "message received", "timestamp", msg.Timestamp)
+			if err = l.handleMessage(msgCtx, msg, logger); err != nil {
 				return err
 			}
 
@@ -67,12 +68,25 @@ LOOP:
 	return nil
 }
 
-func (l *Listener) handleMessage(ctx context.Context, msg amqp091.Delivery, logger *slog.Logger) error {
-	logger.DebugContext(ctx, "got message with body", "body", string(msg.Body))
-	var orderMsg model.OrderServedMessage
-	if err := json.Unmarshal(msg.Body, &orderMsg); err != nil {
+func (l *Listener) handleMessage(ctx context.Context, msg amqp091.Delivery, logger *slog.Logger) (err error) {
+	tx, err := l.db.Begin(ctx)
+	if err != nil {
 		return err
 	}
+	defer func() {
+		if err != nil {
+			tx.Rollback(ctx)
+		}
+	}()
 
-	return l.service.SetOrderStatus(ctx, orderMsg.ID, orderMsg.Status)
+	if err = inbox.Add(ctx, tx, l.q.Name, msg.Body); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	logger.InfoContext(ctx, "message appended to inbox table")
+	return nil
 }
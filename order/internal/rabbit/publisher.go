@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 
 	"github.com/rabbitmq/amqp091-go"
+	"github.com/sunnyyssh/designing-software-cw3/shared/requestid"
 )
 
 type Publisher struct {
@@ -19,7 +20,14 @@ func NewPublisher(ch *amqp091.Channel, q *amqp091.Queue) *Publisher {
 	}
 }
 
-func (p *Publisher) Publish(ctx context.Context, msgs ...any) (err error) {
+func (p *Publisher) Publish(ctx context.Context, destination string, msgs ...any) (err error) {
+	if destination == "" {
+		destination = p.q.Name
+	}
+
+	// Request ID travels as correlation ID, so listener may restore it.
+	requestID, _ := requestid.FromContext(ctx)
+
 	for _, msg := range msgs {
 		body, err := json.Marshal(msg)
 		if err != nil {
@@ -27,13 +35,14 @@ func (p *Publisher) Publish(ctx context.Context, msgs ...any) (err error) {
 		}
 
 		err = p.ch.PublishWithContext(ctx,
-			"",       // exchange (используем default)
-			p.q.Name, // routing key (имя очереди)
-			false,    // mandatory
-			false,    // immediate
+			"",          // exchange (используем default)
+			destination, // routing key (имя очереди)
+			false,       // mandatory
+			false,       // immediate
 			amqp091.Publishing{
-				ContentType: "application/json",
-				Body:        []byte(body),
+				ContentType:   "application/json",
+				CorrelationId: requestID,
+				Body:          []byte(body),
 			},
 		)
 		if err != nil {
@@ -0,0 +1,69 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+
+	"github.com/sunnyyssh/designing-software-cw3/order/internal/model"
+	"github.com/sunnyyssh/designing-software-cw3/shared/errs"
+	"github.com/sunnyyssh/designing-software-cw3/shared/httplib"
+)
+
+// skuRe matches SKUs which fit sku column and are safe to put in paths of other services.
+var skuRe = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+type CatalogService interface {
+	GetProduct(ctx context.Context, sku string) (*model.Product, error)
+	ListProducts(ctx context.Context) ([]model.Product, error)
+	PutProduct(ctx context.Context, product *model.Product) (*model.Product, error)
+	DeleteProduct(ctx context.Context, sku string) error
+}
+
+type CatalogHandler struct {
+	service CatalogService
+}
+
+type putProductRequest struct {
+	Name  string `json:"name" validate:"required,max=255"`
+	Price int64  `json:"price" validate:"required,min=1"`
+}
+
+func NewCatalogHandler(service CatalogService) *CatalogHandler {
+	httplib.RegisterBody[putProductRequest]()
+
+	return &CatalogHandler{service}
+}
+
+func (h *CatalogHandler) GetProduct(req *http.Request) (any, error) {
+	return h.service.GetProduct(req.Context(), req.PathValue("sku"))
+}
+
+func (h *CatalogHandler) ListProducts(req *http.Request) (any, error) {
+	return h.service.ListProducts(req.Context())
+}
+
+func (h *CatalogHandler) PutProduct(req *http.Request) (any, error) {
+	sku := req.PathValue("sku")
+	if !skuRe.MatchString(sku) {
+		return nil, errs.Unprocessable(errs.FieldError{
+			Field:   "sku",
+			Message: "must be 1 to 64 letters, digits, '.', '_' or '-'",
+		})
+	}
+
+	request, err := httplib.UnmarshalBody[putProductRequest](req)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.service.PutProduct(req.Context(), &model.Product{
+		SKU:   sku,
+		Name:  request.Name,
+		Price: request.Price,
+	})
+}
+
+func (h *CatalogHandler) DeleteProduct(req *http.Request) (any, error) {
+	return nil, h.service.DeleteProduct(req.Context(), req.PathValue("sku"))
+}
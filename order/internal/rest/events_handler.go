@@ -0,0 +1,171 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/sunnyyssh/designing-software-cw3/order/internal/events"
+	"github.com/sunnyyssh/designing-software-cw3/order/internal/model"
+	"github.com/sunnyyssh/designing-software-cw3/shared/auth"
+	"github.com/sunnyyssh/designing-software-cw3/shared/httplib"
+)
+
+const heartbeatPeriod = 15 * time.Second
+
+type EventsService interface {
+	GetOrder(ctx context.Context, orderID uuid.UUID) (*model.Order, error)
+	ListEvents(ctx context.Context, orderID, userID uuid.UUID, afterID int64) ([]model.OrderEvent, error)
+}
+
+type Broker interface {
+	Subscribe(filter events.Filter) (<-chan model.OrderEvent, func())
+}
+
+// EventsHandler streams order status changes as Server-Sent Events.
+// Clients may resume the stream with Last-Event-ID header.
+type EventsHandler struct {
+	service EventsService
+	broker  Broker
+}
+
+func NewEventsHandler(service EventsService, broker Broker) *EventsHandler {
+	return &EventsHandler{
+		service: service,
+		broker:  broker,
+	}
+}
+
+// OrderEvents streams status changes of single order. Stream ends when order is finished or cancelled.
+func (h *EventsHandler) OrderEvents(w http.ResponseWriter, req *http.Request) {
+	orderID, err := uuid.FromString(req.PathValue("orderId"))
+	if err != nil {
+		httplib.Send(w, 400, map[string]any{
+			"error": fmt.Sprintf("orderId UUID path value must be specified: %s", err),
+		})
+		return
+	}
+
+	if _, err := h.service.GetOrder(req.Context(), orderID); err != nil {
+		httplib.SendError(w, err)
+		return
+	}
+
+	h.stream(w, req, orderID, uuid.Nil, func(e *model.OrderEvent) bool { return e.OrderID == orderID })
+}
+
+// UserEvents streams status changes of all orders of authenticated user.
+func (h *EventsHandler) UserEvents(w http.ResponseWriter, req *http.Request) {
+	userID := auth.MustUserIDFromContext(req.Context())
+
+	h.stream(w, req, uuid.Nil, userID, func(e *model.OrderEvent) bool { return e.UserID == userID })
+}
+
+func (h *EventsHandler) stream(
+	w http.ResponseWriter, req *http.Request, orderID, userID uuid.UUID, filter events.Filter,
+) {
+	ctx := req.Context()
+	logger := slog.With("path", req.URL.Path, "method", req.Method)
+
+	var lastID int64
+	if header := req.Header.Get("Last-Event-ID"); header != "" {
+		id, err := strconv.ParseInt(header, 10, 64)
+		if err != nil {
+			httplib.Send(w, 400, map[string]any{
+				"error": fmt.Sprintf("invalid Last-Event-ID header: %s", err),
+			})
+			return
+		}
+		lastID = id
+	}
+
+	// Subscription goes first, so events committed while history is read are not missed.
+	live, cancel := h.broker.Subscribe(filter)
+	defer cancel()
+
+	history, err := h.service.ListEvents(ctx, orderID, userID, lastID)
+	if err != nil {
+		httplib.SendError(w, err)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(200)
+
+	logger.InfoContext(ctx, "streaming order events", "last_event_id", lastID)
+
+	send := func(event *model.OrderEvent) bool {
+		if event.ID <= lastID {
+			return true
+		}
+		lastID = event.ID
+
+		if err := writeEvent(w, event); err != nil {
+			logger.InfoContext(ctx, "streaming order events stopped", "error", err)
+			return false
+		}
+		if err := rc.Flush(); err != nil {
+			logger.InfoContext(ctx, "streaming order events stopped", "error", err)
+			return false
+		}
+
+		// Single order stream ends with its final status.
+		return orderID == uuid.Nil || !event.Status.Final()
+	}
+
+	for i := range history {
+		if !send(&history[i]) {
+			return
+		}
+	}
+	// Stream is opened even if there are no events, so client knows it's working.
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatPeriod)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case event, ok := <-live:
+			if !ok {
+				// Subscriber was too slow. Client resumes with Last-Event-ID.
+				return
+			}
+			if !send(&event) {
+				return
+			}
+
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event *model.OrderEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: order_status\ndata: %s\n\n", event.ID, data)
+	return err
+}
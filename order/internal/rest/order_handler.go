@@ -3,24 +3,46 @@ package rest
 import (
 	"context"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gofrs/uuid"
 	"github.com/sunnyyssh/designing-software-cw3/order/internal/model"
+	"github.com/sunnyyssh/designing-software-cw3/order/internal/services"
+	"github.com/sunnyyssh/designing-software-cw3/shared/auth"
 	"github.com/sunnyyssh/designing-software-cw3/shared/errs"
 	"github.com/sunnyyssh/designing-software-cw3/shared/httplib"
 )
 
 type OrderService interface {
 	GetOrder(ctx context.Context, orderID uuid.UUID) (*model.Order, error)
-	ListOrders(ctx context.Context) ([]model.Order, error)
-	CreateOrder(ctx context.Context, userID uuid.UUID, amount int64, description string) (*model.Order, error)
+	ListOrders(ctx context.Context, userID uuid.UUID, limit int) ([]model.Order, error)
+	CreateOrder(
+		ctx context.Context, userID uuid.UUID, lines []services.OrderLine, description string, timeout time.Duration,
+	) (*model.Order, error)
 }
 
 type OrderHandler struct {
 	service OrderService
 }
 
+type orderItem struct {
+	SKU      string `json:"sku" validate:"required,max=64"`
+	Quantity int64  `json:"quantity" validate:"required,min=1,max=10000"`
+}
+
+type createOrderRequest struct {
+	// UserID is optional, the order is always charged to the authenticated user.
+	UserID      uuid.UUID   `json:"user_id"`
+	Description string      `json:"description" validate:"max=1000"`
+	Items       []orderItem `json:"items" validate:"required,min=1,max=100"`
+	// TimeoutSeconds overrides default time given to order to be finished.
+	TimeoutSeconds int64 `json:"timeout_seconds" validate:"min=0,max=86400"`
+}
+
 func NewOrderHandler(service OrderService) *OrderHandler {
+	httplib.RegisterBody[createOrderRequest]()
+
 	return &OrderHandler{service}
 }
 
@@ -33,20 +55,52 @@ func (h *OrderHandler) GetOrder(req *http.Request) (any, error) {
 	return h.service.GetOrder(req.Context(), orderID)
 }
 
+// ListOrders lists the most recent orders first. Optional user_id and limit query parameters filter them.
 func (h *OrderHandler) ListOrders(req *http.Request) (any, error) {
-	return h.service.ListOrders(req.Context())
+	query := req.URL.Query()
+
+	var userID uuid.UUID
+	if raw := query.Get("user_id"); raw != "" {
+		id, err := uuid.FromString(raw)
+		if err != nil {
+			return nil, errs.BadRequest("user_id query parameter must be UUID: %s", err)
+		}
+		userID = id
+	}
+
+	var limit int
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 1000 {
+			return nil, errs.BadRequest("limit query parameter must be from 1 to 1000")
+		}
+		limit = n
+	}
+
+	return h.service.ListOrders(req.Context(), userID, limit)
 }
 
 func (h *OrderHandler) CreateOrder(req *http.Request) (any, error) {
-	type Request struct {
-		UserID      uuid.UUID `json:"user_id"`
-		Description string    `json:"description"`
-		Amount      int64     `json:"amount"`
-	}
-	request, err := httplib.UnmarshalBody[Request](req)
+	request, err := httplib.UnmarshalBody[createOrderRequest](req)
 	if err != nil {
-		return nil, errs.BadRequest("invalid body: %s", err)
+		return nil, err
+	}
+
+	// Callers, API key owners included, may only order for themselves.
+	userID := auth.MustUserIDFromContext(req.Context())
+	if request.UserID != uuid.Nil && request.UserID != userID {
+		return nil, errs.Forbidden("user_id must be the authenticated user")
+	}
+
+	lines := make([]services.OrderLine, 0, len(request.Items))
+	for _, item := range request.Items {
+		lines = append(lines, services.OrderLine{
+			SKU:      item.SKU,
+			Quantity: item.Quantity,
+		})
 	}
 
-	return h.service.CreateOrder(req.Context(), request.UserID, request.Amount, request.Description)
+	timeout := time.Duration(request.TimeoutSeconds) * time.Second
+
+	return h.service.CreateOrder(req.Context(), userID, lines, request.Description, timeout)
 }
@@ -0,0 +1,75 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/sunnyyssh/designing-software-cw3/order/internal/model"
+	"github.com/sunnyyssh/designing-software-cw3/order/internal/services"
+	"github.com/sunnyyssh/designing-software-cw3/shared/auth"
+	"github.com/sunnyyssh/designing-software-cw3/shared/httplib"
+)
+
+type fakeOrderService struct {
+	OrderService
+	// userID is the user the last order is created for.
+	userID uuid.UUID
+}
+
+func (s *fakeOrderService) CreateOrder(
+	ctx context.Context, userID uuid.UUID, lines []services.OrderLine, description string, timeout time.Duration,
+) (*model.Order, error) {
+	s.userID = userID
+	return &model.Order{UserID: userID}, nil
+}
+
+func TestCreateOrder(t *testing.T) {
+	caller := uuid.Must(uuid.NewV4())
+	other := uuid.Must(uuid.NewV4())
+	item := `[{"sku": "tea", "quantity": 2}]`
+
+	for _, tt := range []struct {
+		name     string
+		header   string
+		body     string
+		wantCode int
+	}{
+		{"valid", caller.String(), `{"items": ` + item + `}`, 200},
+		{"own user_id", caller.String(), `{"user_id": "` + caller.String() + `", "items": ` + item + `}`, 200},
+		{"other user_id", caller.String(), `{"user_id": "` + other.String() + `", "items": ` + item + `}`, 403},
+		{"anonymous", "", `{"items": ` + item + `}`, 400},
+		{"missing items", caller.String(), `{"items": null}`, 422},
+		{"empty items", caller.String(), `{"items": []}`, 422},
+		{"zero quantity", caller.String(), `{"items": [{"sku": "tea", "quantity": 0}]}`, 422},
+		{"too large quantity", caller.String(), `{"items": [{"sku": "tea", "quantity": 10001}]}`, 422},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &fakeOrderService{}
+			handler := auth.MiddlewareUserID(httplib.HandlerJSON(NewOrderHandler(service).CreateOrder))
+
+			req := httptest.NewRequest(http.MethodPost, "/order", strings.NewReader(tt.body))
+			if tt.header != "" {
+				req.Header.Set(auth.HeaderUserID, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+
+			wantUser := uuid.Nil
+			if tt.wantCode == 200 {
+				wantUser = caller
+			}
+			if service.userID != wantUser {
+				t.Errorf("order is created for %s, want %s", service.userID, wantUser)
+			}
+		})
+	}
+}
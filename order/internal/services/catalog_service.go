@@ -0,0 +1,60 @@
+package services
+
+import (
+	"context"
+
+	"github.com/sunnyyssh/designing-software-cw3/order/internal/model"
+	"github.com/sunnyyssh/designing-software-cw3/order/internal/storage"
+)
+
+type CatalogService struct {
+	storage *storage.Storage
+}
+
+func NewCatalogService(storage *storage.Storage) *CatalogService {
+	return &CatalogService{storage}
+}
+
+func (s *CatalogService) GetProduct(ctx context.Context, sku string) (_ *model.Product, err error) {
+	repo, endTx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer endTx(ctx, &err)
+
+	return repo.Product().Get(ctx, sku)
+}
+
+func (s *CatalogService) ListProducts(ctx context.Context) (_ []model.Product, err error) {
+	repo, endTx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer endTx(ctx, &err)
+
+	return repo.Product().List(ctx)
+}
+
+func (s *CatalogService) PutProduct(ctx context.Context, product *model.Product) (_ *model.Product, err error) {
+	repo, endTx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer endTx(ctx, &err)
+
+	if err := repo.Product().Upsert(ctx, product); err != nil {
+		return nil, err
+	}
+
+	return product, nil
+}
+
+func (s *CatalogService) DeleteProduct(ctx context.Context, sku string) (err error) {
+	repo, endTx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer endTx(ctx, &err)
+
+	return repo.Product().Delete(ctx, sku)
+}
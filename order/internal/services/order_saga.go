@@ -0,0 +1,131 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gofrs/uuid"
+	"github.com/sunnyyssh/designing-software-cw3/order/internal/model"
+	"github.com/sunnyyssh/designing-software-cw3/order/internal/storage"
+	"github.com/sunnyyssh/designing-software-cw3/shared/saga"
+)
+
+const (
+	OrderSagaName = "create_order"
+
+	StepReserveStock  = "reserve_stock"
+	StepChargePayment = "charge_payment"
+)
+
+type orderSagaData struct {
+	UserID uuid.UUID         `json:"user_id"`
+	Amount int64             `json:"amount"`
+	Items  []model.StockItem `json:"items"`
+}
+
+// NewOrderSaga defines order flow: stock is reserved, then payment is charged.
+// If payment fails, the reservation is released and order is cancelled.
+func NewOrderSaga(storage *storage.Storage) *saga.Definition {
+	setStatus := func(status model.OrderStatus, stockAction model.StockAction) saga.HookFunc {
+		return func(ctx context.Context, inst *saga.Instance) (err error) {
+			repo, endTx, err := storage.Begin(ctx)
+			if err != nil {
+				return err
+			}
+			defer endTx(ctx, &err)
+
+			order, err := repo.Order().Get(ctx, inst.ID)
+			if err != nil {
+				return err
+			}
+
+			if status == model.StatusCancelled {
+				order.CancelReason = &model.CancelReason{Code: model.ReasonUnknown}
+				if len(inst.Failure) > 0 {
+					if err := json.Unmarshal(inst.Failure, order.CancelReason); err != nil {
+						return fmt.Errorf("invalid failure of saga %s: %w", inst.ID, err)
+					}
+				}
+			}
+
+			if stockAction != "" {
+				err = repo.Outbox().AddTo(ctx, model.QueueOrderToInventory, model.StockMessage{
+					OrderID: inst.ID,
+					Action:  stockAction,
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			order.Status = status
+			return repo.Order().Update(ctx, order)
+		}
+	}
+
+	return &saga.Definition{
+		Name: OrderSagaName,
+		Steps: []saga.Step{
+			{
+				Name: StepReserveStock,
+				Action: func(_ context.Context, id uuid.UUID, raw json.RawMessage) (*saga.Command, error) {
+					var data orderSagaData
+					if err := json.Unmarshal(raw, &data); err != nil {
+						return nil, err
+					}
+
+					return &saga.Command{
+						Destination: model.QueueOrderToInventory,
+						Message: model.StockMessage{
+							OrderID: id,
+							Action:  model.StockReserve,
+							Items:   data.Items,
+						},
+					}, nil
+				},
+				Compensation: func(_ context.Context, id uuid.UUID, _ json.RawMessage) (*saga.Command, error) {
+					return &saga.Command{
+						Destination: model.QueueOrderToInventory,
+						Message: model.StockMessage{
+							OrderID: id,
+							Action:  model.StockRelease,
+						},
+					}, nil
+				},
+				OnSuccess: setStatus(model.StatusReserved, ""),
+			},
+			{
+				Name: StepChargePayment,
+				Action: func(_ context.Context, id uuid.UUID, raw json.RawMessage) (*saga.Command, error) {
+					var data orderSagaData
+					if err := json.Unmarshal(raw, &data); err != nil {
+						return nil, err
+					}
+
+					return &saga.Command{
+						Destination: model.QueueOrderToPayment,
+						Message: model.OrderMessage{
+							Type:   model.PaymentCharge,
+							ID:     id,
+							UserID: data.UserID,
+							Amount: data.Amount,
+						},
+					}, nil
+				},
+				// Only aborted saga compensates it: charge might be made after order timed out.
+				Compensation: func(_ context.Context, id uuid.UUID, _ json.RawMessage) (*saga.Command, error) {
+					return &saga.Command{
+						Destination: model.QueueOrderToPayment,
+						Message: model.OrderMessage{
+							Type: model.PaymentVoid,
+							ID:   id,
+						},
+					}, nil
+				},
+			},
+		},
+		OnCompleted: setStatus(model.StatusFinished, model.StockCommit),
+		OnFailed:    setStatus(model.StatusCancelled, ""),
+	}
+}
@@ -2,18 +2,37 @@ package services
 
 import (
 	"context"
+	"errors"
+	"math"
+	"time"
 
 	"github.com/gofrs/uuid"
 	"github.com/sunnyyssh/designing-software-cw3/order/internal/model"
 	"github.com/sunnyyssh/designing-software-cw3/order/internal/storage"
+	"github.com/sunnyyssh/designing-software-cw3/shared/errs"
+	"github.com/sunnyyssh/designing-software-cw3/shared/saga"
+	"github.com/sunnyyssh/designing-software-cw3/shared/txcontext"
 )
 
+type Saga interface {
+	Start(ctx context.Context, name string, id uuid.UUID, data any) error
+	HandleReply(ctx context.Context, id uuid.UUID, step string, reply saga.Reply) error
+	Abort(ctx context.Context, id uuid.UUID, failure any) error
+}
+
 type OrderService struct {
 	storage *storage.Storage
+	saga    Saga
+	// timeout is the default time given to order to be finished.
+	timeout time.Duration
 }
 
-func NewOrderService(storage *storage.Storage) *OrderService {
-	return &OrderService{storage}
+func NewOrderService(storage *storage.Storage, saga Saga, timeout time.Duration) *OrderService {
+	return &OrderService{
+		storage: storage,
+		saga:    saga,
+		timeout: timeout,
+	}
 }
 
 func (s *OrderService) GetOrder(ctx context.Context, orderID uuid.UUID) (_ *model.Order, err error) {
@@ -26,18 +45,25 @@ func (s *OrderService) GetOrder(ctx context.Context, orderID uuid.UUID) (_ *mode
 	return repo.Order().Get(ctx, orderID)
 }
 
-func (s *OrderService) ListOrders(ctx context.Context) (_ []model.Order, err error) {
+// ListOrders returns the most recent orders first. Orders are filtered by user if userID is not nil.
+// Zero limit means no limit.
+func (s *OrderService) ListOrders(ctx context.Context, userID uuid.UUID, limit int) (_ []model.Order, err error) {
 	repo, endTx, err := s.storage.Begin(ctx)
 	if err != nil {
 		return nil, err
 	}
 	defer endTx(ctx, &err)
 
-	return repo.Order().List(ctx)
+	return repo.Order().List(ctx, userID, limit)
+}
+
+type OrderLine struct {
+	SKU      string
+	Quantity int64
 }
 
 func (s *OrderService) CreateOrder(
-	ctx context.Context, userID uuid.UUID, amount int64, description string,
+	ctx context.Context, userID uuid.UUID, lines []OrderLine, description string, timeout time.Duration,
 ) (_ *model.Order, err error) {
 	repo, endTx, err := s.storage.Begin(ctx)
 	if err != nil {
@@ -45,47 +71,116 @@ func (s *OrderService) CreateOrder(
 	}
 	defer endTx(ctx, &err)
 
+	if timeout == 0 {
+		timeout = s.timeout
+	}
+	deadline := time.Now().Add(timeout)
+
 	order := &model.Order{
 		ID:          uuid.Must(uuid.NewV7()),
 		UserID:      userID,
 		Description: description,
-		Amount:      amount,
+		Items:       make([]model.OrderItem, 0, len(lines)),
 		Status:      model.StatusNew,
+		Deadline:    &deadline,
+	}
+
+	for _, line := range lines {
+		product, err := repo.Product().Get(ctx, line.SKU)
+		if err != nil {
+			if errs.IsNotFound(err) {
+				return nil, errs.BadRequest("product with sku %s doesn't exist", line.SKU)
+			}
+			return nil, err
+		}
+
+		order.Items = append(order.Items, model.OrderItem{
+			SKU:       product.SKU,
+			Name:      product.Name,
+			Quantity:  line.Quantity,
+			UnitPrice: product.Price,
+		})
+
+		// Prices and quantities aren't negative, so the amount overflows only by exceeding MaxInt64.
+		if product.Price > 0 && line.Quantity > math.MaxInt64/product.Price ||
+			order.Amount > math.MaxInt64-product.Price*line.Quantity {
+			return nil, errs.Unprocessable(errs.FieldError{Field: "items", Message: "order amount is too large"})
+		}
+		order.Amount += product.Price * line.Quantity
 	}
 
 	if err := repo.Order().Create(ctx, order); err != nil {
 		return nil, err
 	}
 
-	err = repo.Outbox().Add(ctx, model.OrderMessage{
-		ID:     order.ID,
+	data := orderSagaData{
 		UserID: order.UserID,
 		Amount: order.Amount,
-	})
-	if err != nil {
+	}
+	for _, item := range order.Items {
+		data.Items = append(data.Items, model.StockItem{
+			SKU:      item.SKU,
+			Quantity: item.Quantity,
+		})
+	}
+
+	if err := s.saga.Start(txcontext.WithTx(ctx, repo.Tx()), OrderSagaName, order.ID, data); err != nil {
 		return nil, err
 	}
 
 	return order, nil
 }
 
-func (s *OrderService) SetOrderStatus(ctx context.Context, id uuid.UUID, status model.OrderStatus) (err error) {
+func (s *OrderService) HandleStockReserved(ctx context.Context, id uuid.UUID, status model.ReservationStatus) error {
+	return s.saga.HandleReply(ctx, id, StepReserveStock, saga.Reply{
+		Success: status == model.ReservationReserved,
+		Failure: model.CancelReason{
+			Code:    model.ReasonOutOfStock,
+			Details: "not enough stock to reserve order items",
+		},
+	})
+}
+
+func (s *OrderService) SetOrderStatus(ctx context.Context, msg *model.OrderServedMessage) (err error) {
+	err = s.saga.HandleReply(ctx, msg.ID, StepChargePayment, saga.Reply{
+		Success: msg.Status == model.StatusFinished,
+		Failure: msg.CancelReason(),
+	})
+	if !errors.Is(err, saga.ErrNotFound) {
+		return err
+	}
+
+	// Orders created before sagas were introduced are paid right from the new status.
 	repo, endTx, err := s.storage.Begin(ctx)
 	if err != nil {
 		return err
 	}
 	defer endTx(ctx, &err)
 
-	order, err := repo.Order().Get(ctx, id)
+	order, err := repo.Order().Get(ctx, msg.ID)
 	if err != nil {
 		return err
 	}
 
-	order.Status = status
+	if order.Status != model.StatusNew {
+		return nil
+	}
 
-	if err = repo.Order().Update(ctx, order); err != nil {
-		return err
+	order.Status = msg.Status
+	order.CancelReason = msg.CancelReason()
+
+	return repo.Order().Update(ctx, order)
+}
+
+// ListEvents returns status changes with IDs greater than afterID. Nil orderID or userID matches any.
+func (s *OrderService) ListEvents(
+	ctx context.Context, orderID, userID uuid.UUID, afterID int64,
+) (_ []model.OrderEvent, err error) {
+	repo, endTx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, err
 	}
+	defer endTx(ctx, &err)
 
-	return nil
+	return repo.Event().List(ctx, orderID, userID, afterID)
 }
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log/slog"
+	"time"
+
+	"github.com/sunnyyssh/designing-software-cw3/order/internal/model"
+	"github.com/sunnyyssh/designing-software-cw3/order/internal/storage"
+	"github.com/sunnyyssh/designing-software-cw3/shared/saga"
+	"github.com/sunnyyssh/designing-software-cw3/shared/txcontext"
+)
+
+// sweeperMetrics are exposed with the rest of expvar variables.
+var sweeperMetrics = expvar.NewMap("order_sweeper")
+
+type SweeperConfig struct {
+	Period time.Duration
+	// StatusGrace is how long payment answer to status request is awaited before order is cancelled.
+	StatusGrace time.Duration
+	// LegacyTimeout is time given to orders created before deadlines were tracked, counted from their creation.
+	LegacyTimeout time.Duration
+	BatchSize     int
+}
+
+// Sweeper handles orders which are not finished before their deadline.
+// If order waits for payment, payment is asked for the outcome first.
+// If there is still no answer, order saga is aborted: order is cancelled,
+// stock is released and payment is voided, so a late charge gets refunded.
+type Sweeper struct {
+	storage *storage.Storage
+	saga    Saga
+	cfg     *SweeperConfig
+	logger  *slog.Logger
+}
+
+func NewSweeper(storage *storage.Storage, saga Saga, cfg *SweeperConfig, logger *slog.Logger) *Sweeper {
+	return &Sweeper{
+		storage: storage,
+		saga:    saga,
+		cfg:     cfg,
+		logger:  logger,
+	}
+}
+
+func (s *Sweeper) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-time.Tick(s.cfg.Period):
+			cnt, err := s.singleRun(ctx)
+			if err != nil {
+				sweeperMetrics.Add("errors", 1)
+				s.logger.ErrorContext(ctx, "sweeping expired orders failed", "error", err)
+				continue
+			}
+
+			if cnt == 0 {
+				s.logger.DebugContext(ctx, "sweeping expired orders", "cnt", cnt)
+			} else {
+				s.logger.InfoContext(ctx, "sweeping expired orders", "cnt", cnt)
+			}
+		}
+	}
+}
+
+func (s *Sweeper) singleRun(ctx context.Context) (_ int, err error) {
+	repo, endTx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer endTx(ctx, &err)
+
+	now := time.Now()
+
+	orders, err := repo.Order().ListExpired(ctx, now, now.Add(-s.cfg.LegacyTimeout), s.cfg.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var requested, timedOut int64
+
+	for _, order := range orders {
+		logger := s.logger.With("order_id", order.ID, "status", order.Status)
+
+		if order.Status == model.StatusReserved && !order.StatusRequested {
+			logger.InfoContext(ctx, "order expired, requesting payment status")
+
+			err := repo.Outbox().AddTo(ctx, model.QueueOrderToPayment, model.OrderMessage{
+				Type: model.PaymentStatusRequest,
+				ID:   order.ID,
+			})
+			if err != nil {
+				return 0, err
+			}
+
+			deadline := now.Add(s.cfg.StatusGrace)
+			order.Deadline = &deadline
+			order.StatusRequested = true
+
+			if err := repo.Order().Update(ctx, &order); err != nil {
+				return 0, err
+			}
+
+			requested++
+			continue
+		}
+
+		logger.InfoContext(ctx, "order timed out, cancelling")
+
+		reason := &model.CancelReason{
+			Code:    model.ReasonTimedOut,
+			Details: "order wasn't finished before its deadline",
+		}
+
+		err := s.saga.Abort(txcontext.WithTx(ctx, repo.Tx()), order.ID, reason)
+		if errors.Is(err, saga.ErrNotFound) {
+			// Order was created before sagas were introduced.
+			err = repo.Outbox().AddTo(ctx, model.QueueOrderToPayment, model.OrderMessage{
+				Type: model.PaymentVoid,
+				ID:   order.ID,
+			})
+			if err != nil {
+				return 0, err
+			}
+
+			order.Status = model.StatusCancelled
+			order.CancelReason = reason
+			err = repo.Order().Update(ctx, &order)
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		timedOut++
+	}
+
+	sweeperMetrics.Add("status_requests", requested)
+	sweeperMetrics.Add("timed_out", timedOut)
+
+	return len(orders), nil
+}
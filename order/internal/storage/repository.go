@@ -4,16 +4,22 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
+	"time"
 
 	"github.com/gofrs/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/sunnyyssh/designing-software-cw3/order/internal/model"
 	"github.com/sunnyyssh/designing-software-cw3/shared/errs"
+	sharedoutbox "github.com/sunnyyssh/designing-software-cw3/shared/outbox"
+	"github.com/sunnyyssh/designing-software-cw3/shared/webhook"
 )
 
 type Repository interface {
+	// Tx returns underlying transaction, e.g. to pass it to other components via txcontext.
+	Tx() pgx.Tx
 	Order() OrderRepository
+	Event() EventRepository
+	Product() ProductRepository
 	Outbox() Outbox
 }
 
@@ -21,17 +27,34 @@ type repository struct {
 	db pgx.Tx
 }
 
+func (r *repository) Tx() pgx.Tx {
+	return r.db
+}
+
 func (r *repository) Order() OrderRepository {
 	return &orderRepository{r.db}
 }
 
+func (r *repository) Event() EventRepository {
+	return &eventRepository{r.db}
+}
+
+func (r *repository) Product() ProductRepository {
+	return &productRepository{r.db}
+}
+
 func (r *repository) Outbox() Outbox {
 	return &outbox{r.db}
 }
 
 type OrderRepository interface {
 	Get(context.Context, uuid.UUID) (*model.Order, error)
-	List(context.Context) ([]model.Order, error)
+	// List returns the most recent orders first. Orders are filtered by user if userID is not nil.
+	// Zero limit means no limit.
+	List(ctx context.Context, userID uuid.UUID, limit int) ([]model.Order, error)
+	// ListExpired returns unfinished orders with passed deadline and locks them. Orders created before deadlines
+	// were tracked have none, they are expired if created before legacyBefore.
+	ListExpired(ctx context.Context, now, legacyBefore time.Time, limit int) ([]model.Order, error)
 	Create(context.Context, *model.Order) error
 	Update(context.Context, *model.Order) error
 }
@@ -41,24 +64,42 @@ type orderRepository struct {
 }
 
 func (r *orderRepository) Get(ctx context.Context, orderID uuid.UUID) (*model.Order, error) {
-	q := `SELECT user_id, description, amount, status FROM orders WHERE id = $1`
-	order := &model.Order{
-		ID: orderID,
-	}
+	q := `SELECT ` + orderColumns + ` FROM orders WHERE id = $1`
 
-	err := r.db.QueryRow(ctx, q, orderID).Scan(&order.UserID, &order.Description, &order.Amount, &order.Status)
+	order, err := scanOrder(r.db.QueryRow(ctx, q, orderID))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, errs.NotFound("order with id %s not found", orderID)
 		}
 		return nil, err
 	}
+
+	items, err := r.items(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	order.Items = items[orderID]
+
 	return order, nil
 }
 
-func (r *orderRepository) List(ctx context.Context) ([]model.Order, error) {
-	q := `SELECT id, user_id, description, amount, status FROM orders`
-	rows, err := r.db.Query(ctx, q)
+func (r *orderRepository) List(ctx context.Context, userID uuid.UUID, limit int) ([]model.Order, error) {
+	q := `SELECT ` + orderColumns + ` FROM orders
+		WHERE ($1 = $2 OR user_id = $1)
+		ORDER BY created_at DESC, id
+		LIMIT NULLIF($3, 0)`
+	return r.list(ctx, q, userID, uuid.Nil, limit)
+}
+
+func (r *orderRepository) ListExpired(ctx context.Context, now, legacyBefore time.Time, limit int) ([]model.Order, error) {
+	q := `SELECT ` + orderColumns + ` FROM orders
+		WHERE status IN ($1, $2) AND (deadline < $3 OR deadline IS NULL AND created_at < $4)
+		ORDER BY COALESCE(deadline, created_at) LIMIT $5 FOR UPDATE SKIP LOCKED`
+	return r.list(ctx, q, model.StatusNew, model.StatusReserved, now, legacyBefore, limit)
+}
+
+func (r *orderRepository) list(ctx context.Context, q string, args ...any) ([]model.Order, error) {
+	rows, err := r.db.Query(ctx, q, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -67,12 +108,63 @@ func (r *orderRepository) List(ctx context.Context) ([]model.Order, error) {
 	res := make([]model.Order, 0)
 
 	for rows.Next() {
-		var order model.Order
-		err := rows.Scan(&order.ID, &order.UserID, &order.Description, &order.Amount, &order.Status)
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *order)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	ids := make([]uuid.UUID, 0, len(res))
+	for _, order := range res {
+		ids = append(ids, order.ID)
+	}
+
+	items, err := r.items(ctx, ids...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range res {
+		res[i].Items = items[res[i].ID]
+	}
+
+	return res, nil
+}
+
+// items returns items of given orders grouped by order ID.
+func (r *orderRepository) items(ctx context.Context, orderIDs ...uuid.UUID) (map[uuid.UUID][]model.OrderItem, error) {
+	res := make(map[uuid.UUID][]model.OrderItem)
+	if len(orderIDs) == 0 {
+		return res, nil
+	}
+
+	ids := make([]string, 0, len(orderIDs))
+	for _, id := range orderIDs {
+		ids = append(ids, id.String())
+	}
+
+	q := `SELECT order_id, sku, name, quantity, unit_price FROM order_items
+		WHERE order_id = ANY($1::uuid[]) ORDER BY order_id, position`
+	rows, err := r.db.Query(ctx, q, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			orderID uuid.UUID
+			item    model.OrderItem
+		)
+		err := rows.Scan(&orderID, &item.SKU, &item.Name, &item.Quantity, &item.UnitPrice)
 		if err != nil {
 			return nil, err
 		}
-		res = append(res, order)
+		res[orderID] = append(res[orderID], item)
 	}
 
 	if err := rows.Err(); err != nil {
@@ -83,33 +175,268 @@ func (r *orderRepository) List(ctx context.Context) ([]model.Order, error) {
 }
 
 func (r *orderRepository) Create(ctx context.Context, order *model.Order) error {
-	q := `INSERT INTO orders (id, user_id, description, amount, status) VALUES ($1, $2, $3, $4, $5)`
-	_, err := r.db.Exec(ctx, q, order.ID, order.UserID, order.Description, order.Amount, order.Status)
-	return err
+	reasonCode, reasonDetails := cancelReasonArgs(order)
+
+	q := `INSERT INTO orders (id, user_id, description, amount, status, deadline, status_requested, cancel_reason, cancel_details)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err := r.db.Exec(ctx, q,
+		order.ID, order.UserID, order.Description, order.Amount, order.Status, order.Deadline, order.StatusRequested,
+		reasonCode, reasonDetails,
+	)
+	if err != nil {
+		return err
+	}
+
+	for i, item := range order.Items {
+		q := `INSERT INTO order_items (order_id, position, sku, name, quantity, unit_price) VALUES ($1, $2, $3, $4, $5, $6)`
+		_, err := r.db.Exec(ctx, q, order.ID, i, item.SKU, item.Name, item.Quantity, item.UnitPrice)
+		if err != nil {
+			return err
+		}
+	}
+
+	return (&eventRepository{r.db}).Add(ctx, order)
 }
 
+// Update saves order. Status change is recorded as an event.
 func (r *orderRepository) Update(ctx context.Context, order *model.Order) error {
-	q := `UPDATE orders SET user_id = $2, description = $3, amount = $4, status = $5 WHERE id = $1 `
-	_, err := r.db.Exec(ctx, q, order.ID, order.UserID, order.Description, order.Amount, order.Status)
-	return err
+	reasonCode, reasonDetails := cancelReasonArgs(order)
+
+	q := `UPDATE orders o SET user_id = $2, description = $3, amount = $4, status = $5, deadline = $6, status_requested = $7,
+		cancel_reason = $8, cancel_details = $9
+		FROM (SELECT id, status FROM orders WHERE id = $1 FOR UPDATE) old
+		WHERE o.id = old.id
+		RETURNING old.status`
+
+	var oldStatus model.OrderStatus
+	err := r.db.QueryRow(ctx, q,
+		order.ID, order.UserID, order.Description, order.Amount, order.Status, order.Deadline, order.StatusRequested,
+		reasonCode, reasonDetails,
+	).Scan(&oldStatus)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return errs.NotFound("order with id %s not found", order.ID)
+		}
+		return err
+	}
+
+	if oldStatus == order.Status {
+		return nil
+	}
+
+	return (&eventRepository{r.db}).Add(ctx, order)
 }
 
-type Outbox interface {
-	Add(context.Context, any) error
+const orderColumns = `id, user_id, description, amount, status, deadline, status_requested, cancel_reason, cancel_details,
+	created_at`
+
+func scanOrder(row pgx.Row) (*model.Order, error) {
+	var (
+		order         model.Order
+		reasonCode    *string
+		reasonDetails *string
+	)
+
+	err := row.Scan(
+		&order.ID, &order.UserID, &order.Description, &order.Amount, &order.Status, &order.Deadline, &order.StatusRequested,
+		&reasonCode, &reasonDetails, &order.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if reasonCode != nil {
+		order.CancelReason = &model.CancelReason{
+			Code: model.CancelReasonCode(*reasonCode),
+		}
+		if reasonDetails != nil {
+			order.CancelReason.Details = *reasonDetails
+		}
+	}
+
+	return &order, nil
 }
 
-type outbox struct {
+func cancelReasonArgs(order *model.Order) (code, details *string) {
+	if order.CancelReason == nil {
+		return nil, nil
+	}
+
+	code = (*string)(&order.CancelReason.Code)
+	details = &order.CancelReason.Details
+	return code, details
+}
+
+// EventsChannel is PostgreSQL channel events are notified to when their transaction is committed.
+const EventsChannel = "order_events"
+
+type EventRepository interface {
+	// Add records current status of order as an event.
+	Add(context.Context, *model.Order) error
+	// List returns events with IDs greater than afterID ordered by ID. IDs grow in commit order, see Add.
+	// Events are filtered by order or user if their IDs are not nil.
+	List(ctx context.Context, orderID, userID uuid.UUID, afterID int64) ([]model.OrderEvent, error)
+}
+
+type eventRepository struct {
 	db pgx.Tx
 }
 
-func (o *outbox) Add(ctx context.Context, msg any) error {
-	data, err := json.Marshal(msg)
+func (r *eventRepository) Add(ctx context.Context, order *model.Order) error {
+	event := &model.OrderEvent{
+		OrderID:      order.ID,
+		UserID:       order.UserID,
+		Status:       order.Status,
+		CancelReason: order.CancelReason,
+	}
+
+	// IDs are taken in commit order, as streams resume after the last ID client got. Without the lock a transaction
+	// could commit lower ID after higher one is already streamed, and the event would be skipped.
+	if _, err := r.db.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, EventsChannel); err != nil {
+		return err
+	}
+
+	q := `INSERT INTO order_events (order_id, user_id, status, cancel_reason) VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`
+	err := r.db.QueryRow(ctx, q, event.OrderID, event.UserID, event.Status, event.CancelReason).
+		Scan(&event.ID, &event.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Exec(ctx, `SELECT pg_notify($1, $2)`, EventsChannel, string(payload))
+	if err != nil {
+		return err
+	}
+
+	if event.Status.Final() {
+		if err := sharedoutbox.Add(ctx, r.db, model.QueueOrderToNotification, event); err != nil {
+			return err
+		}
+	}
+
+	if eventType, ok := event.WebhookEvent(); ok {
+		return webhook.Add(ctx, r.db, event.UserID, eventType, event)
+	}
+	return nil
+}
+
+func (r *eventRepository) List(ctx context.Context, orderID, userID uuid.UUID, afterID int64) ([]model.OrderEvent, error) {
+	q := `SELECT id, order_id, user_id, status, cancel_reason, created_at FROM order_events
+		WHERE id > $1 AND ($2 = $4 OR order_id = $2) AND ($3 = $4 OR user_id = $3)
+		ORDER BY id`
+	rows, err := r.db.Query(ctx, q, afterID, orderID, userID, uuid.Nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]model.OrderEvent, 0)
+
+	for rows.Next() {
+		var event model.OrderEvent
+		err := rows.Scan(&event.ID, &event.OrderID, &event.UserID, &event.Status, &event.CancelReason, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+type ProductRepository interface {
+	Get(ctx context.Context, sku string) (*model.Product, error)
+	List(context.Context) ([]model.Product, error)
+	Upsert(context.Context, *model.Product) error
+	Delete(ctx context.Context, sku string) error
+}
+
+type productRepository struct {
+	db pgx.Tx
+}
+
+func (r *productRepository) Get(ctx context.Context, sku string) (*model.Product, error) {
+	product := &model.Product{
+		SKU: sku,
+	}
+
+	err := r.db.QueryRow(ctx, `SELECT name, price FROM products WHERE sku = $1`, sku).Scan(&product.Name, &product.Price)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errs.NotFound("product with sku %s not found", sku)
+		}
+		return nil, err
+	}
+	return product, nil
+}
+
+func (r *productRepository) List(ctx context.Context) ([]model.Product, error) {
+	rows, err := r.db.Query(ctx, `SELECT sku, name, price FROM products ORDER BY sku`)
 	if err != nil {
-		return fmt.Errorf("failed to marshal message: %w", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]model.Product, 0)
+
+	for rows.Next() {
+		var product model.Product
+		if err := rows.Scan(&product.SKU, &product.Name, &product.Price); err != nil {
+			return nil, err
+		}
+		res = append(res, product)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	if _, err := o.db.Exec(ctx, `INSERT INTO outbox (message) VALUES ($1)`, data); err != nil {
+	return res, nil
+}
+
+func (r *productRepository) Upsert(ctx context.Context, product *model.Product) error {
+	q := `INSERT INTO products (sku, name, price) VALUES ($1, $2, $3)
+		ON CONFLICT (sku) DO UPDATE SET name = EXCLUDED.name, price = EXCLUDED.price`
+	_, err := r.db.Exec(ctx, q, product.SKU, product.Name, product.Price)
+	return err
+}
+
+func (r *productRepository) Delete(ctx context.Context, sku string) error {
+	tag, err := r.db.Exec(ctx, `DELETE FROM products WHERE sku = $1`, sku)
+	if err != nil {
 		return err
 	}
+	if tag.RowsAffected() == 0 {
+		return errs.NotFound("product with sku %s not found", sku)
+	}
 	return nil
 }
+
+type Outbox interface {
+	// Add puts message to the outbox. It will be sent to the default queue.
+	Add(context.Context, any) error
+	// AddTo puts message to the outbox. It will be sent to the destination queue.
+	AddTo(ctx context.Context, destination string, msg any) error
+}
+
+type outbox struct {
+	db pgx.Tx
+}
+
+func (o *outbox) Add(ctx context.Context, msg any) error {
+	return o.AddTo(ctx, "", msg)
+}
+
+func (o *outbox) AddTo(ctx context.Context, destination string, msg any) error {
+	return sharedoutbox.Add(ctx, o.db, destination, msg)
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rabbitmq/amqp091-go"
 	"github.com/sunnyyssh/designing-software-cw3/payment/internal/handlers"
+	"github.com/sunnyyssh/designing-software-cw3/payment/internal/model"
 	"github.com/sunnyyssh/designing-software-cw3/payment/internal/rabbit"
 	"github.com/sunnyyssh/designing-software-cw3/payment/internal/rest"
 	"github.com/sunnyyssh/designing-software-cw3/payment/internal/services"
@@ -19,6 +20,8 @@ import (
 	"github.com/sunnyyssh/designing-software-cw3/shared/httplib"
 	"github.com/sunnyyssh/designing-software-cw3/shared/inbox"
 	"github.com/sunnyyssh/designing-software-cw3/shared/outbox"
+	"github.com/sunnyyssh/designing-software-cw3/shared/requestid"
+	"github.com/sunnyyssh/designing-software-cw3/shared/webhook"
 )
 
 const (
@@ -113,6 +116,18 @@ func run(ctx context.Context, logger *slog.Logger) error {
 		}
 	}()
 
+	_, err = ch.QueueDeclare(
+		model.QueuePaymentToNotification, // name
+		true,                             // durable
+		false,                            // delete when unused
+		false,                            // exclusive
+		false,                            // no-wait
+		nil,                              // arguments
+	)
+	if err != nil {
+		return err
+	}
+
 	qReceive, err := ch.QueueDeclare(
 		QueueOrderToPayment, // name
 		true,                // durable
@@ -155,6 +170,22 @@ func run(ctx context.Context, logger *slog.Logger) error {
 		}
 	}()
 
+	dispatcher := webhook.NewDispatcher(
+		db,
+		webhook.NewSender(webhook.NewClient()),
+		webhookConfig,
+		logger,
+	)
+	go func() {
+		if err := dispatcher.Run(ctx); err != nil {
+			if errors.Is(err, context.Canceled) {
+				logger.Info("webhook dispatcher gracefully stopped")
+			} else {
+				logger.Error("webhook dispatcher failed and stopped", "error", err)
+			}
+		}
+	}()
+
 	handler := rest.NewPaymentHandler(service)
 
 	r.Mount("/account").
@@ -162,14 +193,29 @@ func run(ctx context.Context, logger *slog.Logger) error {
 		PUT("/{id}", handler.CreateAccount).
 		POST("/{id}/amount", handler.ReplenishAccount)
 
+	webhook.NewHandler(webhook.NewStore(db), model.WebhookEvents...).Mount(r.Mount("/webhook"))
+
 	if err := http.ListenAndServe(":8080", r); err != nil {
 		return err
 	}
 	return nil
 }
 
+var webhookConfig = &webhook.Config{
+	Period:       1 * time.Second,
+	BatchSize:    10,
+	Timeout:      10 * time.Second,
+	MaxAttempts:  10,
+	Backoff:      5 * time.Second,
+	MaxBackoff:   1 * time.Hour,
+	DisableAfter: 20,
+}
+
 func main() {
-	logger := slog.Default()
+	// Default logger is replaced, so records logged by httplib carry request ID too.
+	logger := slog.New(requestid.NewHandler(slog.NewTextHandler(os.Stderr, nil)))
+	slog.SetDefault(logger)
+
 	ctx := context.Background()
 
 	if err := run(ctx, logger); err != nil {
@@ -190,4 +236,55 @@ var migrations = []string{
 		id SERIAL,
 		message JSONB NOT NULL
 	)`,
+
+	`ALTER TABLE outbox ADD COLUMN IF NOT EXISTS destination VARCHAR(255) NOT NULL DEFAULT ''`,
+
+	`ALTER TABLE inbox ADD COLUMN IF NOT EXISTS source VARCHAR(255) NOT NULL DEFAULT ''`,
+
+	`CREATE TABLE IF NOT EXISTS payments (
+		order_id UUID PRIMARY KEY,
+		user_id UUID NOT NULL,
+		amount BIGINT NOT NULL,
+		status VARCHAR(32) NOT NULL
+	)`,
+
+	`ALTER TABLE payments ADD COLUMN IF NOT EXISTS reason VARCHAR(64) NOT NULL DEFAULT ''`,
+
+	`ALTER TABLE payments ADD COLUMN IF NOT EXISTS details TEXT NOT NULL DEFAULT ''`,
+
+	`CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+		id UUID PRIMARY KEY,
+		user_id UUID NOT NULL,
+		url TEXT NOT NULL,
+		events TEXT[] NOT NULL,
+		secret VARCHAR(64) NOT NULL,
+		active BOOLEAN NOT NULL DEFAULT true,
+		failures INT NOT NULL DEFAULT 0,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+
+	`CREATE INDEX IF NOT EXISTS webhook_subscriptions_user_id_idx ON webhook_subscriptions (user_id)`,
+
+	`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id BIGSERIAL PRIMARY KEY,
+		subscription_id UUID NOT NULL REFERENCES webhook_subscriptions (id) ON DELETE CASCADE,
+		event_id UUID NOT NULL,
+		event_type VARCHAR(255) NOT NULL,
+		payload JSONB NOT NULL,
+		status VARCHAR(32) NOT NULL,
+		attempts INT NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMPTZ NOT NULL,
+		last_status_code INT,
+		last_error TEXT,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		delivered_at TIMESTAMPTZ
+	)`,
+
+	`CREATE INDEX IF NOT EXISTS webhook_deliveries_status_next_attempt_at_idx ON webhook_deliveries (status, next_attempt_at)`,
+
+	`CREATE INDEX IF NOT EXISTS webhook_deliveries_subscription_id_idx ON webhook_deliveries (subscription_id, id)`,
+
+	`ALTER TABLE outbox ADD COLUMN IF NOT EXISTS request_id VARCHAR(128) NOT NULL DEFAULT ''`,
+
+	`ALTER TABLE inbox ADD COLUMN IF NOT EXISTS request_id VARCHAR(128) NOT NULL DEFAULT ''`,
 }
@@ -3,6 +3,7 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/sunnyyssh/designing-software-cw3/payment/internal/model"
@@ -12,19 +13,32 @@ import (
 
 type PaymentService interface {
 	ServeOrder(context.Context, *model.OrderMessage) error
+	ServeStatusRequest(context.Context, *model.OrderMessage) error
+	VoidPayment(context.Context, *model.OrderMessage) error
 }
 
 func NewInboxHandler(service PaymentService) inbox.HandlerFunc {
-	return func(ctx context.Context, tx pgx.Tx, messages ...json.RawMessage) error {
+	return func(ctx context.Context, tx pgx.Tx, messages ...inbox.Message) error {
 		ctx = txcontext.WithTx(ctx, tx)
 
 		for _, msg := range messages {
 			var orderMsg model.OrderMessage
-			if err := json.Unmarshal([]byte(msg), &orderMsg); err != nil {
+			if err := json.Unmarshal(msg.Message, &orderMsg); err != nil {
 				return err
 			}
 
-			if err := service.ServeOrder(ctx, &orderMsg); err != nil {
+			var err error
+			switch orderMsg.Type {
+			case model.PaymentCharge, "":
+				err = service.ServeOrder(ctx, &orderMsg)
+			case model.PaymentStatusRequest:
+				err = service.ServeStatusRequest(ctx, &orderMsg)
+			case model.PaymentVoid:
+				err = service.VoidPayment(ctx, &orderMsg)
+			default:
+				err = fmt.Errorf("unknown payment command %q of order %s", orderMsg.Type, orderMsg.ID)
+			}
+			if err != nil {
 				return err
 			}
 		}
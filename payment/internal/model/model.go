@@ -8,20 +8,91 @@ const (
 	StatusNew       OrderStatus = "new"
 	StatusFinished  OrderStatus = "finished"
 	StatusCancelled OrderStatus = "cancelled"
+	// StatusVoided is status of payment cancelled by order. Money is refunded if it was charged.
+	StatusVoided OrderStatus = "voided"
 )
 
+// QueuePaymentToNotification receives balance changes to notify users about.
+const QueuePaymentToNotification = "payment_to_notification"
+
 type Account struct {
 	UserID uuid.UUID `json:"user_id"`
 	Amount int64     `json:"amount"`
 }
 
-type OrderMessage struct {
+// Payment is a result of serving order. It's kept to serve resent orders idempotently.
+type Payment struct {
+	OrderID uuid.UUID        `json:"order_id"`
+	UserID  uuid.UUID        `json:"user_id"`
+	Amount  int64            `json:"amount"`
+	Status  OrderStatus      `json:"status"`
+	Reason  CancelReasonCode `json:"reason,omitempty"`
+	Details string           `json:"details,omitempty"`
+}
+
+// Webhook event types sent to subscribers of payment events.
+const (
+	WebhookAccountCredited  = "account.credited"
+	WebhookAccountDebited   = "account.debited"
+	WebhookPaymentCharged   = "payment.charged"
+	WebhookPaymentCancelled = "payment.cancelled"
+	WebhookPaymentRefunded  = "payment.refunded"
+)
+
+var WebhookEvents = []string{
+	WebhookAccountCredited, WebhookAccountDebited,
+	WebhookPaymentCharged, WebhookPaymentCancelled, WebhookPaymentRefunded,
+}
+
+// BalanceMessage tells notification about change of account balance.
+type BalanceMessage struct {
 	ID     uuid.UUID `json:"id"`
 	UserID uuid.UUID `json:"user_id"`
-	Amount int64     `json:"amount"`
+	// OrderID is nil unless balance is changed by order charge or refund.
+	OrderID uuid.UUID `json:"order_id"`
+	// Amount is a change of balance. It's negative when account is debited.
+	Amount  int64 `json:"amount"`
+	Balance int64 `json:"balance"`
+}
+
+// AccountEvent is a change of account balance: top-up, order charge or refund.
+type AccountEvent struct {
+	UserID uuid.UUID `json:"user_id"`
+	// OrderID is nil unless balance is changed by order charge or refund.
+	OrderID uuid.UUID `json:"order_id"`
+	// Amount is a change of balance. It's negative when account is debited.
+	Amount  int64 `json:"amount"`
+	Balance int64 `json:"balance"`
+}
+
+// CancelReasonCode tells order why payment is cancelled.
+type CancelReasonCode string
+
+const (
+	ReasonAccountNotFound   CancelReasonCode = "account_not_found"
+	ReasonInsufficientFunds CancelReasonCode = "insufficient_funds"
+	ReasonPaymentVoided     CancelReasonCode = "payment_voided"
+)
+
+type PaymentCommand string
+
+const (
+	PaymentCharge        PaymentCommand = "charge"
+	PaymentStatusRequest PaymentCommand = "status_request"
+	PaymentVoid          PaymentCommand = "void"
+)
+
+// OrderMessage is a command from order. Messages without type are charges sent by older order versions.
+type OrderMessage struct {
+	Type   PaymentCommand `json:"type,omitempty"`
+	ID     uuid.UUID      `json:"id"`
+	UserID uuid.UUID      `json:"user_id,omitempty"`
+	Amount int64          `json:"amount,omitempty"`
 }
 
 type OrderServedMessage struct {
-	ID     uuid.UUID   `json:"id"`
-	Status OrderStatus `json:"status"`
+	ID      uuid.UUID        `json:"id"`
+	Status  OrderStatus      `json:"status"`
+	Reason  CancelReasonCode `json:"reason,omitempty"`
+	Details string           `json:"details,omitempty"`
 }
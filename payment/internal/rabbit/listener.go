@@ -6,6 +6,8 @@ import (
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rabbitmq/amqp091-go"
+	"github.com/sunnyyssh/designing-software-cw3/shared/inbox"
+	"github.com/sunnyyssh/designing-software-cw3/shared/requestid"
 )
 
 type Listener struct {
@@ -51,8 +53,10 @@ LOOP:
 				break LOOP
 			}
 
-			logger.Info("message received", "timestamp", msg.Timestamp)
-			if err = l.handleMessage(ctx, msg, logger); err != nil {
+			msgCtx := requestid.WithID(ctx, msg.CorrelationId)
+
+			logger.InfoContext(msgCtx, "message received", "timestamp", msg.Timestamp)
+			if err = l.handleMessage(msgCtx, msg, logger); err != nil {
 				return err
 			}
 
@@ -75,8 +79,7 @@ func (l *Listener) handleMessage(ctx context.Context, msg amqp091.Delivery, logg
 		}
 	}()
 
-	_, err = tx.Exec(ctx, `INSERT INTO inbox (message) VALUES ($1)`, msg.Body)
-	if err != nil {
+	if err = inbox.Add(ctx, tx, l.q.Name, msg.Body); err != nil {
 		return err
 	}
 
@@ -84,6 +87,6 @@ func (l *Listener) handleMessage(ctx context.Context, msg amqp091.Delivery, logg
 		return err
 	}
 
-	logger.Info("message appended to inbox table")
+	logger.InfoContext(ctx, "message appended to inbox table")
 	return nil
 }
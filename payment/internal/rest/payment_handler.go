@@ -20,14 +20,23 @@ type PaymentHandler struct {
 	service PaymentService
 }
 
+type replenishRequest struct {
+	Amount int64 `json:"amount" validate:"required,min=1"`
+}
+
 func NewPaymentHandler(service PaymentService) *PaymentHandler {
+	httplib.RegisterBody[replenishRequest]()
+
 	return &PaymentHandler{service}
 }
 
 func (h *PaymentHandler) GetAccount(req *http.Request) (any, error) {
 	ctx := req.Context()
 
-	userID := uuid.Must(uuid.FromString(req.PathValue("id")))
+	userID, err := uuid.FromString(req.PathValue("id"))
+	if err != nil {
+		return nil, errs.BadRequest("id UUID path value must be specified: %s", err)
+	}
 
 	return h.service.GetAccount(ctx, userID)
 }
@@ -35,7 +44,10 @@ func (h *PaymentHandler) GetAccount(req *http.Request) (any, error) {
 func (h *PaymentHandler) CreateAccount(req *http.Request) (any, error) {
 	ctx := req.Context()
 
-	userID := uuid.Must(uuid.FromString(req.PathValue("id")))
+	userID, err := uuid.FromString(req.PathValue("id"))
+	if err != nil {
+		return nil, errs.BadRequest("id UUID path value must be specified: %s", err)
+	}
 
 	return h.service.CreateAccount(ctx, userID)
 }
@@ -43,14 +55,14 @@ func (h *PaymentHandler) CreateAccount(req *http.Request) (any, error) {
 func (h *PaymentHandler) ReplenishAccount(req *http.Request) (any, error) {
 	ctx := req.Context()
 
-	userID := uuid.Must(uuid.FromString(req.PathValue("id")))
-
-	type Request struct {
-		Amount int64 `json:"amount"`
+	userID, err := uuid.FromString(req.PathValue("id"))
+	if err != nil {
+		return nil, errs.BadRequest("id UUID path value must be specified: %s", err)
 	}
-	reqBody, err := httplib.UnmarshalBody[Request](req)
+
+	reqBody, err := httplib.UnmarshalBody[replenishRequest](req)
 	if err != nil {
-		return nil, errs.BadRequest("invalid format of body")
+		return nil, err
 	}
 
 	return h.service.ReplenishAccount(ctx, userID, reqBody.Amount)
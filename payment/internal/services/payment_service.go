@@ -2,6 +2,7 @@ package services
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/gofrs/uuid"
 	"github.com/sunnyyssh/designing-software-cw3/payment/internal/model"
@@ -61,7 +62,7 @@ func (s *PaymentService) ReplenishAccount(ctx context.Context, userID uuid.UUID,
 	}
 	defer endTx(ctx, &err)
 
-	acc, err := repo.Account().GetAccount(ctx, userID)
+	acc, err := repo.Account().GetAccountForUpdate(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -75,33 +76,70 @@ func (s *PaymentService) ReplenishAccount(ctx context.Context, userID uuid.UUID,
 		return nil, err
 	}
 
+	if err := balanceChanged(ctx, repo, acc, uuid.Nil, amount); err != nil {
+		return nil, err
+	}
+
 	return acc, nil
 }
 
-func (s *PaymentService) ServeOrder(ctx context.Context, order *model.OrderMessage) error {
+func (s *PaymentService) ServeOrder(ctx context.Context, order *model.OrderMessage) (err error) {
 	repo, endTx, err := s.storage.Begin(ctx)
 	if err != nil {
 		return err
 	}
 	defer endTx(ctx, &err)
 
-	outboxFunc := func(status model.OrderStatus) error {
-		return repo.Outbox().Add(ctx, model.OrderServedMessage{
-			ID:     order.ID,
-			Status: status,
-		})
+	// Order is resent, so the reply might be lost. It's replied again without charging twice.
+	payment, err := repo.Payment().GetPayment(ctx, order.ID)
+	if err != nil && !errs.IsNotFound(err) {
+		return err
 	}
+	if err == nil {
+		return reply(ctx, repo, payment)
+	}
+
+	payment = &model.Payment{
+		OrderID: order.ID,
+		UserID:  order.UserID,
+		Amount:  order.Amount,
+		Status:  model.StatusFinished,
+	}
+
+	serve := func() error {
+		if err := repo.Payment().CreatePayment(ctx, payment); err != nil {
+			return err
+		}
 
-	acc, err := repo.Account().GetAccount(ctx, order.UserID)
+		eventType := model.WebhookPaymentCharged
+		if payment.Status == model.StatusCancelled {
+			eventType = model.WebhookPaymentCancelled
+		}
+
+		if err := repo.Webhook().Add(ctx, payment.UserID, eventType, payment); err != nil {
+			return err
+		}
+
+		return reply(ctx, repo, payment)
+	}
+
+	cancel := func(reason model.CancelReasonCode, format string, args ...any) error {
+		payment.Status = model.StatusCancelled
+		payment.Reason = reason
+		payment.Details = fmt.Sprintf(format, args...)
+		return serve()
+	}
+
+	acc, err := repo.Account().GetAccountForUpdate(ctx, order.UserID)
 	if err != nil {
 		if errs.IsNotFound(err) {
-			return outboxFunc(model.StatusCancelled)
+			return cancel(model.ReasonAccountNotFound, "user %s has no account", order.UserID)
 		}
 		return err
 	}
 
 	if acc.Amount-order.Amount < 0 {
-		return outboxFunc(model.StatusCancelled)
+		return cancel(model.ReasonInsufficientFunds, "account balance %d is less than order amount %d", acc.Amount, order.Amount)
 	}
 
 	acc.Amount -= order.Amount
@@ -110,5 +148,122 @@ func (s *PaymentService) ServeOrder(ctx context.Context, order *model.OrderMessa
 		return err
 	}
 
-	return outboxFunc(model.StatusFinished)
+	if err := balanceChanged(ctx, repo, acc, order.ID, -order.Amount); err != nil {
+		return err
+	}
+
+	return serve()
+}
+
+// ServeStatusRequest resends result of the charge. Nothing is sent if order wasn't charged yet.
+func (s *PaymentService) ServeStatusRequest(ctx context.Context, order *model.OrderMessage) (err error) {
+	repo, endTx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer endTx(ctx, &err)
+
+	payment, err := repo.Payment().GetPayment(ctx, order.ID)
+	if err != nil {
+		if errs.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	return reply(ctx, repo, payment)
+}
+
+// VoidPayment refunds the charge of cancelled order.
+// If order wasn't charged yet, voided payment is saved so a late charge is rejected.
+func (s *PaymentService) VoidPayment(ctx context.Context, order *model.OrderMessage) (err error) {
+	repo, endTx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer endTx(ctx, &err)
+
+	payment, err := repo.Payment().GetPayment(ctx, order.ID)
+	if err != nil {
+		if errs.IsNotFound(err) {
+			return repo.Payment().CreatePayment(ctx, &model.Payment{
+				OrderID: order.ID,
+				Status:  model.StatusVoided,
+				Reason:  model.ReasonPaymentVoided,
+				Details: "order was cancelled before it was charged",
+			})
+		}
+		return err
+	}
+
+	if payment.Status != model.StatusFinished {
+		return nil
+	}
+
+	acc, err := repo.Account().GetAccountForUpdate(ctx, payment.UserID)
+	if err != nil {
+		return err
+	}
+
+	acc.Amount += payment.Amount
+
+	if err := repo.Account().UpdateAccount(ctx, acc); err != nil {
+		return err
+	}
+
+	if err := balanceChanged(ctx, repo, acc, payment.OrderID, payment.Amount); err != nil {
+		return err
+	}
+
+	payment.Status = model.StatusVoided
+	payment.Reason = model.ReasonPaymentVoided
+	payment.Details = "order was cancelled, charge is refunded"
+
+	if err := repo.Payment().UpdatePayment(ctx, payment); err != nil {
+		return err
+	}
+
+	return repo.Webhook().Add(ctx, payment.UserID, model.WebhookPaymentRefunded, payment)
+}
+
+// balanceChanged tells notification and webhook subscribers that account balance is changed by amount.
+func balanceChanged(ctx context.Context, repo storage.Repository, acc *model.Account, orderID uuid.UUID, amount int64) error {
+	err := repo.Outbox().AddTo(ctx, model.QueuePaymentToNotification, model.BalanceMessage{
+		ID:      uuid.Must(uuid.NewV4()),
+		UserID:  acc.UserID,
+		OrderID: orderID,
+		Amount:  amount,
+		Balance: acc.Amount,
+	})
+	if err != nil {
+		return err
+	}
+
+	eventType := model.WebhookAccountCredited
+	if amount < 0 {
+		eventType = model.WebhookAccountDebited
+	}
+
+	return repo.Webhook().Add(ctx, acc.UserID, eventType, model.AccountEvent{
+		UserID:  acc.UserID,
+		OrderID: orderID,
+		Amount:  amount,
+		Balance: acc.Amount,
+	})
+}
+
+func reply(ctx context.Context, repo storage.Repository, payment *model.Payment) error {
+	msg := model.OrderServedMessage{
+		ID:      payment.OrderID,
+		Status:  payment.Status,
+		Reason:  payment.Reason,
+		Details: payment.Details,
+	}
+
+	// Order knows nothing about voided payments, they are cancelled for it.
+	if msg.Status == model.StatusVoided {
+		msg.Status = model.StatusCancelled
+	}
+
+	return repo.Outbox().Add(ctx, msg)
 }
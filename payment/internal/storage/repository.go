@@ -2,19 +2,21 @@ package storage
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
-	"fmt"
 
 	"github.com/gofrs/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/sunnyyssh/designing-software-cw3/payment/internal/model"
 	"github.com/sunnyyssh/designing-software-cw3/shared/errs"
+	sharedoutbox "github.com/sunnyyssh/designing-software-cw3/shared/outbox"
+	"github.com/sunnyyssh/designing-software-cw3/shared/webhook"
 )
 
 type Repository interface {
 	Account() AccountRepository
+	Payment() PaymentRepository
 	Outbox() Outbox
+	Webhook() Webhook
 }
 
 type repository struct {
@@ -25,12 +27,22 @@ func (r *repository) Account() AccountRepository {
 	return &accountRepository{r.db}
 }
 
+func (r *repository) Payment() PaymentRepository {
+	return &paymentRepository{r.db}
+}
+
 func (r *repository) Outbox() Outbox {
 	return &outbox{r.db}
 }
 
+func (r *repository) Webhook() Webhook {
+	return &webhookQueue{r.db}
+}
+
 type AccountRepository interface {
 	GetAccount(ctx context.Context, userID uuid.UUID) (*model.Account, error)
+	// GetAccountForUpdate locks account row until the end of transaction, so balance changes aren't lost.
+	GetAccountForUpdate(ctx context.Context, userID uuid.UUID) (*model.Account, error)
 	CreateAccount(context.Context, *model.Account) error
 	UpdateAccount(context.Context, *model.Account) error
 }
@@ -40,11 +52,19 @@ type accountRepository struct {
 }
 
 func (r *accountRepository) GetAccount(ctx context.Context, userID uuid.UUID) (*model.Account, error) {
+	return r.getAccount(ctx, `SELECT amount FROM accounts WHERE user_id = $1`, userID)
+}
+
+func (r *accountRepository) GetAccountForUpdate(ctx context.Context, userID uuid.UUID) (*model.Account, error) {
+	return r.getAccount(ctx, `SELECT amount FROM accounts WHERE user_id = $1 FOR UPDATE`, userID)
+}
+
+func (r *accountRepository) getAccount(ctx context.Context, q string, userID uuid.UUID) (*model.Account, error) {
 	account := &model.Account{
 		UserID: userID,
 	}
 
-	row := r.db.QueryRow(ctx, `SELECT amount FROM accounts WHERE user_id = $1`, userID)
+	row := r.db.QueryRow(ctx, q, userID)
 	if err := row.Scan(&account.Amount); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, errs.NotFound("account with user_id %s not found", userID)
@@ -65,8 +85,54 @@ func (r *accountRepository) UpdateAccount(ctx context.Context, account *model.Ac
 	return err
 }
 
+type PaymentRepository interface {
+	GetPayment(ctx context.Context, orderID uuid.UUID) (*model.Payment, error)
+	CreatePayment(context.Context, *model.Payment) error
+	UpdatePayment(context.Context, *model.Payment) error
+}
+
+type paymentRepository struct {
+	db pgx.Tx
+}
+
+func (r *paymentRepository) GetPayment(ctx context.Context, orderID uuid.UUID) (*model.Payment, error) {
+	payment := &model.Payment{
+		OrderID: orderID,
+	}
+
+	q := `SELECT user_id, amount, status, reason, details FROM payments WHERE order_id = $1 FOR UPDATE`
+	row := r.db.QueryRow(ctx, q, orderID)
+	if err := row.Scan(&payment.UserID, &payment.Amount, &payment.Status, &payment.Reason, &payment.Details); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errs.NotFound("payment of order %s not found", orderID)
+		}
+		return nil, err
+	}
+
+	return payment, nil
+}
+
+func (r *paymentRepository) CreatePayment(ctx context.Context, payment *model.Payment) error {
+	q := `INSERT INTO payments (order_id, user_id, amount, status, reason, details) VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := r.db.Exec(ctx, q,
+		payment.OrderID, payment.UserID, payment.Amount, payment.Status, payment.Reason, payment.Details,
+	)
+	return err
+}
+
+func (r *paymentRepository) UpdatePayment(ctx context.Context, payment *model.Payment) error {
+	q := `UPDATE payments SET user_id = $2, amount = $3, status = $4, reason = $5, details = $6 WHERE order_id = $1`
+	_, err := r.db.Exec(ctx, q,
+		payment.OrderID, payment.UserID, payment.Amount, payment.Status, payment.Reason, payment.Details,
+	)
+	return err
+}
+
 type Outbox interface {
+	// Add puts message to the outbox. It will be sent to the default queue.
 	Add(context.Context, any) error
+	// AddTo puts message to the outbox. It will be sent to the destination queue.
+	AddTo(ctx context.Context, destination string, msg any) error
 }
 
 type outbox struct {
@@ -74,13 +140,22 @@ type outbox struct {
 }
 
 func (o *outbox) Add(ctx context.Context, msg any) error {
-	data, err := json.Marshal(msg)
-	if err != nil {
-		return fmt.Errorf("failed to marshal message: %w", err)
-	}
+	return o.AddTo(ctx, "", msg)
+}
 
-	if _, err := o.db.Exec(ctx, `INSERT INTO outbox (message) VALUES ($1)`, data); err != nil {
-		return err
-	}
-	return nil
+func (o *outbox) AddTo(ctx context.Context, destination string, msg any) error {
+	return sharedoutbox.Add(ctx, o.db, destination, msg)
+}
+
+type Webhook interface {
+	// Add enqueues event for webhook subscribers of user.
+	Add(ctx context.Context, userID uuid.UUID, eventType string, data any) error
+}
+
+type webhookQueue struct {
+	db pgx.Tx
+}
+
+func (w *webhookQueue) Add(ctx context.Context, userID uuid.UUID, eventType string, data any) error {
+	return webhook.Add(ctx, w.db, userID, eventType, data)
 }
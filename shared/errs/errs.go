@@ -8,10 +8,16 @@ import (
 type HTTPError struct {
 	Code    int
 	Message string
+	Fields  []FieldError
 }
 
 func (e HTTPError) Error() string { return e.Message }
 
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
 func BadRequest(format string, args ...any) HTTPError {
 	return HTTPError{
 		Code:    400,
@@ -19,6 +25,13 @@ func BadRequest(format string, args ...any) HTTPError {
 	}
 }
 
+func Forbidden(format string, args ...any) HTTPError {
+	return HTTPError{
+		Code:    403,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
 func NotFound(format string, args ...any) HTTPError {
 	return HTTPError{
 		Code:    404,
@@ -26,6 +39,14 @@ func NotFound(format string, args ...any) HTTPError {
 	}
 }
 
+func Unprocessable(fields ...FieldError) HTTPError {
+	return HTTPError{
+		Code:    422,
+		Message: "validation failed",
+		Fields:  fields,
+	}
+}
+
 func IsNotFound(err error) bool {
 	var httpErr HTTPError
 	if errors.As(err, &httpErr) {
@@ -18,21 +18,11 @@ func HandlerJSON(handle HandlerFunc) http.HandlerFunc {
 		res, err := handle(r)
 
 		if err != nil {
-			var httpErr errs.HTTPError
-			if errors.As(err, &httpErr) {
-				w.WriteHeader(httpErr.Code)
-
-				data, _ := json.Marshal(map[string]any{
-					"error": httpErr.Message,
-				})
-				w.Write(data)
-
-				logger.InfoContext(r.Context(), "request served", "code", httpErr.Code, "error", err)
+			code := SendError(w, err)
+			if code == 500 {
+				logger.ErrorContext(r.Context(), "request failed", "code", code, "error", err)
 			} else {
-				w.WriteHeader(500)
-				w.Write([]byte(`{"error": "Internal server error"}`))
-
-				logger.ErrorContext(r.Context(), "request failed", "code", 500, "error", err)
+				logger.InfoContext(r.Context(), "request served", "code", code, "error", err)
 			}
 			return
 		}
@@ -51,3 +41,26 @@ func HandlerJSON(handle HandlerFunc) http.HandlerFunc {
 		logger.InfoContext(r.Context(), "request served", "code", 200)
 	}
 }
+
+// SendError writes error response and returns its code. Errors other than errs.HTTPError are hidden behind 500.
+func SendError(w http.ResponseWriter, err error) int {
+	var httpErr errs.HTTPError
+	if !errors.As(err, &httpErr) {
+		w.WriteHeader(500)
+		w.Write([]byte(`{"error": "Internal server error"}`))
+		return 500
+	}
+
+	w.WriteHeader(httpErr.Code)
+
+	body := map[string]any{
+		"error": httpErr.Message,
+	}
+	if len(httpErr.Fields) > 0 {
+		body["fields"] = httpErr.Fields
+	}
+	data, _ := json.Marshal(body)
+	w.Write(data)
+
+	return httpErr.Code
+}
@@ -3,6 +3,8 @@ package httplib
 import (
 	"fmt"
 	"net/http"
+
+	"github.com/sunnyyssh/designing-software-cw3/shared/requestid"
 )
 
 type (
@@ -56,10 +58,17 @@ func (s *Server) Mount(prefix string) *Server {
 }
 
 func (s *Server) Handle(method, path string, handler HandlerFunc) *Server {
-	f := HandlerJSON(handler)
+	return s.HandleFunc(method, path, HandlerJSON(handler))
+}
+
+// HandleFunc registers plain http handler, e.g. for streaming responses which aren't JSON.
+// Request ID is put to context before any middleware runs.
+func (s *Server) HandleFunc(method, path string, handler http.HandlerFunc) *Server {
+	f := handler
 	for _, m := range s.middlewares {
 		f = m(f)
 	}
+	f = requestid.Middleware(f)
 
 	pattern := fmt.Sprintf("%s %s%s", method, s.pathPrefix, path)
 	s.server.Handle(pattern, f)
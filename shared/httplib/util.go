@@ -1,27 +1,77 @@
 package httplib
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"strings"
+
+	"github.com/sunnyyssh/designing-software-cw3/shared/errs"
 )
 
+// UnmarshalBody strictly decodes JSON body into T and validates it (see Validate).
+// Returned errors are errs.HTTPError: 400 for malformed bodies and 422 for invalid values.
 func UnmarshalBody[T any](req *http.Request) (mock T, _ error) {
 	defer req.Body.Close()
 
+	// Read fails when client hangs up or body exceeds http.MaxBytesReader limit, both are client's faults.
 	data, err := io.ReadAll(req.Body)
 	if err != nil {
-		return mock, err
+		return mock, errs.BadRequest("invalid body: %s", err)
 	}
 
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
 	var res T
-	if err := json.Unmarshal(data, &res); err != nil {
+	if err := dec.Decode(&res); err != nil {
+		return mock, decodeError(err)
+	}
+	if dec.More() {
+		return mock, errs.BadRequest("invalid body: unexpected data after JSON value")
+	}
+
+	if err := Validate(&res); err != nil {
 		return mock, err
 	}
 
 	return res, nil
 }
 
+func decodeError(err error) errs.HTTPError {
+	var (
+		typeErr   *json.UnmarshalTypeError
+		syntaxErr *json.SyntaxError
+	)
+
+	switch {
+	case errors.As(err, &typeErr):
+		httpErr := errs.BadRequest("invalid body")
+		httpErr.Fields = []errs.FieldError{{
+			Field:   typeErr.Field,
+			Message: fmt.Sprintf("must be %s", typeErr.Type),
+		}}
+		return httpErr
+
+	case errors.As(err, &syntaxErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return errs.BadRequest("invalid body: malformed JSON")
+
+	default:
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			httpErr := errs.BadRequest("invalid body")
+			httpErr.Fields = []errs.FieldError{{
+				Field:   strings.Trim(field, `"`),
+				Message: "unknown field",
+			}}
+			return httpErr
+		}
+		return errs.BadRequest("invalid body: %s", err)
+	}
+}
+
 func Send(w http.ResponseWriter, code int, body any) error {
 	w.WriteHeader(code)
 
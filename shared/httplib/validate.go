@@ -0,0 +1,377 @@
+package httplib
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/sunnyyssh/designing-software-cw3/shared/errs"
+)
+
+// Validator may be implemented by request bodies that need checks which
+// can't be expressed with `validate` struct tags. It's called after tag rules.
+type Validator interface {
+	Validate() error
+}
+
+// Validate checks `validate` struct tags of v and then calls its Validate method
+// if v implements Validator. Supported rules are comma-separated:
+//
+//	required  value must not be zero (nil UUID, empty string, 0...), pointers must not be nil
+//	min=N     numbers must be >= N, strings and slices must have length >= N
+//	max=N     numbers must be <= N, strings and slices must have length <= N
+//	oneof=a b value must be one of space-separated options
+//
+// Rules of pointer fields apply to the value pointed to, nil pointers are skipped unless required.
+// All violations are collected and returned as a single 422 errs.HTTPError.
+// Malformed tags are returned as plain error, see RegisterBody to catch them at startup.
+func Validate(v any) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+
+	var fields []errs.FieldError
+	if rv.Kind() == reflect.Struct {
+		var err error
+		if fields, err = validateStruct(rv, ""); err != nil {
+			return err
+		}
+	}
+
+	if validator, ok := asValidator(rv); ok {
+		if err := validator.Validate(); err != nil {
+			var httpErr errs.HTTPError
+			if !errors.As(err, &httpErr) {
+				fields = append(fields, errs.FieldError{Message: err.Error()})
+			} else if len(httpErr.Fields) > 0 {
+				fields = append(fields, httpErr.Fields...)
+			} else {
+				return httpErr
+			}
+		}
+	}
+
+	if len(fields) > 0 {
+		return errs.Unprocessable(fields...)
+	}
+	return nil
+}
+
+func asValidator(rv reflect.Value) (Validator, bool) {
+	if rv.CanAddr() {
+		if validator, ok := rv.Addr().Interface().(Validator); ok {
+			return validator, true
+		}
+	}
+	if rv.CanInterface() {
+		validator, ok := rv.Interface().(Validator)
+		return validator, ok
+	}
+	return nil, false
+}
+
+// RegisterBody checks validate tags of T and panics if they are malformed. Handlers call it for their request
+// bodies when created, so a tag mistake fails at startup instead of when a request is served.
+func RegisterBody[T any]() {
+	if _, err := rulesOf(indirectType(reflect.TypeFor[T]())); err != nil {
+		panic(err)
+	}
+}
+
+type rule struct {
+	name string
+	arg  string
+	// bound is parsed argument of min and max.
+	bound float64
+	// options are parsed argument of oneof.
+	options []string
+}
+
+type fieldRules struct {
+	index int
+	name  string
+	rules []rule
+}
+
+// typeRules are parsed rules of struct fields. Fields without rules are kept as they may hold nested structs.
+type typeRules struct {
+	fields []fieldRules
+}
+
+// rulesCache maps struct types to their *typeRules, so tags are parsed once per type.
+var rulesCache sync.Map
+
+// rulesOf returns rules of struct type t. Struct types nested in its fields are checked too.
+func rulesOf(t reflect.Type) (*typeRules, error) {
+	if cached, ok := rulesCache.Load(t); ok {
+		return cached.(*typeRules), nil
+	}
+
+	pending := make(map[reflect.Type]*typeRules)
+	tr, err := compileRules(t, pending)
+	if err != nil {
+		return nil, err
+	}
+
+	// Types are cached only when all of them are parsed, so others never see incomplete rules.
+	for pt, ptr := range pending {
+		rulesCache.LoadOrStore(pt, ptr)
+	}
+	return tr, nil
+}
+
+// compileRules parses rules of t and nested struct types into pending.
+func compileRules(t reflect.Type, pending map[reflect.Type]*typeRules) (*typeRules, error) {
+	if cached, ok := rulesCache.Load(t); ok {
+		return cached.(*typeRules), nil
+	}
+	// Recursive types refer to rules which are being parsed.
+	if tr, ok := pending[t]; ok {
+		return tr, nil
+	}
+
+	tr := &typeRules{}
+	if t.Kind() != reflect.Struct {
+		return tr, nil
+	}
+	pending[t] = tr
+
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+
+		rules, err := parseRules(indirectType(sf.Type), sf.Tag.Get("validate"))
+		if err == nil {
+			err = compileNested(sf.Type, pending)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", t, sf.Name, err)
+		}
+
+		tr.fields = append(tr.fields, fieldRules{index: i, name: jsonName(sf), rules: rules})
+	}
+
+	return tr, nil
+}
+
+// compileNested parses rules of struct types validated inside field of type t.
+func compileNested(t reflect.Type, pending map[reflect.Type]*typeRules) error {
+	t = indirectType(t)
+	if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = indirectType(t.Elem())
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	_, err := compileRules(t, pending)
+	return err
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
+
+func parseRules(t reflect.Type, tag string) ([]rule, error) {
+	if tag == "" {
+		return nil, nil
+	}
+
+	var rules []rule
+	for _, part := range strings.Split(tag, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+		r := rule{name: name, arg: arg}
+
+		switch name {
+		case "required":
+		case "min", "max":
+			bound, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s validation rule argument %q", name, arg)
+			}
+			if !measurable(t.Kind()) {
+				return nil, fmt.Errorf("%s validation rule is not applicable to %s", name, t.Kind())
+			}
+			r.bound = bound
+		case "oneof":
+			r.options = strings.Fields(arg)
+			if len(r.options) == 0 {
+				return nil, errors.New("oneof validation rule has no options")
+			}
+			if t.Kind() != reflect.String && !isInteger(t.Kind()) {
+				return nil, fmt.Errorf("oneof validation rule is not applicable to %s", t.Kind())
+			}
+		default:
+			return nil, fmt.Errorf("unknown validation rule %q", name)
+		}
+
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func validateStruct(rv reflect.Value, prefix string) ([]errs.FieldError, error) {
+	tr, err := rulesOf(rv.Type())
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []errs.FieldError
+	for _, f := range tr.fields {
+		name := prefix + f.name
+		fv := rv.Field(f.index)
+
+		isPointer := fv.Kind() == reflect.Pointer
+		for fv.Kind() == reflect.Pointer && !fv.IsNil() {
+			fv = fv.Elem()
+		}
+
+		for _, r := range f.rules {
+			if fv.Kind() == reflect.Pointer {
+				// Absent optional value has nothing to check.
+				if r.name == "required" {
+					fields = append(fields, errs.FieldError{Field: name, Message: "is required"})
+				}
+				break
+			}
+			if r.name == "required" && isPointer {
+				continue
+			}
+
+			if msg, ok := checkRule(fv, r); !ok {
+				fields = append(fields, errs.FieldError{Field: name, Message: msg})
+				// Remaining rules are meaningless for a missing value.
+				if r.name == "required" {
+					break
+				}
+			}
+		}
+
+		nested, err := validateNested(fv, name)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, nested...)
+	}
+
+	return fields, nil
+}
+
+func validateNested(fv reflect.Value, name string) ([]errs.FieldError, error) {
+	switch fv.Kind() {
+	case reflect.Struct:
+		return validateStruct(fv, name+".")
+
+	case reflect.Slice, reflect.Array:
+		var fields []errs.FieldError
+		for j := range fv.Len() {
+			elem := fv.Index(j)
+			for elem.Kind() == reflect.Pointer && !elem.IsNil() {
+				elem = elem.Elem()
+			}
+			if elem.Kind() != reflect.Struct {
+				continue
+			}
+
+			nested, err := validateStruct(elem, fmt.Sprintf("%s[%d].", name, j))
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, nested...)
+		}
+		return fields, nil
+
+	default:
+		return nil, nil
+	}
+}
+
+func jsonName(sf reflect.StructField) string {
+	name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return sf.Name
+	}
+	return name
+}
+
+// checkRule checks value against rule parsed by parseRules, so rule is known to be applicable to it.
+func checkRule(v reflect.Value, r rule) (string, bool) {
+	switch r.name {
+	case "required":
+		if v.IsZero() {
+			return "is required", false
+		}
+		return "", true
+
+	case "min", "max":
+		val, isLen := measure(v)
+
+		if r.name == "min" && val < r.bound {
+			if isLen {
+				return fmt.Sprintf("length must be at least %s", r.arg), false
+			}
+			return fmt.Sprintf("must be at least %s", r.arg), false
+		}
+		if r.name == "max" && val > r.bound {
+			if isLen {
+				return fmt.Sprintf("length must be at most %s", r.arg), false
+			}
+			return fmt.Sprintf("must be at most %s", r.arg), false
+		}
+		return "", true
+
+	default: // oneof
+		if slices.Contains(r.options, fmt.Sprint(v.Interface())) {
+			return "", true
+		}
+		return fmt.Sprintf("must be one of: %s", strings.Join(r.options, ", ")), false
+	}
+}
+
+func isInteger(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	default:
+		return false
+	}
+}
+
+func measurable(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Float32, reflect.Float64, reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return true
+	default:
+		return isInteger(kind)
+	}
+}
+
+func measure(v reflect.Value) (val float64, isLen bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), false
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), false
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), false
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), true
+	default:
+		return float64(v.Len()), true
+	}
+}
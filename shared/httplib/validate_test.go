@@ -0,0 +1,119 @@
+package httplib
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/sunnyyssh/designing-software-cw3/shared/errs"
+)
+
+type testItem struct {
+	SKU      string `json:"sku" validate:"required,max=4"`
+	Quantity int64  `json:"quantity" validate:"min=1,max=10"`
+}
+
+type testBody struct {
+	Name   string     `json:"name" validate:"required"`
+	Kind   string     `json:"kind" validate:"oneof=card cash"`
+	Limit  *int64     `json:"limit" validate:"min=1,max=100"`
+	Note   *string    `json:"note" validate:"required,max=3"`
+	Items  []testItem `json:"items" validate:"max=2"`
+	Parent *testItem  `json:"parent"`
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func TestValidate(t *testing.T) {
+	valid := func() testBody {
+		return testBody{Name: "n", Kind: "card", Note: ptr("ok"), Items: []testItem{{SKU: "a", Quantity: 1}}}
+	}
+
+	for _, tt := range []struct {
+		name   string
+		modify func(*testBody)
+		want   []errs.FieldError
+	}{
+		{"valid", func(*testBody) {}, nil},
+		{"required", func(b *testBody) { b.Name = "" }, []errs.FieldError{{Field: "name", Message: "is required"}}},
+		{"oneof", func(b *testBody) { b.Kind = "crypto" }, []errs.FieldError{{Field: "kind", Message: "must be one of: card, cash"}}},
+		{"nil optional pointer", func(b *testBody) { b.Limit = nil }, nil},
+		{"pointer min", func(b *testBody) { b.Limit = ptr[int64](0) }, []errs.FieldError{{Field: "limit", Message: "must be at least 1"}}},
+		{"pointer max", func(b *testBody) { b.Limit = ptr[int64](101) }, []errs.FieldError{{Field: "limit", Message: "must be at most 100"}}},
+		{"nil required pointer", func(b *testBody) { b.Note = nil }, []errs.FieldError{{Field: "note", Message: "is required"}}},
+		{"zero required pointer", func(b *testBody) { b.Note = ptr("") }, nil},
+		{"string max", func(b *testBody) { b.Note = ptr("long") }, []errs.FieldError{{Field: "note", Message: "length must be at most 3"}}},
+		{"slice max", func(b *testBody) { b.Items = make([]testItem, 3) }, []errs.FieldError{
+			{Field: "items", Message: "length must be at most 2"},
+			{Field: "items[0].sku", Message: "is required"},
+			{Field: "items[0].quantity", Message: "must be at least 1"},
+			{Field: "items[1].sku", Message: "is required"},
+			{Field: "items[1].quantity", Message: "must be at least 1"},
+			{Field: "items[2].sku", Message: "is required"},
+			{Field: "items[2].quantity", Message: "must be at least 1"},
+		}},
+		{"nested pointer", func(b *testBody) { b.Parent = &testItem{SKU: "abcde", Quantity: 11} }, []errs.FieldError{
+			{Field: "parent.sku", Message: "length must be at most 4"},
+			{Field: "parent.quantity", Message: "must be at most 10"},
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			body := valid()
+			tt.modify(&body)
+
+			err := Validate(&body)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("err = %v, want nil", err)
+				}
+				return
+			}
+
+			var httpErr errs.HTTPError
+			if !errors.As(err, &httpErr) || httpErr.Code != 422 {
+				t.Fatalf("err = %v, want 422", err)
+			}
+			if !reflect.DeepEqual(httpErr.Fields, tt.want) {
+				t.Errorf("fields = %v, want %v", httpErr.Fields, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterBodyRejectsMalformedTags(t *testing.T) {
+	type typo struct {
+		Name string `validate:"requird"`
+	}
+	type badArg struct {
+		Count int `validate:"min=one"`
+	}
+	type notMeasurable struct {
+		Flag bool `validate:"max=1"`
+	}
+	type nested struct {
+		Items []typo
+	}
+
+	for name, register := range map[string]func(){
+		"unknown rule":   RegisterBody[typo],
+		"bad argument":   RegisterBody[badArg],
+		"not measurable": RegisterBody[notMeasurable],
+		"nested":         RegisterBody[*nested],
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("RegisterBody didn't panic")
+				}
+			}()
+			register()
+		})
+	}
+
+	// Unregistered malformed type fails the request instead of panicking.
+	if err := Validate(&notMeasurable{}); err == nil {
+		t.Error("Validate of malformed type: err = nil")
+	}
+
+	RegisterBody[testBody]()
+}
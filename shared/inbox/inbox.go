@@ -11,13 +11,29 @@ import (
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sunnyyssh/designing-software-cw3/shared/requestid"
 )
 
-type HandlerFunc func(context.Context, pgx.Tx, ...json.RawMessage) error
+// HandlerFunc handles messages within tx. Context carries request ID of the messages.
+type HandlerFunc func(context.Context, pgx.Tx, ...Message) error
 
 type Message struct {
-	ID      int
+	ID int
+	// Source is the name of queue message was received from.
+	Source  string
 	Message json.RawMessage
+	// RequestID is ID of the request message was sent within. It's empty if there was none.
+	RequestID string
+}
+
+// Add puts message received from source queue to the inbox within tx. It will be handled by Worker.
+// Request ID from ctx is stored with message.
+func Add(ctx context.Context, tx pgx.Tx, source string, body []byte) error {
+	requestID, _ := requestid.FromContext(ctx)
+
+	q := `INSERT INTO inbox (source, message, request_id) VALUES ($1, $2, $3)`
+	_, err := tx.Exec(ctx, q, source, body, requestID)
+	return err
 }
 
 type Config struct {
@@ -83,7 +99,7 @@ func (w *Worker) singleRun(ctx context.Context) (cnt int, err error) {
 		return tx.Commit(ctx)
 	}()
 
-	rows, err := tx.Query(ctx, `SELECT id, message FROM inbox LIMIT $1`, w.cfg.BatchSize)
+	rows, err := tx.Query(ctx, `SELECT id, source, message, request_id FROM inbox ORDER BY id LIMIT $1`, w.cfg.BatchSize)
 	if err != nil {
 		return 0, err
 	}
@@ -93,7 +109,7 @@ func (w *Worker) singleRun(ctx context.Context) (cnt int, err error) {
 
 	for rows.Next() {
 		var msg Message
-		if err := rows.Scan(&msg.ID, &msg.Message); err != nil {
+		if err := rows.Scan(&msg.ID, &msg.Source, &msg.Message, &msg.RequestID); err != nil {
 			return 0, err
 		}
 		messages = append(messages, msg)
@@ -107,13 +123,11 @@ func (w *Worker) singleRun(ctx context.Context) (cnt int, err error) {
 		return 0, nil
 	}
 
-	events := make([]json.RawMessage, 0, len(messages))
+	// Messages are handled one by one, so each of them is handled with its own request ID.
 	for _, msg := range messages {
-		events = append(events, msg.Message)
-	}
-
-	if err := w.handler(ctx, tx, events...); err != nil {
-		return 0, err
+		if err := w.handler(requestid.WithID(ctx, msg.RequestID), tx, msg); err != nil {
+			return 0, err
+		}
 	}
 
 	ids := make([]int, 0, len(messages))
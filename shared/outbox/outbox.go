@@ -9,16 +9,41 @@ import (
 	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sunnyyssh/designing-software-cw3/shared/requestid"
 )
 
 type Message struct {
-	ID      int
-	Message json.RawMessage
+	ID          int
+	Destination string
+	Message     json.RawMessage
+	// RequestID is ID of the request message was added within. It's empty if there was none.
+	RequestID string
 }
 
+// EventPublisher sends messages to destination queue.
+// Empty destination means publisher's default queue.
+// Request ID from context should be sent along with messages.
 type EventPublisher interface {
-	Publish(context.Context, ...any) error
+	Publish(ctx context.Context, destination string, msgs ...any) error
+}
+
+// Add puts message to the outbox within tx. It will be published to destination queue by Worker.
+// Request ID from ctx is stored with message.
+func Add(ctx context.Context, tx pgx.Tx, destination string, msg any) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	requestID, _ := requestid.FromContext(ctx)
+
+	q := `INSERT INTO outbox (destination, message, request_id) VALUES ($1, $2, $3)`
+	if _, err := tx.Exec(ctx, q, destination, data, requestID); err != nil {
+		return err
+	}
+	return nil
 }
 
 type Config struct {
@@ -79,7 +104,7 @@ func (w *Worker) singleRun(ctx context.Context) (cnt int, err error) {
 		return tx.Commit(ctx)
 	}()
 
-	rows, err := tx.Query(ctx, `SELECT id, message FROM outbox LIMIT $1`, w.cfg.BatchSize)
+	rows, err := tx.Query(ctx, `SELECT id, destination, message, request_id FROM outbox ORDER BY id LIMIT $1`, w.cfg.BatchSize)
 	if err != nil {
 		return 0, err
 	}
@@ -89,7 +114,7 @@ func (w *Worker) singleRun(ctx context.Context) (cnt int, err error) {
 
 	for rows.Next() {
 		var msg Message
-		if err := rows.Scan(&msg.ID, &msg.Message); err != nil {
+		if err := rows.Scan(&msg.ID, &msg.Destination, &msg.Message, &msg.RequestID); err != nil {
 			return 0, err
 		}
 
@@ -104,13 +129,11 @@ func (w *Worker) singleRun(ctx context.Context) (cnt int, err error) {
 		return 0, nil
 	}
 
-	events := make([]any, 0, len(messages))
+	// Messages are published one by one to keep their order across destinations.
 	for _, msg := range messages {
-		events = append(events, msg.Message)
-	}
-
-	if err := w.publisher.Publish(ctx, events...); err != nil {
-		return 0, err
+		if err := w.publisher.Publish(requestid.WithID(ctx, msg.RequestID), msg.Destination, msg.Message); err != nil {
+			return 0, err
+		}
 	}
 
 	ids := make([]int, 0, len(messages))
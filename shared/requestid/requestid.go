@@ -0,0 +1,90 @@
+// Package requestid carries ID of the request which caused the work through context, logs and messages,
+// so handling of a single client request may be followed across services.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+)
+
+const Header = "X-Request-ID"
+
+// maxLength bounds IDs accepted from clients.
+const maxLength = 128
+
+type ctxKey int
+
+func WithID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, ctxKey(0), id)
+}
+
+func FromContext(ctx context.Context) (string, bool) {
+	val := ctx.Value(ctxKey(0))
+	if val == nil {
+		return "", false
+	}
+	return val.(string), true
+}
+
+func New() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// Valid tells whether id may be accepted from client: it's short and consists of visible ASCII characters.
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// Middleware puts ID from request header to context or generates one if it's missing or invalid.
+// ID is sent back in response header.
+func Middleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if !Valid(id) {
+			id = New()
+		}
+
+		w.Header().Set(Header, id)
+		next(w, r.WithContext(WithID(r.Context(), id)))
+	}
+}
+
+// Handler adds request_id attribute to records logged with context carrying ID.
+type Handler struct {
+	slog.Handler
+}
+
+func NewHandler(h slog.Handler) *Handler {
+	return &Handler{Handler: h}
+}
+
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	if id, ok := FromContext(ctx); ok {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &Handler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,415 @@
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sunnyyssh/designing-software-cw3/shared/outbox"
+	"github.com/sunnyyssh/designing-software-cw3/shared/requestid"
+	"github.com/sunnyyssh/designing-software-cw3/shared/txcontext"
+)
+
+// Command is a message sent to saga participant through the outbox.
+type Command struct {
+	Destination string
+	Message     any
+}
+
+// StepFunc builds command of a step from saga data.
+type StepFunc func(ctx context.Context, id uuid.UUID, data json.RawMessage) (*Command, error)
+
+// HookFunc is called within the saga transaction, so it may use txcontext.FromContext.
+type HookFunc func(ctx context.Context, inst *Instance) error
+
+type Step struct {
+	Name string
+	// Action sends command to participant. Step waits for reply passed to Orchestrator.HandleReply.
+	// Action may be resent on recovery, so participants must handle it idempotently.
+	Action StepFunc
+	// Compensation undoes successful step when one of the next steps fails
+	// or the step itself when saga is aborted. May be nil.
+	// Compensation commands are sent once and no reply is awaited, so they must be idempotent
+	// and handle the case when the action was never received.
+	Compensation StepFunc
+	// OnSuccess is called when step is replied successfully. May be nil.
+	OnSuccess HookFunc
+}
+
+type Definition struct {
+	Name  string
+	Steps []Step
+	// OnCompleted is called when all steps are done. May be nil.
+	OnCompleted HookFunc
+	// OnFailed is called after failed saga is compensated. May be nil.
+	OnFailed HookFunc
+}
+
+type Status string
+
+const (
+	StatusRunning     Status = "running"
+	StatusCompleted   Status = "completed"
+	StatusCompensated Status = "compensated"
+)
+
+type Instance struct {
+	ID     uuid.UUID
+	Name   string
+	Step   int
+	Status Status
+	Data   json.RawMessage
+	// Failure describes why saga failed. It's null unless saga is compensated.
+	Failure   json.RawMessage
+	Attempts  int
+	UpdatedAt time.Time
+	// RequestID is ID of the request saga was started within. Commands of saga are sent with it.
+	RequestID string
+}
+
+type Reply struct {
+	Success bool
+	// Failure describes why step failed. It's marshalled to Instance.Failure.
+	Failure any
+}
+
+var ErrNotFound = errors.New("saga not found")
+
+type Config struct {
+	// Period of checking for stuck sagas.
+	Period time.Duration
+	// RetryAfter is how long saga waits for a reply before current step action is resent.
+	RetryAfter time.Duration
+	BatchSize  int
+}
+
+type Orchestrator struct {
+	db     *pgxpool.Pool
+	defs   map[string]*Definition
+	cfg    *Config
+	logger *slog.Logger
+}
+
+func NewOrchestrator(db *pgxpool.Pool, cfg *Config, logger *slog.Logger, defs ...*Definition) *Orchestrator {
+	o := &Orchestrator{
+		db:     db,
+		defs:   make(map[string]*Definition, len(defs)),
+		cfg:    cfg,
+		logger: logger,
+	}
+
+	for _, def := range defs {
+		if len(def.Steps) == 0 {
+			panic(fmt.Errorf("saga %s has no steps", def.Name))
+		}
+		o.defs[def.Name] = def
+	}
+
+	return o
+}
+
+// Start creates saga instance with given data and sends the first step command.
+func (o *Orchestrator) Start(ctx context.Context, name string, id uuid.UUID, data any) error {
+	def, ok := o.defs[name]
+	if !ok {
+		return fmt.Errorf("saga %s is not defined", name)
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal saga data: %w", err)
+	}
+
+	return o.inTx(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		requestID, _ := requestid.FromContext(ctx)
+
+		inst := &Instance{
+			ID:        id,
+			Name:      name,
+			Step:      0,
+			Status:    StatusRunning,
+			Data:      raw,
+			RequestID: requestID,
+		}
+
+		q := `INSERT INTO sagas (id, name, step, status, data, attempts, updated_at, request_id)
+			VALUES ($1, $2, $3, $4, $5, 0, now(), $6)`
+		if _, err := tx.Exec(ctx, q, inst.ID, inst.Name, inst.Step, inst.Status, inst.Data, inst.RequestID); err != nil {
+			return err
+		}
+
+		return o.send(ctx, tx, inst, def.Steps[0].Action)
+	})
+}
+
+// HandleReply moves saga forward if step succeeded or compensates it otherwise.
+// Replies to steps other than the current one are considered duplicates and ignored.
+func (o *Orchestrator) HandleReply(ctx context.Context, id uuid.UUID, step string, reply Reply) error {
+	return o.inTx(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		inst, err := o.get(ctx, tx, id, true)
+		if err != nil {
+			return err
+		}
+
+		def, ok := o.defs[inst.Name]
+		if !ok {
+			return fmt.Errorf("saga %s is not defined", inst.Name)
+		}
+
+		logger := o.logger.With("saga", inst.Name, "saga_id", inst.ID, "step", step)
+
+		if inst.Status != StatusRunning || def.Steps[inst.Step].Name != step {
+			logger.WarnContext(ctx, "unexpected saga reply ignored", "status", inst.Status, "success", reply.Success)
+			return nil
+		}
+
+		if !reply.Success {
+			logger.InfoContext(ctx, "saga step failed, compensating")
+			return o.compensate(ctx, tx, def, inst, reply.Failure)
+		}
+
+		if hook := def.Steps[inst.Step].OnSuccess; hook != nil {
+			if err := hook(ctx, inst); err != nil {
+				return err
+			}
+		}
+
+		inst.Step++
+		inst.Attempts = 0
+
+		if inst.Step == len(def.Steps) {
+			logger.InfoContext(ctx, "saga completed")
+
+			inst.Status = StatusCompleted
+			if err := o.update(ctx, tx, inst); err != nil {
+				return err
+			}
+
+			if def.OnCompleted != nil {
+				return def.OnCompleted(ctx, inst)
+			}
+			return nil
+		}
+
+		if err := o.update(ctx, tx, inst); err != nil {
+			return err
+		}
+
+		return o.send(ctx, tx, inst, def.Steps[inst.Step].Action)
+	})
+}
+
+// Abort compensates running saga including its current step, because outcome of the step is unknown,
+// e.g. when reply didn't come in time. It does nothing with finished sagas.
+func (o *Orchestrator) Abort(ctx context.Context, id uuid.UUID, failure any) error {
+	return o.inTx(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		inst, err := o.get(ctx, tx, id, true)
+		if err != nil {
+			return err
+		}
+
+		def, ok := o.defs[inst.Name]
+		if !ok {
+			return fmt.Errorf("saga %s is not defined", inst.Name)
+		}
+
+		if inst.Status != StatusRunning {
+			return nil
+		}
+
+		o.logger.InfoContext(ctx, "saga aborted, compensating",
+			"saga", inst.Name, "saga_id", inst.ID, "step", def.Steps[inst.Step].Name)
+
+		if err := o.send(ctx, tx, inst, def.Steps[inst.Step].Compensation); err != nil {
+			return err
+		}
+
+		return o.compensate(ctx, tx, def, inst, failure)
+	})
+}
+
+func (o *Orchestrator) Get(ctx context.Context, id uuid.UUID) (inst *Instance, err error) {
+	err = o.inTx(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		inst, err = o.get(ctx, tx, id, false)
+		return err
+	})
+	return inst, err
+}
+
+// Run resends commands of sagas which wait for a reply longer than Config.RetryAfter.
+// It resumes sagas which got stuck because of lost messages or crashed participants.
+func (o *Orchestrator) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-time.Tick(o.cfg.Period):
+			cnt, err := o.singleRun(ctx)
+			if err != nil {
+				o.logger.ErrorContext(ctx, "resuming sagas failed", "error", err)
+				continue
+			}
+
+			if cnt == 0 {
+				o.logger.DebugContext(ctx, "resuming sagas", "cnt", cnt)
+			} else {
+				o.logger.InfoContext(ctx, "resuming sagas", "cnt", cnt)
+			}
+		}
+	}
+}
+
+func (o *Orchestrator) singleRun(ctx context.Context) (cnt int, err error) {
+	err = o.inTx(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		q := `SELECT id, name, step, status, data, failure, attempts, updated_at, request_id FROM sagas
+			WHERE status = $1 AND updated_at < $2
+			ORDER BY updated_at LIMIT $3 FOR UPDATE SKIP LOCKED`
+		rows, err := tx.Query(ctx, q, StatusRunning, time.Now().Add(-o.cfg.RetryAfter), o.cfg.BatchSize)
+		if err != nil {
+			return err
+		}
+
+		insts, err := pgx.CollectRows(rows, scanInstance)
+		if err != nil {
+			return err
+		}
+
+		for _, inst := range insts {
+			ctx := requestid.WithID(ctx, inst.RequestID)
+
+			def, ok := o.defs[inst.Name]
+			if !ok {
+				o.logger.WarnContext(ctx, "unknown saga skipped", "saga", inst.Name, "saga_id", inst.ID)
+				continue
+			}
+
+			o.logger.InfoContext(ctx, "resending saga step",
+				"saga", inst.Name, "saga_id", inst.ID, "step", def.Steps[inst.Step].Name, "attempts", inst.Attempts)
+
+			inst.Attempts++
+			if err := o.update(ctx, tx, inst); err != nil {
+				return err
+			}
+
+			if err := o.send(ctx, tx, inst, def.Steps[inst.Step].Action); err != nil {
+				return err
+			}
+		}
+
+		cnt = len(insts)
+		return nil
+	})
+	return cnt, err
+}
+
+func (o *Orchestrator) compensate(ctx context.Context, tx pgx.Tx, def *Definition, inst *Instance, failure any) error {
+	raw, err := json.Marshal(failure)
+	if err != nil {
+		return fmt.Errorf("failed to marshal saga failure: %w", err)
+	}
+	inst.Failure = raw
+
+	// Current step failed, so only previous ones are undone, in reverse order.
+	for i := inst.Step - 1; i >= 0; i-- {
+		if err := o.send(ctx, tx, inst, def.Steps[i].Compensation); err != nil {
+			return err
+		}
+	}
+
+	inst.Status = StatusCompensated
+	if err := o.update(ctx, tx, inst); err != nil {
+		return err
+	}
+
+	if def.OnFailed != nil {
+		return def.OnFailed(ctx, inst)
+	}
+	return nil
+}
+
+func (o *Orchestrator) send(ctx context.Context, tx pgx.Tx, inst *Instance, f StepFunc) error {
+	if f == nil {
+		return nil
+	}
+
+	cmd, err := f(ctx, inst.ID, inst.Data)
+	if err != nil {
+		return err
+	}
+	if cmd == nil {
+		return nil
+	}
+
+	return outbox.Add(requestid.WithID(ctx, inst.RequestID), tx, cmd.Destination, cmd.Message)
+}
+
+func (o *Orchestrator) get(ctx context.Context, tx pgx.Tx, id uuid.UUID, forUpdate bool) (*Instance, error) {
+	q := `SELECT id, name, step, status, data, failure, attempts, updated_at, request_id FROM sagas WHERE id = $1`
+	if forUpdate {
+		q += ` FOR UPDATE`
+	}
+
+	rows, err := tx.Query(ctx, q, id)
+	if err != nil {
+		return nil, err
+	}
+
+	inst, err := pgx.CollectOneRow(rows, scanInstance)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+		}
+		return nil, err
+	}
+
+	return inst, nil
+}
+
+func (o *Orchestrator) update(ctx context.Context, tx pgx.Tx, inst *Instance) error {
+	q := `UPDATE sagas SET step = $2, status = $3, failure = $4, attempts = $5, updated_at = now() WHERE id = $1`
+	_, err := tx.Exec(ctx, q, inst.ID, inst.Step, inst.Status, inst.Failure, inst.Attempts)
+	return err
+}
+
+func scanInstance(row pgx.CollectableRow) (*Instance, error) {
+	var inst Instance
+	err := row.Scan(
+		&inst.ID, &inst.Name, &inst.Step, &inst.Status, &inst.Data, &inst.Failure, &inst.Attempts, &inst.UpdatedAt,
+		&inst.RequestID,
+	)
+	return &inst, err
+}
+
+// inTx runs f within transaction from context or within a new one.
+func (o *Orchestrator) inTx(ctx context.Context, f func(context.Context, pgx.Tx) error) (err error) {
+	if tx, ok := txcontext.FromContext(ctx); ok {
+		return f(ctx, tx)
+	}
+
+	tx, err := o.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback(ctx)
+			panic(p)
+		}
+		if err != nil {
+			tx.Rollback(ctx)
+		}
+	}()
+
+	if err = f(txcontext.WithTx(ctx, tx), tx); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
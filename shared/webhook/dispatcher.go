@@ -0,0 +1,277 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Config struct {
+	Period    time.Duration
+	BatchSize int
+	// Timeout of single delivery request.
+	Timeout time.Duration
+	// MaxAttempts is how many times delivery is tried before it's marked failed.
+	MaxAttempts int
+	// Backoff is a delay after the first failed attempt. It doubles with every next one up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// DisableAfter is a number of failed attempts in a row after which subscription is disabled.
+	DisableAfter int
+}
+
+// Sender posts signed events to subscription endpoints.
+type Sender struct {
+	client *http.Client
+}
+
+func NewSender(client *http.Client) *Sender {
+	return &Sender{client}
+}
+
+// Send posts delivery payload to subscription URL. Any response but 2xx is an error.
+// Status code is returned whenever receiver answered.
+func (s *Sender) Send(ctx context.Context, sub *Subscription, delivery *Delivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderID, delivery.EventID.String())
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(HeaderSignature, Sign(sub.Secret, now, delivery.Payload))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded with %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// Dispatcher sends pending deliveries and retries failed ones with exponential backoff.
+type Dispatcher struct {
+	db     *pgxpool.Pool
+	sender *Sender
+	cfg    *Config
+	logger *slog.Logger
+}
+
+func NewDispatcher(db *pgxpool.Pool, sender *Sender, cfg *Config, logger *slog.Logger) *Dispatcher {
+	return &Dispatcher{
+		db:     db,
+		sender: sender,
+		cfg:    cfg,
+		logger: logger,
+	}
+}
+
+func (d *Dispatcher) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-time.Tick(d.cfg.Period):
+			cnt, err := d.singleRun(ctx)
+			if err != nil {
+				d.logger.ErrorContext(ctx, "dispatching webhooks failed", "error", err)
+				continue
+			}
+
+			if cnt == 0 {
+				d.logger.DebugContext(ctx, "dispatching webhooks", "cnt", cnt)
+			} else {
+				d.logger.InfoContext(ctx, "dispatching webhooks", "cnt", cnt)
+			}
+		}
+	}
+}
+
+// singleRun claims a batch of due deliveries and sends them. Claim, sending and recording of every result are
+// separate, so no transaction or row lock is held while endpoints answer.
+func (d *Dispatcher) singleRun(ctx context.Context) (int, error) {
+	jobs, err := d.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// Subscription may have several deliveries in the batch, its state is shared between them.
+	subs := make(map[uuid.UUID]*Subscription)
+
+	for i := range jobs {
+		del := &jobs[i].delivery
+		sub, ok := subs[jobs[i].sub.ID]
+		if !ok {
+			sub = &jobs[i].sub
+			subs[sub.ID] = sub
+		}
+
+		if err := d.deliver(ctx, sub, del); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(jobs), nil
+}
+
+type job struct {
+	delivery Delivery
+	sub      Subscription
+}
+
+// claim selects due deliveries and postpones them by a lease, so other dispatchers skip them while they are sent.
+// Deliveries of a dispatcher which stopped in the middle are sent again when the lease is over.
+func (d *Dispatcher) claim(ctx context.Context) (jobs []job, err error) {
+	// The batch is sent one by one, so the lease covers all of it.
+	lease := d.cfg.Timeout * time.Duration(d.cfg.BatchSize+1)
+
+	err = pgx.BeginFunc(ctx, d.db, func(tx pgx.Tx) error {
+		q := `SELECT d.id, d.subscription_id, d.event_id, d.event_type, d.payload, d.status, d.attempts,
+				d.next_attempt_at, d.last_status_code, d.last_error, d.created_at, d.delivered_at,
+				s.id, s.user_id, s.url, s.events, s.secret, s.active, s.failures, s.created_at
+			FROM webhook_deliveries d JOIN webhook_subscriptions s ON s.id = d.subscription_id
+			WHERE d.status = $1 AND d.next_attempt_at <= now()
+			ORDER BY d.next_attempt_at LIMIT $2
+			FOR UPDATE OF d SKIP LOCKED`
+		rows, err := tx.Query(ctx, q, DeliveryPending, d.cfg.BatchSize)
+		if err != nil {
+			return err
+		}
+
+		jobs, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (job, error) {
+			var (
+				j   job
+				del = &j.delivery
+				sub = &j.sub
+			)
+			err := row.Scan(
+				&del.ID, &del.SubscriptionID, &del.EventID, &del.EventType, &del.Payload, &del.Status, &del.Attempts,
+				&del.NextAttemptAt, &del.LastStatusCode, &del.LastError, &del.CreatedAt, &del.DeliveredAt,
+				&sub.ID, &sub.UserID, &sub.URL, &sub.Events, &sub.Secret, &sub.Active, &sub.Failures, &sub.CreatedAt,
+			)
+			return j, err
+		})
+		if err != nil || len(jobs) == 0 {
+			return err
+		}
+
+		ids := make([]int64, 0, len(jobs))
+		for _, j := range jobs {
+			ids = append(ids, j.delivery.ID)
+		}
+
+		q = `UPDATE webhook_deliveries SET next_attempt_at = now() + make_interval(secs => $2) WHERE id = ANY($1)`
+		_, err = tx.Exec(ctx, q, ids, lease.Seconds())
+		return err
+	})
+	return jobs, err
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, sub *Subscription, del *Delivery) error {
+	logger := d.logger.With("subscription_id", sub.ID, "delivery_id", del.ID, "event_type", del.EventType)
+
+	now := time.Now()
+
+	if !sub.Active {
+		del.Status = DeliveryFailed
+		del.LastError = ptr("subscription is disabled")
+		return pgx.BeginFunc(ctx, d.db, func(tx pgx.Tx) error {
+			return updateDelivery(ctx, tx, del)
+		})
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, d.cfg.Timeout)
+	code, err := d.sender.Send(sendCtx, sub, del)
+	cancel()
+
+	del.Attempts++
+	del.LastStatusCode = nil
+	if code != 0 {
+		del.LastStatusCode = &code
+	}
+
+	if err == nil {
+		del.Status = DeliveryDelivered
+		del.LastError = nil
+		del.DeliveredAt = &now
+
+		return pgx.BeginFunc(ctx, d.db, func(tx pgx.Tx) error {
+			if err := updateDelivery(ctx, tx, del); err != nil {
+				return err
+			}
+
+			q := `UPDATE webhook_subscriptions SET failures = 0 WHERE id = $1 RETURNING active, failures`
+			return tx.QueryRow(ctx, q, sub.ID).Scan(&sub.Active, &sub.Failures)
+		})
+	}
+
+	logger.WarnContext(ctx, "webhook delivery attempt failed", "attempt", del.Attempts, "error", err)
+
+	del.LastError = ptr(err.Error())
+	del.NextAttemptAt = now.Add(d.backoff(del.Attempts))
+	if del.Attempts >= d.cfg.MaxAttempts {
+		del.Status = DeliveryFailed
+	}
+
+	wasActive := sub.Active
+	err = pgx.BeginFunc(ctx, d.db, func(tx pgx.Tx) error {
+		if err := updateDelivery(ctx, tx, del); err != nil {
+			return err
+		}
+
+		// Failures are counted in place, as other dispatchers may deliver to the same subscription.
+		q := `UPDATE webhook_subscriptions SET failures = failures + 1, active = active AND failures + 1 < $2
+			WHERE id = $1 RETURNING active, failures`
+		return tx.QueryRow(ctx, q, sub.ID, d.cfg.DisableAfter).Scan(&sub.Active, &sub.Failures)
+	})
+	if err != nil {
+		return err
+	}
+
+	if wasActive && !sub.Active {
+		logger.WarnContext(ctx, "webhook subscription disabled after repeated failures", "failures", sub.Failures)
+	}
+	return nil
+}
+
+// backoff returns delay before next attempt after given number of attempts.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.cfg.Backoff
+	for i := 1; i < attempts && delay < d.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, d.cfg.MaxBackoff)
+}
+
+func updateDelivery(ctx context.Context, tx pgx.Tx, del *Delivery) error {
+	q := `UPDATE webhook_deliveries SET status = $2, attempts = $3, next_attempt_at = $4,
+		last_status_code = $5, last_error = $6, delivered_at = $7
+		WHERE id = $1`
+	_, err := tx.Exec(ctx, q, del.ID, del.Status, del.Attempts, del.NextAttemptAt,
+		del.LastStatusCode, del.LastError, del.DeliveredAt)
+	return err
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,52 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofrs/uuid"
+)
+
+func TestCheckURL(t *testing.T) {
+	for _, tt := range []struct {
+		url     string
+		wantErr bool
+	}{
+		{"https://8.8.8.8/hooks", false},
+		{"http://[2606:4700::1111]:8080/hooks", false},
+		{"ftp://8.8.8.8/hooks", true},
+		{"/hooks", true},
+		{"http://127.0.0.1:8080/", true},
+		{"http://[::1]/", true},
+		{"http://10.0.0.5/", true},
+		{"http://172.20.0.3/", true},
+		{"http://192.168.1.1/", true},
+		{"http://169.254.169.254/latest/meta-data", true},
+		{"http://100.64.0.1/", true},
+		{"http://[::ffff:127.0.0.1]/", true},
+		{"http://[fd00::1]/", true},
+		{"http://0.0.0.0/", true},
+	} {
+		err := CheckURL(context.Background(), tt.url)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, want error %t", tt.url, err, tt.wantErr)
+		}
+	}
+}
+
+func TestClientRefusesPrivateAddress(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("private endpoint is reached")
+	}))
+	defer receiver.Close()
+
+	sub := &Subscription{ID: uuid.Must(uuid.NewV4()), URL: receiver.URL, Secret: "secret"}
+
+	_, err := NewSender(NewClient()).Send(context.Background(), sub, &Delivery{Payload: []byte(`{}`)})
+	if !errors.Is(err, ErrPrivateAddress) {
+		t.Fatalf("err = %v, want ErrPrivateAddress", err)
+	}
+}
@@ -0,0 +1,153 @@
+package webhook
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gofrs/uuid"
+	"github.com/sunnyyssh/designing-software-cw3/shared/auth"
+	"github.com/sunnyyssh/designing-software-cw3/shared/errs"
+	"github.com/sunnyyssh/designing-software-cw3/shared/httplib"
+)
+
+const (
+	defaultDeliveriesLimit = 50
+	maxDeliveriesLimit     = 500
+)
+
+// Handler serves subscriptions of authenticated user. It must be mounted behind auth.MiddlewareUserID.
+type Handler struct {
+	store *Store
+	// events are event types service sends. Filters are checked against them.
+	events []string
+}
+
+type createSubscriptionRequest struct {
+	URL    string   `json:"url" validate:"required,max=2048"`
+	Events []string `json:"events" validate:"max=100"`
+}
+
+func NewHandler(store *Store, events ...string) *Handler {
+	httplib.RegisterBody[createSubscriptionRequest]()
+
+	return &Handler{
+		store:  store,
+		events: events,
+	}
+}
+
+// Mount registers subscription routes on r.
+func (h *Handler) Mount(r *httplib.Server) {
+	r.Use(auth.MiddlewareUserID).
+		POST("", h.CreateSubscription).
+		GET("/all", h.ListSubscriptions).
+		GET("/{subscriptionId}", h.GetSubscription).
+		DELETE("/{subscriptionId}", h.DeleteSubscription).
+		POST("/{subscriptionId}/enable", h.EnableSubscription).
+		GET("/{subscriptionId}/deliveries", h.ListDeliveries)
+}
+
+func (h *Handler) CreateSubscription(req *http.Request) (any, error) {
+	request, err := httplib.UnmarshalBody[createSubscriptionRequest](req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := CheckURL(req.Context(), request.URL); err != nil {
+		if errors.Is(err, ErrPrivateAddress) {
+			err = errors.New("must point to public internet host")
+		}
+		return nil, errs.Unprocessable(errs.FieldError{Field: "url", Message: err.Error()})
+	}
+
+	for _, pattern := range request.Events {
+		if !h.known(pattern) {
+			return nil, errs.Unprocessable(errs.FieldError{
+				Field:   "events",
+				Message: "must be one of " + strings.Join(h.events, ", ") + " or a pattern like order.*",
+			})
+		}
+	}
+
+	userID := auth.MustUserIDFromContext(req.Context())
+
+	return h.store.CreateSubscription(req.Context(), userID, request.URL, request.Events)
+}
+
+func (h *Handler) ListSubscriptions(req *http.Request) (any, error) {
+	return h.store.ListSubscriptions(req.Context(), auth.MustUserIDFromContext(req.Context()))
+}
+
+func (h *Handler) GetSubscription(req *http.Request) (any, error) {
+	id, err := subscriptionID(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.store.GetSubscription(req.Context(), auth.MustUserIDFromContext(req.Context()), id)
+}
+
+func (h *Handler) DeleteSubscription(req *http.Request) (any, error) {
+	id, err := subscriptionID(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, h.store.DeleteSubscription(req.Context(), auth.MustUserIDFromContext(req.Context()), id)
+}
+
+func (h *Handler) EnableSubscription(req *http.Request) (any, error) {
+	id, err := subscriptionID(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.store.EnableSubscription(req.Context(), auth.MustUserIDFromContext(req.Context()), id)
+}
+
+// ListDeliveries returns delivery log of subscription, latest first.
+// It's filtered with optional status and limit query parameters.
+func (h *Handler) ListDeliveries(req *http.Request) (any, error) {
+	id, err := subscriptionID(req)
+	if err != nil {
+		return nil, err
+	}
+
+	status := DeliveryStatus(req.URL.Query().Get("status"))
+	if !slices.Contains([]DeliveryStatus{"", DeliveryPending, DeliveryDelivered, DeliveryFailed}, status) {
+		return nil, errs.BadRequest("status must be one of %s, %s, %s", DeliveryPending, DeliveryDelivered, DeliveryFailed)
+	}
+
+	limit := defaultDeliveriesLimit
+	if s := req.URL.Query().Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxDeliveriesLimit {
+			return nil, errs.BadRequest("limit must be integer from 1 to %d", maxDeliveriesLimit)
+		}
+	}
+
+	return h.store.ListDeliveries(req.Context(), auth.MustUserIDFromContext(req.Context()), id, status, limit)
+}
+
+func (h *Handler) known(pattern string) bool {
+	if pattern == "*" {
+		return true
+	}
+
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return slices.ContainsFunc(h.events, func(e string) bool { return strings.HasPrefix(e, prefix) })
+	}
+
+	return slices.Contains(h.events, pattern)
+}
+
+func subscriptionID(req *http.Request) (uuid.UUID, error) {
+	id, err := uuid.FromString(req.PathValue("subscriptionId"))
+	if err != nil {
+		return uuid.Nil, errs.BadRequest("subscriptionId UUID path value must be specified: %s", err)
+	}
+	return id, nil
+}
@@ -0,0 +1,105 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+func TestSenderSignsDelivery(t *testing.T) {
+	sub := &Subscription{ID: uuid.Must(uuid.NewV4()), Secret: "secret"}
+	delivery := &Delivery{
+		EventID:   uuid.Must(uuid.NewV4()),
+		EventType: "order.finished",
+		Payload:   []byte(`{"type":"order.finished"}`),
+	}
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		err := Verify(sub.Secret, r.Header.Get(HeaderTimestamp), r.Header.Get(HeaderSignature), body, time.Minute)
+		if err != nil {
+			t.Errorf("verify delivery: %s", err)
+		}
+		if got := r.Header.Get(HeaderID); got != delivery.EventID.String() {
+			t.Errorf("%s = %q, want %q", HeaderID, got, delivery.EventID)
+		}
+		if got := r.Header.Get(HeaderEvent); got != delivery.EventType {
+			t.Errorf("%s = %q, want %q", HeaderEvent, got, delivery.EventType)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	sub.URL = receiver.URL
+
+	code, err := NewSender(receiver.Client()).Send(context.Background(), sub, delivery)
+	if err != nil {
+		t.Fatalf("send: %s", err)
+	}
+	if code != http.StatusNoContent {
+		t.Fatalf("code = %d, want %d", code, http.StatusNoContent)
+	}
+}
+
+func TestSenderFailsOnNon2xx(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	sub := &Subscription{URL: receiver.URL, Secret: "secret"}
+
+	code, err := NewSender(receiver.Client()).Send(context.Background(), sub, &Delivery{Payload: []byte(`{}`)})
+	if err == nil {
+		t.Fatal("send succeeded, want error")
+	}
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("code = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestVerifyRejectsTampering(t *testing.T) {
+	now := time.Now()
+	stale := now.Add(-time.Hour)
+	body := []byte(`{"amount":100}`)
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp time.Time
+		signature string
+		body      []byte
+	}{
+		{"tampered body", "secret", now, Sign("secret", now, body), []byte(`{"amount":999}`)},
+		{"other secret", "other", now, Sign("secret", now, body), body},
+		{"stale timestamp", "secret", stale, Sign("secret", stale, body), body},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := strconv.FormatInt(tt.timestamp.Unix(), 10)
+			if err := Verify(tt.secret, ts, tt.signature, tt.body, time.Minute); err == nil {
+				t.Error("delivery verified, want error")
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := &Dispatcher{cfg: &Config{Backoff: time.Second, MaxBackoff: 5 * time.Second}}
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if got := d.backoff(i + 1); got != w {
+			t.Errorf("backoff(%d) = %s, want %s", i+1, got, w)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sunnyyssh/designing-software-cw3/shared/errs"
+)
+
+// Headers sent with every delivery. Receivers verify signature with Verify.
+const (
+	HeaderID        = "Webhook-ID"
+	HeaderEvent     = "Webhook-Event"
+	HeaderTimestamp = "Webhook-Timestamp"
+	HeaderSignature = "Webhook-Signature"
+)
+
+// signaturePrefix tells receivers which algorithm signature is made with.
+const signaturePrefix = "sha256="
+
+type Subscription struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+	URL    string    `json:"url"`
+	// Events are event types or patterns like "order.*". Empty means all events.
+	Events []string `json:"events"`
+	// Secret signs deliveries. It's shown only when subscription is created.
+	Secret string `json:"secret,omitempty"`
+	Active bool   `json:"active"`
+	// Failures is a number of failed attempts in a row. Subscription is disabled when it gets too big.
+	Failures  int       `json:"failures"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Matches tells whether subscription wants events of given type.
+func (s *Subscription) Matches(eventType string) bool {
+	if len(s.Events) == 0 {
+		return true
+	}
+
+	for _, pattern := range s.Events {
+		if pattern == "*" || pattern == eventType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(eventType, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryFailed    DeliveryStatus = "failed"
+)
+
+type Delivery struct {
+	ID             int64           `json:"id"`
+	SubscriptionID uuid.UUID       `json:"subscription_id"`
+	EventID        uuid.UUID       `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         DeliveryStatus  `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastStatusCode *int            `json:"last_status_code,omitempty"`
+	LastError      *string         `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+}
+
+// Event is a body of delivery request.
+type Event struct {
+	ID        uuid.UUID `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// Add enqueues event for every matching active subscription of user within tx.
+// Deliveries are sent later by Dispatcher, so event is delivered only if tx is committed.
+func Add(ctx context.Context, tx pgx.Tx, userID uuid.UUID, eventType string, data any) error {
+	subs, err := listSubscriptions(ctx, tx, `WHERE user_id = $1 AND active`, userID)
+	if err != nil {
+		return err
+	}
+
+	matched := make([]uuid.UUID, 0, len(subs))
+	for _, sub := range subs {
+		if sub.Matches(eventType) {
+			matched = append(matched, sub.ID)
+		}
+	}
+	if len(matched) == 0 {
+		return nil
+	}
+
+	event := Event{
+		ID:        uuid.Must(uuid.NewV4()),
+		Type:      eventType,
+		CreatedAt: time.Now(),
+		Data:      data,
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook event: %w", err)
+	}
+
+	q := `INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload, status, next_attempt_at)
+		SELECT id, $2, $3, $4, $5, now() FROM unnest($1::uuid[]) AS id`
+	if _, err := tx.Exec(ctx, q, matched, event.ID, event.Type, payload, DeliveryPending); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Sign returns signature of delivery body sent at timestamp.
+// It's HMAC-SHA256 of "<unix timestamp>.<body>" keyed with subscription secret.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks signature and timestamp headers of received delivery.
+// Deliveries older than tolerance are rejected to prevent replays.
+func Verify(secret, timestamp, signature string, body []byte, tolerance time.Duration) error {
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp: %w", err)
+	}
+
+	ts := time.Unix(unix, 0)
+	if age := time.Since(ts); age > tolerance || age < -tolerance {
+		return fmt.Errorf("timestamp %s is out of tolerance", ts)
+	}
+
+	if !hmac.Equal([]byte(Sign(secret, ts, body)), []byte(signature)) {
+		return errors.New("signature mismatch")
+	}
+
+	return nil
+}
+
+// Store manages subscriptions and their delivery log.
+type Store struct {
+	db *pgxpool.Pool
+}
+
+func NewStore(db *pgxpool.Pool) *Store {
+	return &Store{db}
+}
+
+func (s *Store) CreateSubscription(ctx context.Context, userID uuid.UUID, url string, events []string) (*Subscription, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	if events == nil {
+		events = []string{}
+	}
+
+	sub := &Subscription{
+		ID:     uuid.Must(uuid.NewV4()),
+		UserID: userID,
+		URL:    url,
+		Events: events,
+		Secret: hex.EncodeToString(secret),
+		Active: true,
+	}
+
+	q := `INSERT INTO webhook_subscriptions (id, user_id, url, events, secret, active) VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at`
+	err := s.db.QueryRow(ctx, q, sub.ID, sub.UserID, sub.URL, sub.Events, sub.Secret, sub.Active).Scan(&sub.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return sub, nil
+}
+
+func (s *Store) GetSubscription(ctx context.Context, userID, id uuid.UUID) (*Subscription, error) {
+	subs, err := listSubscriptions(ctx, s.db, `WHERE user_id = $1 AND id = $2`, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(subs) == 0 {
+		return nil, errs.NotFound("webhook subscription with id %s not found", id)
+	}
+
+	sub := subs[0]
+	sub.Secret = ""
+	return &sub, nil
+}
+
+func (s *Store) ListSubscriptions(ctx context.Context, userID uuid.UUID) ([]Subscription, error) {
+	subs, err := listSubscriptions(ctx, s.db, `WHERE user_id = $1 ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range subs {
+		subs[i].Secret = ""
+	}
+	return subs, nil
+}
+
+// DeleteSubscription removes subscription with its delivery log.
+func (s *Store) DeleteSubscription(ctx context.Context, userID, id uuid.UUID) error {
+	tag, err := s.db.Exec(ctx, `DELETE FROM webhook_subscriptions WHERE user_id = $1 AND id = $2`, userID, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errs.NotFound("webhook subscription with id %s not found", id)
+	}
+	return nil
+}
+
+// EnableSubscription activates disabled subscription and resets its failures.
+// Deliveries failed while it was disabled aren't resent.
+func (s *Store) EnableSubscription(ctx context.Context, userID, id uuid.UUID) (*Subscription, error) {
+	tag, err := s.db.Exec(ctx, `UPDATE webhook_subscriptions SET active = true, failures = 0 WHERE user_id = $1 AND id = $2`,
+		userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, errs.NotFound("webhook subscription with id %s not found", id)
+	}
+
+	return s.GetSubscription(ctx, userID, id)
+}
+
+// ListDeliveries returns latest deliveries of subscription. Empty status means any.
+func (s *Store) ListDeliveries(
+	ctx context.Context, userID, id uuid.UUID, status DeliveryStatus, limit int,
+) ([]Delivery, error) {
+	if _, err := s.GetSubscription(ctx, userID, id); err != nil {
+		return nil, err
+	}
+
+	q := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries
+		WHERE subscription_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY id DESC LIMIT $3`
+	rows, err := s.db.Query(ctx, q, id, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]Delivery, 0)
+
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *delivery)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+const subscriptionColumns = `id, user_id, url, events, secret, active, failures, created_at`
+
+func listSubscriptions(ctx context.Context, db querier, where string, args ...any) ([]Subscription, error) {
+	rows, err := db.Query(ctx, `SELECT `+subscriptionColumns+` FROM webhook_subscriptions `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]Subscription, 0)
+
+	for rows.Next() {
+		var sub Subscription
+		err := rows.Scan(&sub.ID, &sub.UserID, &sub.URL, &sub.Events, &sub.Secret, &sub.Active, &sub.Failures, &sub.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, sub)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+const deliveryColumns = `id, subscription_id, event_id, event_type, payload, status, attempts, next_attempt_at,
+	last_status_code, last_error, created_at, delivered_at`
+
+func scanDelivery(row pgx.Row) (*Delivery, error) {
+	var d Delivery
+	err := row.Scan(
+		&d.ID, &d.SubscriptionID, &d.EventID, &d.EventType, &d.Payload, &d.Status, &d.Attempts, &d.NextAttemptAt,
+		&d.LastStatusCode, &d.LastError, &d.CreatedAt, &d.DeliveredAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}